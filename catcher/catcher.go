@@ -2,18 +2,23 @@ package catcher
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"github.com/programmingthomas/Pogo/media"
 	"github.com/programmingthomas/Pogo/pogoutils"
 	"html/template"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
-	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,9 +31,27 @@ type PodEpisode struct {
 	Author                        string
 	Summary                       string
 	PubDate                       string
+	Published                     time.Time
+	PubDateInvalid                bool
+	Discovered                    time.Time
 	Type                          string
 	Length                        time.Duration
+	Season                        int
+	Number                        int
 	Image                         string
+	ChaptersURL                   string
+	Chapters                      []media.Chapter
+	ChaptersLoaded                bool
+	Bitrate                       int
+	Artwork                       string
+	MetadataLoaded                bool
+	Transcripts                   []TranscriptLink
+	TranscriptFile                string
+	TranscriptLoaded              bool
+	//When the transcript last couldn't be fetched, so that it isn't tried again straight away
+	TranscriptFailed time.Time
+	//...and the same for the chapters file
+	ChaptersFailed time.Time
 }
 
 //A podcast feed (as stored in pogoconfig.json)
@@ -37,6 +60,8 @@ type PodFeed struct {
 	FeedURL         string
 	Site            string
 	LastRefreshed   time.Time
+	LastChecked     time.Time
+	RefreshInterval time.Duration
 	Language        string
 	PodcastEpisodes []PodEpisode
 	Copyright       string
@@ -47,124 +72,467 @@ type PodFeed struct {
 	Categories      []string
 	ID              string
 	Acronym         string
+	//Why the last refresh failed, or empty if it worked
+	LastError string
 }
 
 //A catcher is the tool that will catch the podcasts and run a scheduled loop in the
-//background
+//background. Podcasts is shared between the refresher and the server, so use AllPodcasts
+//(or hold the mutex) to read it from other goroutines
 type Catcher struct {
 	Podcasts        []PodFeed
-	RefreshInterval time.Duration
 	ConfigLocation  string
-	addFeed         chan PodFeed
+	addFeed         chan string
 	ticker          *time.Ticker
-	updatedPodcasts chan []PodFeed
+	mutex           sync.RWMutex
+	saveMutex       sync.Mutex
+	quit            chan struct{}
+	stopped         chan struct{}
+	fetchContext    context.Context
+	stopFetching    context.CancelFunc
+	downloadContext context.Context
+	stopDownloading context.CancelFunc
+	downloads       sync.WaitGroup
+	downloading     map[string]*pogoutils.RateLimiter
+	limiter         *pogoutils.RateLimiter
+	downloadingLock sync.Mutex
+	paused          bool
+	restoring       sync.RWMutex
+	offline         atomic.Bool
+	wake            chan struct{}
+	schedule        Schedule
+	deferred        bool
+	capWindow       time.Time
+	capUsed         int64
+	notifiers       []Notifier
+	notifiersLock   sync.Mutex
+	activity        map[string]Event
+	activityLock    sync.Mutex
+	loadingChapters map[string]bool
+	chaptersLock    sync.Mutex
+
+	//Download rate limits in bytes per second (0 for no limit), for all downloads together
+	//and for each download. Use SetDownloadLimits to change them
+	DownloadLimit        int64
+	EpisodeDownloadLimit int64
 }
 
 //Open a catcher from the given file (creating it if it doesn't exist) and start catching
-//podcasts
-func StartCatcher(configSaveLocation string) Catcher {
+//podcasts on the given schedule. An offline catcher only uses what it already has until
+//SetOffline(false)
+func StartCatcher(configSaveLocation string, offline bool, schedule Schedule) *Catcher {
 	if !pogoutils.FileExists("downloads/") {
 		pogoutils.CreateFolder("downloads")
 	}
-	catcher := Catcher{}
+	catcher := &Catcher{}
 	_, er := os.Stat(configSaveLocation)
 	if er == nil {
 		contents, err := ioutil.ReadFile(configSaveLocation)
 		if err == nil {
-			json.Unmarshal(contents, &catcher)
+			json.Unmarshal(contents, catcher)
+			catcher.parseSavedDates()
+			catcher.sanitiseSavedDescriptions()
 			fmt.Println("Loaded from file")
 		}
 	} else {
 		//Initial creation of a catcher
 		catcher.ConfigLocation = configSaveLocation
-		catcher.RefreshInterval = time.Minute * 30
 		catcher.SaveData()
 	}
-	//Temporary default for debugging purposes...
-	catcher.RefreshInterval = time.Minute * 2
-	catcher.addFeed = make(chan PodFeed)
-	catcher.updatedPodcasts = make(chan []PodFeed)
-	catcher.ticker = time.NewTicker(catcher.RefreshInterval)
+	if schedule.RefreshInterval <= 0 {
+		schedule.RefreshInterval = time.Minute * 30
+	}
+	catcher.schedule = schedule
+	//Start anything that didn't get downloaded before Pogo was stopped
+	catcher.deferred = true
+	catcher.addFeed = make(chan string)
+	catcher.wake = make(chan struct{}, 1)
+	catcher.offline.Store(offline)
+	catcher.quit = make(chan struct{})
+	catcher.stopped = make(chan struct{})
+	catcher.fetchContext, catcher.stopFetching = context.WithCancel(context.Background())
+	catcher.downloadContext, catcher.stopDownloading = context.WithCancel(context.Background())
+	catcher.downloading = make(map[string]*pogoutils.RateLimiter)
+	catcher.limiter = pogoutils.NewRateLimiter(catcher.DownloadLimit)
+	catcher.ticker = time.NewTicker(checkInterval)
 	go catcher.Refresher()
 	return catcher
 }
 
-//A concurrent task that will refresh podcasts
+//A concurrent task that will refresh podcasts until the catcher is stopped
 func (catcher *Catcher) Refresher() {
-	//Refresh once at the beginning
-	catcher.RefreshAllPodcasts()
+	defer close(catcher.stopped)
+	defer catcher.ticker.Stop()
+	//Catch up on anything that was missed while Pogo wasn't running
+	catcher.RefreshDuePodcasts()
+	catcher.downloadDeferred()
 	for {
 		select {
 		case <-catcher.ticker.C:
 			//Ticker fired
+			catcher.RefreshDuePodcasts()
+			catcher.downloadDeferred()
+		case <-catcher.wake:
+			//Came back online
 			catcher.RefreshAllPodcasts()
-		case u := <-catcher.addFeed:
-			//Received a new feed; add it
-			fmt.Println("Adding", u.Name)
-			catcher.Podcasts = append(catcher.Podcasts, u)
-			u.Refresh(catcher)
-			catcher.updatedPodcasts <- catcher.Podcasts
-			fmt.Println("But I got here!!!")
+			catcher.downloadDeferred()
+		case feedURL := <-catcher.addFeed:
+			//Received a new feed; refresh it so that its latest episode gets downloaded
+			fmt.Println("Adding", feedURL)
+			catcher.refreshFeed(feedURL)
+		case <-catcher.quit:
+			return
 		}
 	}
 }
 
+//Stops the catcher. Waits for the refresher to finish what it's doing and gives downloads
+//that are in progress until the timeout to finish before cancelling them (their partial files
+//are kept, so they carry on next time). Everything is saved before Stop returns
+func (catcher *Catcher) Stop(timeout time.Duration) {
+	fmt.Println("Stopping catcher")
+	close(catcher.quit)
+	catcher.downloadingLock.Lock()
+	catcher.stopFetching()
+	catcher.downloadingLock.Unlock()
+	<-catcher.stopped
+	finished := make(chan struct{})
+	go func() {
+		catcher.downloads.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(timeout):
+		fmt.Println("Cancelling downloads that are still in progress")
+		catcher.downloadingLock.Lock()
+		catcher.stopDownloading()
+		catcher.downloadingLock.Unlock()
+		<-finished
+	}
+	catcher.SaveData()
+	fmt.Println("Stopped catcher")
+}
+
+//Whether or not Stop has been called
+func (catcher *Catcher) stopping() bool {
+	select {
+	case <-catcher.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+//Gets a copy of all of the podcasts that is safe to use from any goroutine
+func (catcher *Catcher) AllPodcasts() []PodFeed {
+	catcher.mutex.RLock()
+	defer catcher.mutex.RUnlock()
+	podcasts := make([]PodFeed, len(catcher.Podcasts))
+	for i, podcast := range catcher.Podcasts {
+		podcasts[i] = podcast.copy()
+	}
+	return podcasts
+}
+
+//Gets a copy of the podcast with the given feed URL
+func (catcher *Catcher) Podcast(feedURL string) (PodFeed, bool) {
+	catcher.mutex.RLock()
+	defer catcher.mutex.RUnlock()
+	for _, podcast := range catcher.Podcasts {
+		if podcast.FeedURL == feedURL {
+			return podcast.copy(), true
+		}
+	}
+	return PodFeed{}, false
+}
+
+//Copies a podcast so that its episodes can be changed without affecting anyone else
+func (podFeed PodFeed) copy() PodFeed {
+	podFeed.PodcastEpisodes = append([]PodEpisode(nil), podFeed.PodcastEpisodes...)
+	podFeed.Categories = append([]string(nil), podFeed.Categories...)
+	return podFeed
+}
+
 //Should be run concurrently to refresh all podcasts
 func (catcher *Catcher) RefreshAllPodcasts() {
-	fmt.Println("Refreshing all podcasts", len(catcher.Podcasts))
-	for _, podcast := range catcher.Podcasts {
-		podcast.Refresh(catcher)
+	if catcher.Offline() {
+		fmt.Println("Offline, so not refreshing podcasts")
+		return
+	}
+	podcasts := catcher.AllPodcasts()
+	fmt.Println("Refreshing all podcasts", len(podcasts))
+	catcher.refreshPodcasts(podcasts)
+}
+
+//Should be run concurrently to refresh the podcasts that are due to be refreshed. Nothing is
+//refreshed during quiet hours
+func (catcher *Catcher) RefreshDuePodcasts() {
+	now := time.Now()
+	if catcher.Offline() || catcher.schedule.quiet(now) {
+		return
+	}
+	due := make([]PodFeed, 0)
+	for _, podcast := range catcher.AllPodcasts() {
+		if catcher.due(podcast, now) {
+			due = append(due, podcast)
+		}
+	}
+	if len(due) > 0 {
+		fmt.Println("Refreshing podcasts that are due", len(due))
+		catcher.refreshPodcasts(due)
+	}
+}
+
+//Refreshes some podcasts one after another, then tidies up and saves. Progress events say
+//how many have been done so far and which one is being refreshed now
+func (catcher *Catcher) refreshPodcasts(podcasts []PodFeed) {
+	total := int64(len(podcasts))
+	catcher.emit(Event{Type: EventRefreshStarted, Total: total})
+	for i, podcast := range podcasts {
+		if catcher.stopping() {
+			catcher.emit(Event{Type: EventRefreshFinished, Done: int64(i), Total: total})
+			return
+		}
+		catcher.emit(Event{Type: EventRefreshProgress, FeedURL: podcast.FeedURL, Podcast: podcast.Name, Done: int64(i), Total: total})
+		catcher.refreshFeed(podcast.FeedURL)
 	}
-	catcher.updatedPodcasts <- catcher.Podcasts
+	catcher.emit(Event{Type: EventRefreshFinished, Done: total, Total: total})
+	catcher.cleanUpImages()
 	go catcher.SaveData()
 }
 
-//Refresh an individual podcast
-func (podFeed *PodFeed) Refresh(parent *Catcher) {
-	fmt.Println("Refreshing", podFeed.Name)
-	resp, err := http.Get(podFeed.FeedURL)
+//Refreshes a single podcast and stores the result. The feed is fetched without holding the
+//mutex, then merged into the podcast as it is by then, as it may have changed in the meantime
+//(e.g. an episode finished downloading or a backup was restored)
+func (catcher *Catcher) refreshFeed(feedURL string) {
+	//Restore waits for this so that it can't be refreshed halfway through
+	catcher.restoring.RLock()
+	defer catcher.restoring.RUnlock()
+	podcast, ok := catcher.Podcast(feedURL)
+	if !ok || catcher.Offline() {
+		return
+	}
+	fmt.Println("Refreshing", podcast.Name)
+	started := time.Now()
+	feedRefreshes.Inc(podcast.ID)
+	xmlResponse, err := catcher.fetchFeed(feedURL)
+	var fetched PodFeed
 	if err == nil {
-		defer resp.Body.Close()
-		contents, err := ioutil.ReadAll(resp.Body)
-		if err == nil {
-			var xmlResponse Fetched
-			err := xml.Unmarshal(contents, &xmlResponse)
+		fetched = catcher.getPodcastFromXML(xmlResponse, feedURL)
+	}
+	feedRefreshDuration.Observe(time.Since(started).Seconds())
+	//Refreshes that were cut short by Pogo stopping or going offline aren't the feed's fault
+	cutShort := err != nil && (catcher.stopping() || catcher.Offline())
+	var added []PodEpisode
+	failing := false
+	found := false
+	catcher.mutex.Lock()
+	for i := range catcher.Podcasts {
+		//It may have been removed while it was being refreshed
+		if catcher.Podcasts[i].FeedURL == feedURL {
+			stored := &catcher.Podcasts[i]
+			stored.LastChecked = started
 			if err == nil {
-				podcast := parent.getPodcastFromXML(xmlResponse, podFeed.FeedURL)
-				for _, episode := range podcast.PodcastEpisodes {
-					added := false
-					for _, existingEpisode := range podFeed.PodcastEpisodes {
-						if existingEpisode.URL == episode.URL {
-							added = true
-							break
-						}
-					}
-					if !added {
-						fmt.Println("Added", episode.URL)
-						episode.ShouldDownloadIfNotDownloaded = true
-						podFeed.PodcastEpisodes = append(podFeed.PodcastEpisodes, episode)
-					}
-				}
+				added = stored.merge(fetched)
+				stored.LastRefreshed = time.Now()
+				stored.LastError = ""
+			} else if !cutShort {
+				//Only tell people when it starts failing, not every time it's checked
+				failing = stored.LastError == ""
+				stored.LastError = err.Error()
 			}
+			podcast = stored.copy()
+			found = true
+			break
 		}
 	}
-	for _, episode := range podFeed.PodcastEpisodes {
+	catcher.mutex.Unlock()
+	if !found {
+		return
+	}
+	if err != nil {
+		fmt.Println("Error refreshing", podcast.Name, err)
+		feedRefreshErrors.Inc(podcast.ID)
+	}
+	if failing {
+		catcher.emit(Event{Type: EventFeedError, FeedURL: podcast.FeedURL, Podcast: podcast.Name, Error: err.Error()})
+	}
+	for _, episode := range added {
+		fmt.Println("Added", episode.URL)
+		episodesDiscovered.Inc(podcast.ID)
+		catcher.emit(Event{Type: EventNewEpisode, FeedURL: podcast.FeedURL, Podcast: podcast.Name, EpisodeURL: episode.URL, Episode: episode.Title})
+	}
+	for _, episode := range podcast.PodcastEpisodes {
 		if episode.ShouldDownloadIfNotDownloaded && !episode.Downloaded() {
-			_, filename := path.Split(episode.URL)
-			episode.ShouldDownloadIfNotDownloaded = false
-			go pogoutils.Download(episode.URL, "downloads/"+filename)
+			catcher.download(episode.URL, episode.DownloadedFilename())
+		}
+	}
+	catcher.cacheImages(&podcast)
+	//Episodes that were downloaded last time can be looked at now
+	for _, episode := range podcast.PodcastEpisodes {
+		if !catcher.stopping() && episode.Downloaded() && episode.needsInspecting() {
+			catcher.inspectDownload(episode.URL)
+		}
+	}
+}
+
+//Merges what a feed says now into a podcast, returning the episodes that are new
+func (podFeed *PodFeed) merge(podcast PodFeed) []PodEpisode {
+	added := make([]PodEpisode, 0)
+	//Podcasts move between categories (and ones saved by old versions don't have any)
+	podFeed.Categories = podcast.Categories
+	//...and change their artwork, which gets cached under its new URL (see ImageKey)
+	podFeed.Image = podcast.Image
+	for _, episode := range podcast.PodcastEpisodes {
+		found := false
+		for i, existingEpisode := range podFeed.PodcastEpisodes {
+			if existingEpisode.URL == episode.URL {
+				found = true
+				//Feeds can add chapters and transcripts to episodes after they're released
+				if existingEpisode.ChaptersURL == "" && episode.ChaptersURL != "" {
+					podFeed.PodcastEpisodes[i].ChaptersURL = episode.ChaptersURL
+					podFeed.PodcastEpisodes[i].ChaptersLoaded = false
+				}
+				if len(existingEpisode.Transcripts) == 0 {
+					podFeed.PodcastEpisodes[i].Transcripts = episode.Transcripts
+				}
+				podFeed.PodcastEpisodes[i].Image = episode.Image
+				//...or number them
+				if episode.Season != 0 || episode.Number != 0 {
+					podFeed.PodcastEpisodes[i].Season = episode.Season
+					podFeed.PodcastEpisodes[i].Number = episode.Number
+				}
+				//...and fix their dates
+				if existingEpisode.PubDate != episode.PubDate {
+					podFeed.PodcastEpisodes[i].PubDate = episode.PubDate
+					podFeed.PodcastEpisodes[i].Published = episode.Published
+					podFeed.PodcastEpisodes[i].PubDateInvalid = episode.PubDateInvalid
+				}
+				break
+			}
+		}
+		if !found {
+			episode.ShouldDownloadIfNotDownloaded = true
+			podFeed.PodcastEpisodes = append(podFeed.PodcastEpisodes, episode)
+			added = append(added, episode)
+		}
+	}
+	return added
+}
+
+//Fetches and parses a feed. Fetches are abandoned when the catcher is stopped, and never
+//happen while it is offline
+func (catcher *Catcher) fetchFeed(feedURL string) (Fetched, error) {
+	var xmlResponse Fetched
+	ctx, err := catcher.fetching()
+	if err != nil {
+		return xmlResponse, err
+	}
+	request, err := http.NewRequestWithContext(ctx, "GET", feedURL, nil)
+	if err != nil {
+		return xmlResponse, err
+	}
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return xmlResponse, err
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return xmlResponse, err
+	}
+	err = xml.Unmarshal(contents, &xmlResponse)
+	return xmlResponse, err
+}
+
+//Starts downloading an episode in the background, unless it is already being downloaded or
+//the schedule doesn't allow it yet
+func (catcher *Catcher) download(url, saveFile string) {
+	_, each := catcher.DownloadLimits()
+	catcher.downloadingLock.Lock()
+	defer catcher.downloadingLock.Unlock()
+	if _, ok := catcher.downloading[url]; ok || catcher.paused || catcher.stopping() || catcher.Offline() || !catcher.canDownload() {
+		return
+	}
+	limiter := pogoutils.NewRateLimiter(each)
+	//Going offline swaps this for a new one, so this download keeps the one it started with
+	ctx := catcher.downloadContext
+	catcher.downloading[url] = limiter
+	catcher.downloads.Add(1)
+	downloadQueueDepth.Add(1)
+	go func() {
+		defer catcher.downloads.Done()
+		event := catcher.episodeEvent(EventDownloadStarted, url)
+		catcher.emit(event)
+		err := downloadEpisode(ctx, url, saveFile, func(reader io.Reader, offset, size int64) io.Reader {
+			reader = capReader{reader: reader, catcher: catcher}
+			reader = limiter.Reader(ctx, reader)
+			//Every download shares the overall limit
+			reader = catcher.limiter.Reader(ctx, reader)
+			event.Type = EventDownloadProgress
+			event.Done = offset
+			event.Total = max(size, 0)
+			return &progressReader{reader: reader, catcher: catcher, event: event}
+		})
+		catcher.downloadingLock.Lock()
+		delete(catcher.downloading, url)
+		catcher.downloadingLock.Unlock()
+		if err == nil {
+			catcher.emit(catcher.episodeEvent(EventDownloaded, url))
+			catcher.inspectDownload(url)
+		} else if errors.Is(err, pogoutils.ErrDownloadPaused) || (ctx.Err() != nil && !catcher.stopping()) {
+			//It ran out of cap or Pogo went offline, so it'll carry on later
+			catcher.emit(catcher.episodeEvent(EventDownloadDeferred, url))
+		} else if ctx.Err() == nil {
+			event := catcher.episodeEvent(EventDownloadFailed, url)
+			event.Error = err.Error()
+			catcher.emit(event)
 		}
+	}()
+}
+
+//Downloads an episode now (or as soon as the schedule allows), even if it wasn't going to be
+//downloaded automatically
+func (catcher *Catcher) DownloadEpisode(episodeURL string) error {
+	if catcher.Offline() {
+		return ErrOffline
 	}
+	var episode PodEpisode
+	catcher.mutex.Lock()
+	for i := range catcher.Podcasts {
+		for j := range catcher.Podcasts[i].PodcastEpisodes {
+			if catcher.Podcasts[i].PodcastEpisodes[j].URL == episodeURL {
+				catcher.Podcasts[i].PodcastEpisodes[j].ShouldDownloadIfNotDownloaded = true
+				episode = catcher.Podcasts[i].PodcastEpisodes[j]
+			}
+		}
+	}
+	catcher.mutex.Unlock()
+	if episode.URL == "" {
+		return ErrNoEpisode
+	}
+	go catcher.SaveData()
+	if !episode.Downloaded() {
+		catcher.download(episode.URL, episode.DownloadedFilename())
+	}
+	return nil
 }
 
-//Should be run concurrently. Will save all data to the configuration file
+//Should be run concurrently. Will save all data to the configuration file. The file is
+//written to a temporary file first so that it is never left half written
 func (catcher *Catcher) SaveData() {
+	catcher.saveMutex.Lock()
+	defer catcher.saveMutex.Unlock()
+	catcher.mutex.RLock()
 	b, jsonErr := json.MarshalIndent(catcher, "", "    ")
+	catcher.mutex.RUnlock()
 	if jsonErr == nil {
-		file, fileErr := os.Create(catcher.ConfigLocation)
+		fileErr := ioutil.WriteFile(catcher.ConfigLocation+".tmp", b, 0644)
+		if fileErr == nil {
+			fileErr = os.Rename(catcher.ConfigLocation+".tmp", catcher.ConfigLocation)
+		}
 		if fileErr == nil {
-			defer file.Close()
-			file.Write(b)
 			fmt.Println("Saved catcher data to", catcher.ConfigLocation)
 		} else {
 			fmt.Println("Error creating file", fileErr)
@@ -174,39 +542,92 @@ func (catcher *Catcher) SaveData() {
 	}
 }
 
+//Replaces every podcast and the download limits with ones saved by SaveData (on this machine
+//or another one), like when a backup is restored, and saves them. Nothing is refreshed or
+//downloaded while this happens; downloads that were going on are cancelled and carry on
+//afterwards if the restored podcasts still want them
+func (catcher *Catcher) Restore(contents []byte) error {
+	loaded := &Catcher{}
+	if err := json.Unmarshal(contents, loaded); err != nil {
+		return err
+	}
+	loaded.parseSavedDates()
+	loaded.sanitiseSavedDescriptions()
+	catcher.restoring.Lock()
+	defer catcher.restoring.Unlock()
+	catcher.downloadingLock.Lock()
+	catcher.paused = true
+	catcher.cancelDownloads()
+	catcher.downloadingLock.Unlock()
+	catcher.downloads.Wait()
+	defer func() {
+		catcher.downloadingLock.Lock()
+		catcher.paused = false
+		catcher.downloadingLock.Unlock()
+	}()
+	catcher.mutex.Lock()
+	catcher.Podcasts = loaded.Podcasts
+	catcher.mutex.Unlock()
+	if err := catcher.SetDownloadLimits(loaded.DownloadLimit, loaded.EpisodeDownloadLimit); err != nil {
+		return err
+	}
+	catcher.SaveData()
+	return nil
+}
+
 //Should be run concurrently. Subscribe to a podcast feed
 func (catcher *Catcher) AddPodcastFeed(feedURL string) {
 	//Firstly check if the podcast feed has already been added
-	for _, podcast := range catcher.Podcasts {
-		if podcast.FeedURL == feedURL {
-			return
-		}
+	if _, ok := catcher.Podcast(feedURL); ok {
+		return
 	}
-	resp, err := http.Get(feedURL)
+	xmlResponse, err := catcher.fetchFeed(feedURL)
 	if err == nil {
-		defer resp.Body.Close()
-		contents, err := ioutil.ReadAll(resp.Body)
-		if err == nil {
-			var xmlResponse Fetched
-			err := xml.Unmarshal(contents, &xmlResponse)
-			if err == nil {
-				catcher.AddPodcast(xmlResponse, feedURL)
-			}
-		}
+		catcher.AddPodcast(xmlResponse, feedURL)
+	} else {
+		fmt.Println("Error adding", feedURL, err)
 	}
-
 }
 
 //Will add a podcast given by AddPodcastFeed. Do not call directly
 func (catcher *Catcher) AddPodcast(xml Fetched, feedURL string) {
 	podcast := catcher.getPodcastFromXML(xml, feedURL)
-	mostRecentEpisode := podcast.PodcastEpisodes[0]
-	mostRecentEpisode.ShouldDownloadIfNotDownloaded = true
-	podcast.PodcastEpisodes[0] = mostRecentEpisode
+	if len(podcast.PodcastEpisodes) > 0 {
+		mostRecentEpisode := podcast.PodcastEpisodes[0]
+		mostRecentEpisode.ShouldDownloadIfNotDownloaded = true
+		podcast.PodcastEpisodes[0] = mostRecentEpisode
+	}
+	catcher.mutex.Lock()
+	for _, existing := range catcher.Podcasts {
+		if existing.FeedURL == feedURL {
+			//Somebody else added it while we were fetching it
+			catcher.mutex.Unlock()
+			return
+		}
+	}
 	catcher.Podcasts = append(catcher.Podcasts, podcast)
+	catcher.mutex.Unlock()
 	go catcher.SaveData()
 	//Ensures that changes are reflected in the download queue
-	catcher.addFeed <- podcast
+	select {
+	case catcher.addFeed <- feedURL:
+	case <-catcher.quit:
+	}
+}
+
+//Should be run concurrently. Stops catching a podcast feed (episodes that have already been
+//downloaded are left in the downloads folder)
+func (catcher *Catcher) RemovePodcastFeed(feedURL string) {
+	catcher.mutex.Lock()
+	remaining := make([]PodFeed, 0, len(catcher.Podcasts))
+	for _, podcast := range catcher.Podcasts {
+		if podcast.FeedURL != feedURL {
+			remaining = append(remaining, podcast)
+		}
+	}
+	catcher.Podcasts = remaining
+	catcher.mutex.Unlock()
+	catcher.SaveData()
 }
 
 //Gets a PodFeed object from some fetched XML
@@ -217,6 +638,7 @@ func (catcher *Catcher) getPodcastFromXML(xml Fetched, feedURL string) PodFeed {
 	podcast.FeedURL = feedURL
 	podcast.Site = channel.Link
 	podcast.LastRefreshed = time.Now()
+	podcast.LastChecked = podcast.LastRefreshed
 	podcast.Language = channel.Language
 	podcast.Copyright = channel.Copyright
 	podcast.Subtitle = channel.Subtitle
@@ -239,14 +661,28 @@ func (catcher *Catcher) getPodcastFromXML(xml Fetched, feedURL string) PodFeed {
 
 	for _, item := range channel.Items {
 		episode := PodEpisode{}
-		episode.Description = template.HTML(item.Description)
+		episode.Description = SanitiseHTML(item.Description)
 		episode.Title = item.Title
 		episode.Author = item.Author
 		episode.Image = item.Image.Href
 		episode.PubDate = item.PubDate
+		if episode.PubDate == "" {
+			episode.PubDate = item.Date
+		}
+		episode.parsePubDate()
+		//Episodes that are already known keep the time they were first found when they're merged
+		episode.Discovered = podcast.LastRefreshed
 		episode.URL = item.Enclosure.URL
 		episode.Type = item.Enclosure.Type
 		episode.Length = ParseDuration(item.Duration)
+		//Both are 0 if the feed doesn't number its episodes
+		episode.Season, _ = strconv.Atoi(strings.TrimSpace(item.Season))
+		episode.Number, _ = strconv.Atoi(strings.TrimSpace(item.Episode))
+		episode.ChaptersURL = item.Chapters.URL
+		episode.Transcripts = make([]TranscriptLink, 0, len(item.Transcripts))
+		for _, transcript := range item.Transcripts {
+			episode.Transcripts = append(episode.Transcripts, TranscriptLink{URL: transcript.URL, Type: transcript.Type, Language: transcript.Language})
+		}
 		podcast.PodcastEpisodes = append(podcast.PodcastEpisodes, episode)
 	}
 	return podcast
@@ -255,6 +691,8 @@ func (catcher *Catcher) getPodcastFromXML(xml Fetched, feedURL string) PodFeed {
 //Checks to see if an acronym is unique and if not appends a number
 func (catcher *Catcher) UniqueIDForPodcast(podcastAcronym string) string {
 	suffix := 1
+	catcher.mutex.RLock()
+	defer catcher.mutex.RUnlock()
 	for _, podcast := range catcher.Podcasts {
 		if podcast.Acronym == podcastAcronym {
 			suffix++
@@ -280,79 +718,61 @@ func Acronym(original string) string {
 }
 
 //Parses a time string into a duration (13:37 -> 13 * time.Minute + 37 * time.Second,
-//however 10:20:30 -> 10 * time.Hour + 20 * time.Minute + 10 * time.Second)
+//however 10:20:30 -> 10 * time.Hour + 20 * time.Minute + 10 * time.Second). Plain seconds
+//(817) and fractions of a second (13:37.5) work too. Anything else is 0
 func ParseDuration(dur string) time.Duration {
-	split := strings.Split(dur, ":")
-	var d int64
-	d = 0
-	//Has seconds component
-	if len(split) >= 1 {
-		t, _ := strconv.ParseInt(split[len(split)-1], 0, 0)
-		d += t * int64(time.Second)
-	}
-	//Has minutes components
-	if len(split) >= 2 {
-		t, _ := strconv.ParseInt(split[len(split)-2], 0, 0)
-		d += t * int64(time.Minute)
+	split := strings.Split(strings.TrimSpace(dur), ":")
+	if len(split) > 3 {
+		return 0
 	}
-	//Has hour component (unlikely?)
-	if len(split) >= 3 {
-		t, _ := strconv.ParseInt(split[len(split)-3], 0, 0)
-		d += t * int64(time.Hour)
+	var seconds float64
+	for _, part := range split {
+		t, err := strconv.ParseFloat(part, 64)
+		if err != nil || t < 0 {
+			return 0
+		}
+		seconds = seconds*60 + t
 	}
-	return time.Duration(d)
+	return time.Duration(seconds * float64(time.Second))
 }
 
-//Removes content in HTML tags
-func (episode PodEpisode) PlainTextDescription() template.HTML {
-	regex, _ := regexp.Compile("<[^>]*>")
-	return template.HTML(regex.ReplaceAll([]byte(episode.Description), []byte("")))
+//Gets the description as plain text (which templates will escape, so it doesn't need to be
+//safe HTML)
+func (episode PodEpisode) PlainTextDescription() string {
+	return HTMLToText(string(episode.Description))
 }
 
 //First 50 characters of the description
-func (episode PodEpisode) PlainTextDescriptionBeginning() template.HTML {
-	return template.HTML(episode.PlainTextDescription()[0:50]) + "..."
+func (episode PodEpisode) PlainTextDescriptionBeginning() string {
+	description := []rune(strings.Join(strings.Fields(episode.PlainTextDescription()), " "))
+	if len(description) <= 50 {
+		return string(description)
+	}
+	return string(description[:50]) + "..."
 }
 
-//Gets a date like 'Today' or 'Yesterday' to represent the date
+//Gets a date like 'Today' or 'Yesterday' to represent the date. This is always in English;
+//the web interface shows dates in each person's own locale instead
 func (episode PodEpisode) PubDateText() string {
+	if !episode.HasPubDate() {
+		return "Unknown date"
+	}
 	now := time.Now()
 	then := episode.ReleaseDate()
 	if now.Day() == then.Day() && now.Month() == then.Month() && now.Year() == then.Year() {
 		return "Today"
 	}
 	yesterday := now.AddDate(0, 0, -1)
-	if yesterday.Day() == then.Day() && yesterday.Month() == yesterday.Month() && now.Year() == then.Year() {
+	if yesterday.Day() == then.Day() && yesterday.Month() == then.Month() && yesterday.Year() == then.Year() {
 		return "Yesterday"
 	}
-	//Need to add locale
 	return fmt.Sprintf("%d/%d/%d", then.Month(), then.Day(), then.Year())
 }
 
-//Parses the date from the XML data
+//Gets when the episode was published. This is the zero time if the feed didn't say or we
+//couldn't understand it (see HasPubDate)
 func (episode PodEpisode) ReleaseDate() time.Time {
-	then, err := time.Parse(time.RFC822Z, episode.PubDate)
-	if err != nil {
-		then, err = time.Parse(time.RFC1123Z, episode.PubDate)
-		if err != nil {
-			//Fail
-			then = time.Now()
-		}
-	}
-	return then
-}
-
-//Ensures that the current thread is using the most up to date version of the podcasts
-//(resolves concurrency issues?!)
-func (catcher *Catcher) UpdateAll() {
-	fmt.Println("Update all")
-	select {
-	case podcasts := <-catcher.updatedPodcasts:
-		catcher.Podcasts = podcasts
-	default:
-		break
-	}
-	fmt.Println("Exit update all")
+	return episode.Published
 }
 
 //Determines whether or not this episode is an audio episode
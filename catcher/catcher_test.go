@@ -0,0 +1,52 @@
+package catcher
+
+import (
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	stored := PodFeed{
+		Image: "https://example.com/old.png",
+		PodcastEpisodes: []PodEpisode{
+			{URL: "https://example.com/1.mp3", Title: "One", Image: "https://example.com/1-old.png", Length: 60, MetadataLoaded: true},
+		},
+	}
+	fetched := PodFeed{
+		Image:      "https://example.com/new.png",
+		Categories: []string{"Technology"},
+		PodcastEpisodes: []PodEpisode{
+			{URL: "https://example.com/2.mp3", Title: "Two"},
+			{URL: "https://example.com/1.mp3", Title: "One", Image: "https://example.com/1-new.png", Season: 1, Number: 1},
+		},
+	}
+	added := stored.merge(fetched)
+	if len(added) != 1 || added[0].URL != "https://example.com/2.mp3" || !added[0].ShouldDownloadIfNotDownloaded {
+		t.Errorf("added %+v, want just episode 2 marked for downloading", added)
+	}
+	if stored.Image != fetched.Image {
+		t.Errorf("podcast image is %q, want %q", stored.Image, fetched.Image)
+	}
+	if len(stored.Categories) != 1 || stored.Categories[0] != "Technology" {
+		t.Errorf("categories are %v, want [Technology]", stored.Categories)
+	}
+	if len(stored.PodcastEpisodes) != 2 {
+		t.Fatalf("there are %d episodes, want 2", len(stored.PodcastEpisodes))
+	}
+	tests := []struct {
+		field string
+		got   interface{}
+		want  interface{}
+	}{
+		{"Image", stored.PodcastEpisodes[0].Image, "https://example.com/1-new.png"},
+		{"Season", stored.PodcastEpisodes[0].Season, 1},
+		{"Number", stored.PodcastEpisodes[0].Number, 1},
+		//What was loaded from the download is kept
+		{"Length", stored.PodcastEpisodes[0].Length, PodEpisode{Length: 60}.Length},
+		{"MetadataLoaded", stored.PodcastEpisodes[0].MetadataLoaded, true},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("episode 1's %s is %v, want %v", test.field, test.got, test.want)
+		}
+	}
+}
@@ -47,10 +47,24 @@ type Item struct {
 		Href string `xml:"href,attr"`
 	} `xml:"image"`
 	PubDate string `xml:"pubDate"`
+	//dc:date, which some feeds use instead of pubDate
+	Date string `xml:"date"`
 	Duration string `xml:"duration"`
+	//itunes:season and itunes:episode
+	Season string `xml:"season"`
+	Episode string `xml:"episode"`
 	Enclosure struct {
 		URL string `xml:"url,attr"`
 		Length int64 `xml:"length,attr"`
 		Type string `xml:"type,attr"`
 	} `xml:"enclosure"`
+	Chapters struct {
+		URL string `xml:"url,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"chapters"`
+	Transcripts []struct {
+		URL string `xml:"url,attr"`
+		Type string `xml:"type,attr"`
+		Language string `xml:"language,attr"`
+	} `xml:"transcript"`
 }
\ No newline at end of file
@@ -0,0 +1,147 @@
+package catcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/programmingthomas/Pogo/media"
+	"io"
+	"net/http"
+	"time"
+)
+
+//Chapters JSON files are small, so anything bigger than this is probably not one
+const maxChaptersSize = 1 << 20
+
+//Transcripts can be fetched while somebody is waiting for a page, so don't wait forever
+const fileTimeout = 15 * time.Second
+
+//How long to wait before trying to fetch chapters again when they couldn't be fetched
+const chaptersRetryInterval = 6 * time.Hour
+
+//Gets the chapters for an episode. Chapters from a podcast:chapters file in the feed are
+//preferred because they can have links and images, otherwise they are read from the
+//downloaded episode. Chapters that haven't been loaded yet are loaded in the background so
+//that nobody waits for them, and there aren't any until they have been
+func (catcher *Catcher) Chapters(episodeURL string) []media.Chapter {
+	episode, ok := catcher.episode(episodeURL)
+	if !ok {
+		return []media.Chapter{}
+	}
+	if episode.ChaptersLoaded {
+		return episode.Chapters
+	}
+	if episode.chaptersDue() {
+		catcher.loadChaptersLater(episodeURL)
+	}
+	return []media.Chapter{}
+}
+
+//Whether or not there's any point loading the chapters now: they haven't been loaded yet, and
+//either the episode has been downloaded or there's a chapters file that didn't fail recently
+func (episode PodEpisode) chaptersDue() bool {
+	if episode.ChaptersLoaded {
+		return false
+	}
+	return episode.Downloaded() || (episode.ChaptersURL != "" && time.Since(episode.ChaptersFailed) >= chaptersRetryInterval)
+}
+
+//Loads the chapters for an episode on another goroutine, unless they're already being loaded
+func (catcher *Catcher) loadChaptersLater(episodeURL string) {
+	catcher.chaptersLock.Lock()
+	defer catcher.chaptersLock.Unlock()
+	if catcher.loadingChapters == nil {
+		catcher.loadingChapters = make(map[string]bool)
+	}
+	if catcher.loadingChapters[episodeURL] {
+		return
+	}
+	catcher.loadingChapters[episodeURL] = true
+	go func() {
+		defer func() {
+			catcher.chaptersLock.Lock()
+			delete(catcher.loadingChapters, episodeURL)
+			catcher.chaptersLock.Unlock()
+		}()
+		episode, ok := catcher.episode(episodeURL)
+		if !ok || episode.ChaptersLoaded {
+			return
+		}
+		chapters, loaded := catcher.loadChapters(episode)
+		if !loaded {
+			return
+		}
+		catcher.updateEpisode(episodeURL, func(stored *PodEpisode) {
+			//The feed may have given it new chapters since
+			if !stored.ChaptersLoaded && stored.ChaptersURL == episode.ChaptersURL {
+				stored.Chapters = chapters
+				stored.ChaptersLoaded = true
+			}
+		})
+	}()
+}
+
+//Loads the chapters for an episode without storing them. Returns false if they couldn't be
+//loaded yet (e.g. the episode hasn't been downloaded) so that we try again later. A chapters
+//file that can't be fetched (or is empty) isn't tried again until chaptersRetryInterval has
+//passed
+func (catcher *Catcher) loadChapters(episode PodEpisode) ([]media.Chapter, bool) {
+	if episode.ChaptersURL != "" && time.Since(episode.ChaptersFailed) >= chaptersRetryInterval {
+		chapters, err := catcher.fetchChapters(episode.ChaptersURL)
+		if err == nil && len(chapters) > 0 {
+			return chapters, true
+		}
+		if err != nil && err != ErrOffline {
+			fmt.Println("Error fetching chapters for", episode.Title, err)
+		}
+		//It's not the file's fault if Pogo went offline or stopped halfway through
+		if !catcher.Offline() && !catcher.stopping() {
+			catcher.updateEpisode(episode.URL, func(stored *PodEpisode) {
+				stored.ChaptersFailed = time.Now()
+			})
+		}
+	}
+	if episode.Downloaded() {
+		chapters, err := media.ReadChapters(episode.DownloadedFilename())
+		if err != nil {
+			fmt.Println("Error reading chapters from", episode.DownloadedFilename(), err)
+			chapters = []media.Chapter{}
+		}
+		return chapters, true
+	}
+	return nil, false
+}
+
+//Fetches and parses a podcast:chapters JSON file
+func (catcher *Catcher) fetchChapters(chaptersURL string) ([]media.Chapter, error) {
+	contents, _, err := catcher.fetchFile(chaptersURL, maxChaptersSize)
+	if err != nil {
+		return nil, err
+	}
+	return media.ParseChaptersJSON(contents)
+}
+
+//Fetches a small file that goes along with an episode (like its chapters or transcript),
+//returning its contents and type
+func (catcher *Catcher) fetchFile(fileURL string, maxSize int64) ([]byte, string, error) {
+	fetchContext, err := catcher.fetching()
+	if err != nil {
+		return nil, "", err
+	}
+	ctx, cancel := context.WithTimeout(fetchContext, fileTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, "GET", fileURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.New(resp.Status)
+	}
+	contents, err := io.ReadAll(io.LimitReader(resp.Body, maxSize))
+	return contents, resp.Header.Get("Content-Type"), err
+}
@@ -0,0 +1,25 @@
+package catcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChaptersDue(t *testing.T) {
+	tests := []struct {
+		name    string
+		episode PodEpisode
+		want    bool
+	}{
+		{"already loaded", PodEpisode{ChaptersLoaded: true, ChaptersURL: "https://example.com/c.json"}, false},
+		{"no chapters file and not downloaded", PodEpisode{URL: "https://example.com/missing.mp3"}, false},
+		{"chapters file", PodEpisode{ChaptersURL: "https://example.com/c.json"}, true},
+		{"chapters file failed recently", PodEpisode{ChaptersURL: "https://example.com/c.json", ChaptersFailed: time.Now().Add(-time.Minute)}, false},
+		{"chapters file failed a while ago", PodEpisode{ChaptersURL: "https://example.com/c.json", ChaptersFailed: time.Now().Add(-chaptersRetryInterval - time.Minute)}, true},
+	}
+	for _, test := range tests {
+		if got := test.episode.chaptersDue(); got != test.want {
+			t.Errorf("%s: chaptersDue() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package catcher
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+//Feeds are meant to use RFC 822 dates, but plenty of them don't. These are the layouts we
+//try once the weekday has been taken off and any named time zone has been swapped for an
+//offset
+var dateLayouts = []string{
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04 -0700",
+	"2 Jan 06 15:04:05 -0700",
+	"2 Jan 06 15:04 -0700",
+	"2 Jan 2006 15:04:05 -07:00",
+	"2 Jan 2006 15:04:05",
+	"2 Jan 2006 15:04",
+	"2 Jan 2006",
+	"Jan 2 2006 15:04:05 -0700",
+	"Jan 2 2006 15:04 -0700",
+	"Jan 2 2006 15:04:05",
+	"Jan 2 2006",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"2006/01/02 15:04:05",
+	"2006/01/02",
+}
+
+//Offsets for the time zone names that turn up in feeds. Go only knows the offset of a named
+//zone if it happens to be the local one, so it can't be left to time.Parse
+var zoneOffsets = map[string]string{
+	"GMT": "+0000", "UT": "+0000", "UTC": "+0000", "Z": "+0000", "WET": "+0000",
+	"EST": "-0500", "EDT": "-0400",
+	"CST": "-0600", "CDT": "-0500",
+	"MST": "-0700", "MDT": "-0600",
+	"PST": "-0800", "PDT": "-0700",
+	"AKST": "-0900", "AKDT": "-0800",
+	"HST": "-1000",
+	"AST": "-0400", "ADT": "-0300",
+	"NST": "-0330", "NDT": "-0230",
+	"BST": "+0100", "IST": "+0100", "WEST": "+0100",
+	"CET": "+0100", "CEST": "+0200", "MET": "+0100", "MEST": "+0200",
+	"EET": "+0200", "EEST": "+0300",
+	"MSK": "+0300",
+	"JST": "+0900", "KST": "+0900",
+	"AWST": "+0800", "ACST": "+0930", "ACDT": "+1030", "AEST": "+1000", "AEDT": "+1100",
+	"NZST": "+1200", "NZDT": "+1300",
+}
+
+//Full month names and other spellings that feeds use, and the short names Go wants
+var monthNames = map[string]string{
+	"january": "Jan", "february": "Feb", "march": "Mar", "april": "Apr", "may": "May",
+	"june": "Jun", "july": "Jul", "august": "Aug", "september": "Sep", "sept": "Sep",
+	"october": "Oct", "november": "Nov", "december": "Dec",
+	"jan": "Jan", "feb": "Feb", "mar": "Mar", "apr": "Apr", "jun": "Jun", "jul": "Jul",
+	"aug": "Aug", "sep": "Sep", "oct": "Oct", "nov": "Nov", "dec": "Dec",
+}
+
+//Parses a date from a feed as leniently as we can. Returns false if it still can't be made
+//sense of
+func ParseDate(text string) (time.Time, bool) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return time.Time{}, false
+	}
+	//The standard formats come first because they're the most common
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123, time.RFC822Z, time.RFC3339} {
+		if then, err := time.Parse(layout, text); err == nil && knownZone(layout, text) {
+			return then, true
+		}
+	}
+	normalised := normaliseDate(text)
+	for _, layout := range dateLayouts {
+		if then, err := time.Parse(layout, normalised); err == nil {
+			return then, true
+		}
+	}
+	return time.Time{}, false
+}
+
+//time.Parse makes up a zero offset for zone names it doesn't know, so only trust it with
+//named zones when the name is GMT or UTC
+func knownZone(layout, text string) bool {
+	if layout != time.RFC1123 {
+		return true
+	}
+	return strings.HasSuffix(text, "GMT") || strings.HasSuffix(text, "UTC")
+}
+
+//Tidies up a date so that it matches one of dateLayouts: the weekday (which is often
+//misspelt or wrong) is removed, months get their short names, named time zones become
+//offsets and commas, dots and extra spaces go
+func normaliseDate(text string) string {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ','
+	})
+	normalised := make([]string, 0, len(fields))
+	for i, field := range fields {
+		trimmed := strings.TrimSuffix(field, ".")
+		lower := strings.ToLower(trimmed)
+		if month, ok := monthNames[lower]; ok {
+			normalised = append(normalised, month)
+			continue
+		}
+		//GMT+0100 and the like
+		if upper := strings.ToUpper(trimmed); len(upper) > 4 && (strings.HasPrefix(upper, "GMT") || strings.HasPrefix(upper, "UTC")) && (upper[3] == '+' || upper[3] == '-') {
+			normalised = append(normalised, trimmed[3:])
+			continue
+		}
+		if isWord(trimmed) {
+			if offset, ok := zoneOffsets[strings.ToUpper(trimmed)]; ok && i > 0 {
+				normalised = append(normalised, offset)
+			}
+			//Weekdays and zones we don't know about are left out
+			continue
+		}
+		//1st, 2nd, 3rd and so on
+		for _, suffix := range []string{"st", "nd", "rd", "th"} {
+			if strings.HasSuffix(lower, suffix) && isNumber(lower[:len(lower)-len(suffix)]) {
+				trimmed = trimmed[:len(trimmed)-len(suffix)]
+				break
+			}
+		}
+		//Times like 9:05 need a leading zero
+		if len(trimmed) > 1 && trimmed[1] == ':' {
+			trimmed = "0" + trimmed
+		}
+		normalised = append(normalised, trimmed)
+	}
+	return strings.Join(normalised, " ")
+}
+
+func isWord(text string) bool {
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return text != ""
+}
+
+func isNumber(text string) bool {
+	for _, r := range text {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return text != ""
+}
+
+//Works out when an episode was published from the date in its feed
+func (episode *PodEpisode) parsePubDate() {
+	episode.Published, episode.PubDateInvalid = time.Time{}, false
+	if episode.PubDate == "" {
+		return
+	}
+	published, ok := ParseDate(episode.PubDate)
+	if !ok {
+		episode.PubDateInvalid = true
+		return
+	}
+	episode.Published = published
+}
+
+//Whether or not we know when an episode was published
+func (episode PodEpisode) HasPubDate() bool {
+	return !episode.Published.IsZero()
+}
+
+//Fills in the publication dates of episodes that were saved before they were parsed when
+//feeds were fetched
+func (catcher *Catcher) parseSavedDates() {
+	for i := range catcher.Podcasts {
+		for j := range catcher.Podcasts[i].PodcastEpisodes {
+			episode := &catcher.Podcasts[i].PodcastEpisodes[j]
+			if !episode.HasPubDate() && !episode.PubDateInvalid {
+				episode.parsePubDate()
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package catcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	want := time.Date(2024, time.March, 5, 14, 30, 0, 0, time.UTC)
+	tests := []struct {
+		text string
+		want time.Time
+		ok   bool
+	}{
+		//The standard formats
+		{"Tue, 05 Mar 2024 14:30:00 +0000", want, true},
+		{"Tue, 05 Mar 2024 14:30:00 GMT", want, true},
+		{"Tue, 05 Mar 2024 09:30:00 -0500", want, true},
+		{"2024-03-05T14:30:00Z", want, true},
+		{"2024-03-05T15:30:00+01:00", want, true},
+		//Named zones Go doesn't know the offset of
+		{"Tue, 05 Mar 2024 09:30:00 EST", want, true},
+		{"Tue, 05 Mar 2024 06:30:00 PST", want, true},
+		{"Tue, 05 Mar 2024 15:30:00 CET", want, true},
+		{"Wed, 06 Mar 2024 01:30:00 AEDT", want, true},
+		{"Tue, 05 Mar 2024 14:30:00 UT", want, true},
+		{"Tue, 05 Mar 2024 15:30:00 GMT+0100", want, true},
+		//Misspelt or wrong weekdays, long month names and missing commas
+		{"Tues, 05 Mar 2024 14:30:00 +0000", want, true},
+		{"Sunday, 05 Mar 2024 14:30:00 +0000", want, true},
+		{"Tuesday, 5 March 2024 14:30:00 GMT", want, true},
+		{"Tue 05 Mar 2024 14:30:00 +0000", want, true},
+		{"Tue, 5 Sept. 2024 14:30:00 +0000", time.Date(2024, time.September, 5, 14, 30, 0, 0, time.UTC), true},
+		{"March 5th, 2024", time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC), true},
+		{"Mar 5 2024 14:30:00 +0000", want, true},
+		//Times without seconds or a leading zero, two digit years and no zone at all
+		{"Tue, 05 Mar 2024 14:30 +0000", want, true},
+		{"Tue, 05 Mar 24 14:30:00 +0000", want, true},
+		{"05 Mar 2024 9:30:00 -0500", want, true},
+		{"5 Mar 2024 14:30:00", want, true},
+		{"  Tue, 05 Mar 2024 14:30:00 +0000\n", want, true},
+		//ISO 8601 and friends
+		{"2024-03-05T14:30:00.123Z", want.Add(123 * time.Millisecond), true},
+		{"2024-03-05T14:30:00+0000", want, true},
+		{"2024-03-05 14:30:00", want, true},
+		{"2024-03-05", time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC), true},
+		{"2024/03/05", time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC), true},
+		//Things that aren't dates
+		{"", time.Time{}, false},
+		{"   ", time.Time{}, false},
+		{"yesterday", time.Time{}, false},
+		{"Tue, 32 Mar 2024 14:30:00 +0000", time.Time{}, false},
+		{"Tue, 05 Foo 2024 14:30:00 +0000", time.Time{}, false},
+		{"2024-13-05", time.Time{}, false},
+		{"14:30:00", time.Time{}, false},
+	}
+	for _, test := range tests {
+		got, ok := ParseDate(test.text)
+		if ok != test.ok {
+			t.Errorf("ParseDate(%q) ok = %v, want %v", test.text, ok, test.ok)
+			continue
+		}
+		if ok && !got.Equal(test.want) {
+			t.Errorf("ParseDate(%q) = %v, want %v", test.text, got, test.want.UTC())
+		}
+	}
+}
+
+func TestNormaliseDate(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Tue, 05 Mar 2024 14:30:00 +0000", "05 Mar 2024 14:30:00 +0000"},
+		{"Tuesday, 5 March 2024 9:30:00 EST", "5 Mar 2024 09:30:00 -0500"},
+		{"Tue, 5 Sept. 2024 14:30:00 GMT+0100", "5 Sep 2024 14:30:00 +0100"},
+		{"March 5th, 2024", "Mar 5 2024"},
+		{"1st jan 2024", "1 Jan 2024"},
+		{"Tue, 05 Mar 2024 14:30:00 XYZ", "05 Mar 2024 14:30:00"},
+		//A zone name on its own at the start is dropped rather than made into an offset
+		{"EST 05 Mar 2024", "05 Mar 2024"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := normaliseDate(test.text); got != test.want {
+			t.Errorf("normaliseDate(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestParsePubDate(t *testing.T) {
+	tests := []struct {
+		pubDate string
+		has     bool
+		invalid bool
+	}{
+		{"Tue, 05 Mar 2024 14:30:00 +0000", true, false},
+		{"", false, false},
+		{"sometime last week", false, true},
+	}
+	for _, test := range tests {
+		episode := PodEpisode{PubDate: test.pubDate, PubDateInvalid: true}
+		episode.parsePubDate()
+		if episode.HasPubDate() != test.has || episode.PubDateInvalid != test.invalid {
+			t.Errorf("parsePubDate(%q) gave HasPubDate %v and PubDateInvalid %v, want %v and %v", test.pubDate, episode.HasPubDate(), episode.PubDateInvalid, test.has, test.invalid)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package catcher
+
+import (
+	"io"
+	"sort"
+	"time"
+)
+
+//The kinds of things that happen in the catcher that people might want to know about
+const (
+	EventNewEpisode     = "episode.new"
+	EventDownloaded     = "download.complete"
+	EventDownloadFailed = "download.failed"
+	EventFeedError      = "feed.error"
+)
+
+//Every kind of event that people can be notified about, in the order they're usually shown in
+var EventTypes = []string{EventNewEpisode, EventDownloaded, EventDownloadFailed, EventFeedError}
+
+//Events that say how the catcher is getting on with refreshing and downloading. There are lots
+//of these, so they're only shown on pages as they happen rather than being sent anywhere
+const (
+	EventRefreshStarted   = "refresh.start"
+	EventRefreshProgress  = "refresh.progress"
+	EventRefreshFinished  = "refresh.complete"
+	EventDownloadStarted  = "download.start"
+	EventDownloadProgress = "download.progress"
+	EventDownloadDeferred = "download.deferred"
+)
+
+//How often download progress is sent out
+const progressInterval = time.Second
+
+//Whether or not people can be notified about a kind of event
+func Notifiable(eventType string) bool {
+	for _, t := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+//Something that happened to a podcast or one of its episodes. Feed errors don't have an
+//episode, and only failures have an error
+type Event struct {
+	Type       string
+	Time       time.Time
+	FeedURL    string
+	Podcast    string
+	EpisodeURL string `json:",omitempty"`
+	Episode    string `json:",omitempty"`
+	Error      string `json:",omitempty"`
+	//How far through a refresh (in podcasts) or a download (in bytes) the catcher is. Total is
+	//0 when it isn't known, like downloads from servers that don't say how big they are
+	Done    int64 `json:",omitempty"`
+	Total   int64 `json:",omitempty"`
+	Percent int   `json:",omitempty"`
+}
+
+//Something that wants to hear about events, like webhooks or email. Notify is called on its
+//own goroutine, so it can take as long as it needs
+type Notifier interface {
+	Notify(event Event)
+}
+
+//Lets a plain function be used as a Notifier
+type NotifierFunc func(event Event)
+
+func (f NotifierFunc) Notify(event Event) {
+	f(event)
+}
+
+//Adds something to tell about events from now on
+func (catcher *Catcher) AddNotifier(notifier Notifier) {
+	catcher.notifiersLock.Lock()
+	defer catcher.notifiersLock.Unlock()
+	catcher.notifiers = append(catcher.notifiers, notifier)
+}
+
+//Tells every notifier about an event
+func (catcher *Catcher) emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Total > 0 {
+		event.Percent = int(event.Done * 100 / event.Total)
+	}
+	eventsEmitted.Inc(event.Type)
+	catcher.track(event)
+	catcher.notifiersLock.Lock()
+	notifiers := append([]Notifier{}, catcher.notifiers...)
+	catcher.notifiersLock.Unlock()
+	for _, notifier := range notifiers {
+		go notifier.Notify(event)
+	}
+}
+
+//Makes an event about an episode, filling in the podcast it's from
+func (catcher *Catcher) episodeEvent(eventType, episodeURL string) Event {
+	event := Event{Type: eventType, EpisodeURL: episodeURL}
+	catcher.mutex.RLock()
+	defer catcher.mutex.RUnlock()
+	for _, podcast := range catcher.Podcasts {
+		for _, episode := range podcast.PodcastEpisodes {
+			if episode.URL == episodeURL {
+				event.FeedURL = podcast.FeedURL
+				event.Podcast = podcast.Name
+				event.Episode = episode.Title
+				return event
+			}
+		}
+	}
+	return event
+}
+
+//Keeps track of the refreshes and downloads that are going on, so that pages that are opened
+//halfway through one can be told about it
+func (catcher *Catcher) track(event Event) {
+	catcher.activityLock.Lock()
+	defer catcher.activityLock.Unlock()
+	if catcher.activity == nil {
+		catcher.activity = make(map[string]Event)
+	}
+	switch event.Type {
+	case EventRefreshStarted, EventRefreshProgress:
+		catcher.activity[""] = event
+	case EventRefreshFinished:
+		delete(catcher.activity, "")
+	case EventDownloadStarted, EventDownloadProgress:
+		catcher.activity[event.EpisodeURL] = event
+	case EventDownloaded, EventDownloadFailed, EventDownloadDeferred:
+		delete(catcher.activity, event.EpisodeURL)
+	}
+}
+
+//Gets the latest event about each refresh and download that is going on, oldest first
+func (catcher *Catcher) Activity() []Event {
+	catcher.activityLock.Lock()
+	defer catcher.activityLock.Unlock()
+	events := make([]Event, 0, len(catcher.activity))
+	for _, event := range catcher.activity {
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events
+}
+
+//Sends out download progress events as an episode is read, at most once every
+//progressInterval
+type progressReader struct {
+	reader  io.Reader
+	catcher *Catcher
+	event   Event
+	sent    time.Time
+}
+
+func (reader *progressReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	reader.event.Done += int64(n)
+	if now := time.Now(); now.Sub(reader.sent) >= progressInterval {
+		reader.sent = now
+		reader.event.Time = now
+		reader.catcher.emit(reader.event)
+	}
+	return n, err
+}
@@ -0,0 +1,195 @@
+package catcher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/programmingthomas/Pogo/media"
+	"github.com/programmingthomas/Pogo/pogoutils"
+	"os"
+	"strconv"
+)
+
+//Where podcast and episode images are cached. Each image gets a folder named after its key
+//with the original image and JPEG thumbnails in it
+const imageFolder = "downloads/images"
+
+//The sizes (in pixels, along the longest side) of the thumbnails that are made for each image
+var ThumbnailSizes = []int{64, 150, 300, 600}
+
+//Artwork can be big, but not this big
+const maxImageSize = 20 << 20
+
+//How many images are fetched for a podcast each time it is refreshed. Anything else is
+//fetched when somebody first looks at it
+const imagesPerRefresh = 25
+
+var ErrNoImage = errors.New("no such image")
+
+//Gets the key that an image is cached under. This changes when a feed's image URL changes,
+//so new artwork is fetched straight away
+func ImageKey(imageURL string) string {
+	sum := sha256.Sum256([]byte(imageURL))
+	return hex.EncodeToString(sum[:16])
+}
+
+//Keys are always hex, which keeps them safe to use in paths
+func validImageKey(key string) bool {
+	_, err := hex.DecodeString(key)
+	return len(key) == 32 && err == nil
+}
+
+func imageFile(key, name string) string {
+	return imageFolder + "/" + key + "/" + name
+}
+
+//Gets the file for a cached image and its content type, fetching it if it hasn't been cached
+//yet. size picks the smallest thumbnail that is at least that big; 0 gets the original. Only
+//images that are used by a podcast, episode or chapter can be fetched, so this can't be used
+//to fetch anything else
+func (catcher *Catcher) Image(key string, size int) (string, string, error) {
+	if !validImageKey(key) {
+		return "", "", ErrNoImage
+	}
+	original := imageFile(key, "original")
+	if !pogoutils.FileExists(original) {
+		imageURL, ok := catcher.imageURLs()[key]
+		if !ok {
+			return "", "", ErrNoImage
+		}
+		if err := catcher.cacheImage(imageURL); err != nil {
+			return "", "", err
+		}
+	}
+	if size > 0 {
+		thumbnail := ThumbnailSizes[len(ThumbnailSizes)-1]
+		for i := len(ThumbnailSizes) - 1; i >= 0 && ThumbnailSizes[i] >= size; i-- {
+			thumbnail = ThumbnailSizes[i]
+		}
+		if filename := imageFile(key, strconv.Itoa(thumbnail)+".jpg"); pogoutils.FileExists(filename) {
+			return filename, "image/jpeg", nil
+		}
+	}
+	//Old versions of Pogo cached whatever they were given, which could be anything (like a
+	//web page), so originals are checked before they're served
+	format, err := cachedImageFormat(original)
+	if err != nil {
+		fmt.Println("Removing cached image that isn't an image", key, err)
+		os.RemoveAll(imageFolder + "/" + key)
+		return "", "", ErrNoImage
+	}
+	return original, "image/" + format, nil
+}
+
+//Works out what kind of image a cached original is
+func cachedImageFormat(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return media.ImageFormat(file)
+}
+
+//Gets all of the images that are in use, by their keys
+func (catcher *Catcher) imageURLs() map[string]string {
+	urls := make(map[string]string)
+	add := func(imageURL string) {
+		if imageURL != "" {
+			urls[ImageKey(imageURL)] = imageURL
+		}
+	}
+	for _, podcast := range catcher.AllPodcasts() {
+		add(podcast.Image)
+		for _, episode := range podcast.PodcastEpisodes {
+			add(episode.Image)
+			for _, chapter := range episode.Chapters {
+				add(chapter.Image)
+			}
+		}
+	}
+	return urls
+}
+
+//Whether or not an image has been cached
+func ImageCached(imageURL string) bool {
+	return pogoutils.FileExists(imageFile(ImageKey(imageURL), "original"))
+}
+
+//Writes a file via a temporary file so that nobody sees half of it
+func writeFileAtomically(filename string, data []byte) error {
+	if err := os.WriteFile(filename+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+//Fetches an image and makes its thumbnails. Anything that isn't a JPEG, PNG or GIF we can
+//decode isn't cached, as the original is served from our own site
+func (catcher *Catcher) cacheImage(imageURL string) error {
+	data, _, err := catcher.fetchFile(imageURL, maxImageSize)
+	if err != nil {
+		return err
+	}
+	img, _, err := media.DecodeImage(data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNoImage, err)
+	}
+	key := ImageKey(imageURL)
+	if err := os.MkdirAll(imageFolder+"/"+key, 0755); err != nil {
+		return err
+	}
+	//Each thumbnail is made from the one before it, which is much quicker than going back
+	//to the original every time
+	for i := len(ThumbnailSizes) - 1; i >= 0; i-- {
+		thumbnail := media.Thumbnail(img, ThumbnailSizes[i])
+		encoded, err := media.EncodeThumbnail(thumbnail)
+		if err == nil {
+			err = writeFileAtomically(imageFile(key, strconv.Itoa(ThumbnailSizes[i])+".jpg"), encoded)
+		}
+		if err != nil {
+			return err
+		}
+		img = thumbnail
+	}
+	//The original goes last because it is what says that the image has been cached
+	return writeFileAtomically(imageFile(key, "original"), data)
+}
+
+//Caches the images for a podcast and its episodes that haven't been cached yet
+func (catcher *Catcher) cacheImages(podFeed *PodFeed) {
+	urls := []string{podFeed.Image}
+	for _, episode := range podFeed.PodcastEpisodes {
+		urls = append(urls, episode.Image)
+	}
+	fetched := 0
+	for _, imageURL := range urls {
+		if imageURL == "" || ImageCached(imageURL) {
+			continue
+		}
+		if fetched >= imagesPerRefresh || catcher.stopping() || catcher.Offline() {
+			return
+		}
+		fetched++
+		if err := catcher.cacheImage(imageURL); err != nil {
+			fmt.Println("Error caching image", imageURL, err)
+		}
+	}
+}
+
+//Removes cached images that aren't used any more (e.g. because a feed changed its artwork or
+//was removed)
+func (catcher *Catcher) cleanUpImages() {
+	entries, err := os.ReadDir(imageFolder)
+	if err != nil {
+		return
+	}
+	inUse := catcher.imageURLs()
+	for _, entry := range entries {
+		if _, ok := inUse[entry.Name()]; !ok {
+			fmt.Println("Removing unused image", entry.Name())
+			os.RemoveAll(imageFolder + "/" + entry.Name())
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package catcher
+
+import (
+	"errors"
+	"fmt"
+)
+
+//Gets the download rate limits in bytes per second: for all downloads together and for each
+//download. 0 means there isn't a limit
+func (catcher *Catcher) DownloadLimits() (int64, int64) {
+	catcher.mutex.RLock()
+	defer catcher.mutex.RUnlock()
+	return catcher.DownloadLimit, catcher.EpisodeDownloadLimit
+}
+
+//Changes the download rate limits (in bytes per second, 0 for no limit). Downloads that are
+//already in progress slow down or speed up straight away
+func (catcher *Catcher) SetDownloadLimits(total, each int64) error {
+	if total < 0 || each < 0 {
+		return errors.New("download limits can't be negative")
+	}
+	catcher.mutex.Lock()
+	catcher.DownloadLimit = total
+	catcher.EpisodeDownloadLimit = each
+	catcher.mutex.Unlock()
+	catcher.limiter.SetRate(total)
+	catcher.downloadingLock.Lock()
+	for _, limiter := range catcher.downloading {
+		limiter.SetRate(each)
+	}
+	catcher.downloadingLock.Unlock()
+	fmt.Println("Download limits are now", total, "bytes per second in total and", each, "for each download")
+	go catcher.SaveData()
+	return nil
+}
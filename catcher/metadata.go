@@ -0,0 +1,161 @@
+package catcher
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/programmingthomas/Pogo/media"
+	"os"
+	"path"
+	"time"
+)
+
+//Where artwork that was embedded in downloaded episodes is saved
+const artworkFolder = "downloads/artwork"
+
+//Finds a copy of an episode from its URL
+func (catcher *Catcher) episode(episodeURL string) (PodEpisode, bool) {
+	catcher.mutex.RLock()
+	defer catcher.mutex.RUnlock()
+	for _, podcast := range catcher.Podcasts {
+		for _, episode := range podcast.PodcastEpisodes {
+			if episode.URL == episodeURL {
+				return episode, true
+			}
+		}
+	}
+	return PodEpisode{}, false
+}
+
+//Changes a stored episode and saves the result
+func (catcher *Catcher) updateEpisode(episodeURL string, update func(*PodEpisode)) {
+	catcher.mutex.Lock()
+	for i := range catcher.Podcasts {
+		for j := range catcher.Podcasts[i].PodcastEpisodes {
+			if catcher.Podcasts[i].PodcastEpisodes[j].URL == episodeURL {
+				update(&catcher.Podcasts[i].PodcastEpisodes[j])
+			}
+		}
+	}
+	catcher.mutex.Unlock()
+	go catcher.SaveData()
+}
+
+//Looks at an episode that has just been downloaded. This is done on a copy so that the mutex
+//isn't held while reading the file, so only what was loaded is copied back (the rest of the
+//episode may have changed in the meantime)
+func (catcher *Catcher) inspectDownload(episodeURL string) {
+	episode, ok := catcher.episode(episodeURL)
+	if !ok {
+		return
+	}
+	inspected := episode
+	catcher.inspect(&inspected)
+	catcher.updateEpisode(episodeURL, func(stored *PodEpisode) {
+		//The feed may have given it new chapters since
+		if inspected.ChaptersLoaded && !stored.ChaptersLoaded && stored.ChaptersURL == episode.ChaptersURL {
+			stored.Chapters = inspected.Chapters
+			stored.ChaptersLoaded = true
+		}
+		if inspected.MetadataLoaded && !stored.MetadataLoaded {
+			if stored.Length == 0 {
+				stored.Length = inspected.Length
+			}
+			if stored.Title == "" {
+				stored.Title = inspected.Title
+			}
+			stored.Bitrate = inspected.Bitrate
+			stored.Artwork = inspected.Artwork
+			stored.MetadataLoaded = true
+		}
+		if inspected.TranscriptLoaded && !stored.TranscriptLoaded {
+			stored.TranscriptFile = inspected.TranscriptFile
+			stored.TranscriptLoaded = true
+		}
+	})
+}
+
+//Whether or not there's anything about a downloaded episode that hasn't been loaded yet
+func (episode PodEpisode) needsInspecting() bool {
+	return !episode.ChaptersLoaded || !episode.MetadataLoaded || episode.transcriptDue()
+}
+
+//Loads anything about a downloaded episode that hasn't been loaded yet
+func (catcher *Catcher) inspect(episode *PodEpisode) {
+	if !episode.ChaptersLoaded {
+		if chapters, ok := catcher.loadChapters(*episode); ok {
+			episode.Chapters = chapters
+			episode.ChaptersLoaded = true
+		}
+	}
+	if !episode.MetadataLoaded {
+		episode.fillFromMetadata()
+	}
+	if episode.transcriptDue() {
+		if filename, ok := catcher.loadTranscript(*episode); ok {
+			episode.TranscriptFile = filename
+			episode.TranscriptLoaded = true
+		}
+	}
+}
+
+//Fills in whatever the feed left out (the length, the title and artwork) from the downloaded
+//file. Feeds often don't say how long episodes are, or say it in a way we can't understand
+func (episode *PodEpisode) fillFromMetadata() {
+	metadata, err := media.ReadMetadata(episode.DownloadedFilename())
+	if err != nil {
+		fmt.Println("Error reading metadata from", episode.DownloadedFilename(), err)
+	}
+	//Don't try again; a file that can't be read now won't be readable later either
+	episode.MetadataLoaded = true
+	if episode.Length == 0 {
+		episode.Length = metadata.Duration.Round(time.Second)
+	}
+	if episode.Title == "" {
+		episode.Title = metadata.Title
+	}
+	episode.Bitrate = metadata.Bitrate
+	if len(metadata.Artwork) > 0 {
+		artwork, err := saveArtwork(episode.DownloadedFilename(), metadata)
+		if err != nil {
+			fmt.Println("Error saving artwork for", episode.Title, err)
+			return
+		}
+		episode.Artwork = artwork
+	}
+}
+
+//Saves embedded artwork next to the downloads so that it can be served without reading
+//through the episode every time. The extension comes from what the artwork really is rather
+//than what the tags say, as it decides what it's served as
+func saveArtwork(downloadedFilename string, metadata media.Metadata) (string, error) {
+	format, err := media.ImageFormat(bytes.NewReader(metadata.Artwork))
+	if err != nil {
+		return "", err
+	}
+	extension := "." + format
+	if format == "jpeg" {
+		extension = ".jpg"
+	}
+	_, filename := path.Split(downloadedFilename)
+	artwork := artworkFolder + "/" + filename + extension
+	if err := os.MkdirAll(artworkFolder, 0755); err != nil {
+		return "", err
+	}
+	return artwork, os.WriteFile(artwork, metadata.Artwork, 0644)
+}
+
+//Gets the bitrate like 128 kbps
+func (episode PodEpisode) BitrateText() string {
+	if episode.Bitrate <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d kbps", (episode.Bitrate+500)/1000)
+}
+
+//Gets the length like 13:37 or 1:02:03
+func (episode PodEpisode) LengthText() string {
+	if episode.Length <= 0 {
+		return ""
+	}
+	return media.FormatTime(episode.Length)
+}
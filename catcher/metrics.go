@@ -0,0 +1,56 @@
+package catcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/programmingthomas/Pogo/pogoutils"
+	"io"
+	"time"
+)
+
+//Prometheus metrics for the catcher. These are served up by the server on /metrics
+var (
+	feedRefreshes = pogoutils.NewCounter("pogo_feed_refreshes_total",
+		"Number of times a podcast feed has been refreshed.", "feed")
+	feedRefreshErrors = pogoutils.NewCounter("pogo_feed_refresh_errors_total",
+		"Number of podcast feed refreshes that failed.", "feed")
+	feedRefreshDuration = pogoutils.NewHistogram("pogo_feed_refresh_duration_seconds",
+		"Time taken to fetch and parse a podcast feed.",
+		[]float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60})
+	episodesDiscovered = pogoutils.NewCounter("pogo_episodes_discovered_total",
+		"Number of new episodes found when refreshing feeds.", "feed")
+	downloadBytes = pogoutils.NewCounter("pogo_download_bytes_total",
+		"Number of bytes of episodes downloaded.")
+	downloadDuration = pogoutils.NewHistogram("pogo_download_duration_seconds",
+		"Time taken to download an episode.",
+		[]float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600})
+	downloadFailures = pogoutils.NewCounter("pogo_download_failures_total",
+		"Number of episode downloads that failed.")
+	eventsEmitted = pogoutils.NewCounter("pogo_events_total",
+		"Number of events sent to notifiers.", "type")
+	downloadQueueDepth = pogoutils.NewGauge("pogo_download_queue_depth",
+		"Number of episode downloads that are queued or in progress.")
+	_ = pogoutils.NewGaugeFunc("pogo_downloads_disk_bytes",
+		"Disk space used by the downloads folder.",
+		func() float64 { return float64(pogoutils.DirSize("downloads")) })
+)
+
+//Downloads an episode, keeping track of how long it took and how big it was. Should be run
+//concurrently
+func downloadEpisode(ctx context.Context, url, saveFile string, filter func(io.Reader, int64, int64) io.Reader) error {
+	started := time.Now()
+	written, err := pogoutils.DownloadThrough(ctx, url, saveFile, filter)
+	downloadQueueDepth.Add(-1)
+	downloadBytes.Add(float64(written))
+	if err != nil {
+		fmt.Println("Error downloading", url, err)
+		//Downloads that will carry on later haven't failed
+		if ctx.Err() == nil && !errors.Is(err, pogoutils.ErrDownloadPaused) {
+			downloadFailures.Inc()
+		}
+		return err
+	}
+	downloadDuration.Observe(time.Since(started).Seconds())
+	return nil
+}
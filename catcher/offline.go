@@ -0,0 +1,60 @@
+package catcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+//Returned instead of fetching anything while the catcher is offline
+var ErrOffline = errors.New("Pogo is offline")
+
+//Whether or not the catcher is offline. When it is offline nothing is refreshed, downloaded
+//or fetched, so Pogo only uses what it already has
+func (catcher *Catcher) Offline() bool {
+	return catcher.offline.Load()
+}
+
+//Takes the catcher offline or brings it back online. Going offline cancels the refreshes and
+//downloads that are going on, and the downloads carry on where they stopped when it comes back
+//online. Podcasts are refreshed straight away then too so that it catches up on anything it missed
+func (catcher *Catcher) SetOffline(offline bool) {
+	catcher.downloadingLock.Lock()
+	defer catcher.downloadingLock.Unlock()
+	if catcher.offline.Swap(offline) == offline {
+		return
+	}
+	if offline {
+		fmt.Println("Going offline")
+		catcher.stopFetching()
+		//A fresh one for when it comes back online
+		catcher.fetchContext, catcher.stopFetching = context.WithCancel(context.Background())
+		catcher.cancelDownloads()
+		return
+	}
+	fmt.Println("Going online")
+	select {
+	case catcher.wake <- struct{}{}:
+	default:
+		//A refresh is already waiting to happen
+	}
+}
+
+//Gets what fetches should use to be cancelled when the catcher stops or goes offline, or
+//ErrOffline if it's offline already
+func (catcher *Catcher) fetching() (context.Context, error) {
+	catcher.downloadingLock.Lock()
+	defer catcher.downloadingLock.Unlock()
+	if catcher.Offline() {
+		return nil, ErrOffline
+	}
+	return catcher.fetchContext, nil
+}
+
+//Cancels the downloads that are going on so that they carry on later (see downloadDeferred).
+//The caller must hold downloadingLock
+func (catcher *Catcher) cancelDownloads() {
+	catcher.stopDownloading()
+	catcher.downloadContext, catcher.stopDownloading = context.WithCancel(context.Background())
+	catcher.deferred = true
+}
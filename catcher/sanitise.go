@@ -0,0 +1,488 @@
+package catcher
+
+import (
+	"html"
+	"html/template"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+//Descriptions come from other people's feeds, so they're cleaned up before anyone sees them.
+//Only the tags (and attributes) below are kept. Tags in droppedTags go along with everything
+//inside them, and any other tag goes but its text stays
+var allowedTags = map[string][]string{
+	"a":          {"href", "title"},
+	"abbr":       {"title"},
+	"b":          nil,
+	"blockquote": nil,
+	"br":         nil,
+	"code":       nil,
+	"dd":         nil,
+	"del":        nil,
+	"div":        nil,
+	"dl":         nil,
+	"dt":         nil,
+	"em":         nil,
+	"figcaption": nil,
+	"figure":     nil,
+	"h1":         nil,
+	"h2":         nil,
+	"h3":         nil,
+	"h4":         nil,
+	"h5":         nil,
+	"h6":         nil,
+	"hr":         nil,
+	"i":          nil,
+	"img":        {"src", "alt", "title", "width", "height"},
+	"ins":        nil,
+	"li":         nil,
+	"ol":         {"start"},
+	"p":          nil,
+	"pre":        nil,
+	"q":          nil,
+	"s":          nil,
+	"small":      nil,
+	"span":       nil,
+	"strike":     nil,
+	"strong":     nil,
+	"sub":        nil,
+	"sup":        nil,
+	"table":      nil,
+	"tbody":      nil,
+	"td":         {"colspan", "rowspan"},
+	"tfoot":      nil,
+	"th":         {"colspan", "rowspan"},
+	"thead":      nil,
+	"tr":         nil,
+	"u":          nil,
+	"ul":         nil,
+}
+
+var droppedTags = map[string]bool{
+	"applet": true, "audio": true, "button": true, "canvas": true, "embed": true, "form": true,
+	"frame": true, "frameset": true, "head": true, "iframe": true, "link": true, "math": true,
+	"meta": true, "noembed": true, "noframes": true, "noscript": true, "object": true,
+	"script": true, "select": true, "style": true, "svg": true, "template": true,
+	"textarea": true, "title": true, "video": true, "xmp": true,
+}
+
+//Tags that never have an end tag
+var voidTags = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true,
+	"wbr": true,
+}
+
+//Tags whose contents are text rather than HTML, so a < inside them doesn't start a tag
+var rawTextTags = map[string]bool{
+	"iframe": true, "noembed": true, "noframes": true, "noscript": true, "script": true,
+	"style": true, "textarea": true, "title": true, "xmp": true,
+}
+
+//Tags that are ended by the start of another tag when they're the last one open
+var implicitlyClosed = map[string]map[string]bool{
+	"dd": {"dd": true, "dt": true},
+	"dt": {"dd": true, "dt": true},
+	"li": {"li": true},
+	"p":  {"blockquote": true, "div": true, "dl": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "ol": true, "p": true, "pre": true, "table": true, "ul": true},
+	"td": {"td": true, "th": true, "tr": true},
+	"th": {"td": true, "th": true, "tr": true},
+	"tr": {"tr": true},
+}
+
+//Attributes holding numbers, which have to actually be numbers
+var numberAttributes = map[string]bool{
+	"colspan": true, "height": true, "rowspan": true, "start": true, "width": true,
+}
+
+//Images from these hosts (and their subdomains) are only there to count who has read the
+//description or to show adverts
+var trackingHosts = []string{
+	"doubleclick.net", "feedburner.com", "feedsportal.com", "google-analytics.com",
+	"pixel.wp.com", "quantserve.com", "scorecardresearch.com", "stats.wordpress.com",
+	"statcounter.com",
+}
+
+const (
+	textToken = iota
+	startTagToken
+	endTagToken
+)
+
+type htmlToken struct {
+	kind int
+	//Text tokens are still escaped
+	text string
+	//Lower case
+	name  string
+	attrs []htmlAttr
+}
+
+type htmlAttr struct {
+	//Lower case
+	name string
+	//Unescaped
+	value string
+}
+
+//Gets an attribute's value, or "" if the tag doesn't have it
+func (token htmlToken) attr(name string) string {
+	for _, attr := range token.attrs {
+		if attr.name == name {
+			return attr.value
+		}
+	}
+	return ""
+}
+
+//Splits some HTML into text, start tags and end tags. Comments, doctypes and the contents of
+//script (and other raw text) tags are left out. Like a browser, this never fails: anything
+//that doesn't look like a tag is text
+func tokenizeHTML(text string) []htmlToken {
+	tokens := make([]htmlToken, 0)
+	for text != "" {
+		lt := strings.IndexByte(text, '<')
+		if lt < 0 {
+			tokens = append(tokens, htmlToken{kind: textToken, text: text})
+			break
+		}
+		if lt > 0 {
+			tokens = append(tokens, htmlToken{kind: textToken, text: text[:lt]})
+			text = text[lt:]
+		}
+		switch {
+		case strings.HasPrefix(text, "<!--"):
+			end := strings.Index(text[4:], "-->")
+			if end < 0 {
+				return tokens
+			}
+			text = text[4+end+3:]
+		case strings.HasPrefix(text, "<!") || strings.HasPrefix(text, "<?"):
+			end := strings.IndexByte(text, '>')
+			if end < 0 {
+				return tokens
+			}
+			text = text[end+1:]
+		case len(text) > 2 && text[1] == '/' && isASCIILetter(text[2]):
+			end := strings.IndexByte(text, '>')
+			if end < 0 {
+				return tokens
+			}
+			name, _ := tagName(text[2:end])
+			tokens = append(tokens, htmlToken{kind: endTagToken, name: name})
+			text = text[end+1:]
+		case len(text) > 1 && isASCIILetter(text[1]):
+			token, rest, ok := parseStartTag(text[1:])
+			if !ok {
+				return tokens
+			}
+			tokens = append(tokens, token)
+			text = rest
+			if rawTextTags[token.name] {
+				//Skip straight to the end tag
+				end := indexFold(text, "</"+token.name)
+				if end < 0 {
+					return tokens
+				}
+				text = text[end:]
+			}
+		default:
+			tokens = append(tokens, htmlToken{kind: textToken, text: "&lt;"})
+			text = text[1:]
+		}
+	}
+	return tokens
+}
+
+//Finds the first place that substr (which has to be ASCII) is in text, ignoring case, or -1.
+//text isn't lower cased first, as that can change how long it is
+func indexFold(text, substr string) int {
+	for i := 0; i+len(substr) <= len(text); i++ {
+		if strings.EqualFold(text[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isASCIILetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func isHTMLSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+}
+
+//Reads a tag or attribute name from the start of text and returns the rest
+func tagName(text string) (string, string) {
+	i := 0
+	for i < len(text) && !isHTMLSpace(text[i]) && text[i] != '/' && text[i] != '>' && text[i] != '=' {
+		i++
+	}
+	return strings.ToLower(text[:i]), text[i:]
+}
+
+//Parses a start tag (without its <) and returns what comes after it. Returns false if the
+//tag never ends
+func parseStartTag(text string) (htmlToken, string, bool) {
+	token := htmlToken{kind: startTagToken}
+	token.name, text = tagName(text)
+	for {
+		for text != "" && (isHTMLSpace(text[0]) || text[0] == '/') {
+			text = text[1:]
+		}
+		if text == "" {
+			return token, "", false
+		}
+		if text[0] == '>' {
+			return token, text[1:], true
+		}
+		var attr htmlAttr
+		attr.name, text = tagName(text)
+		if attr.name == "" {
+			//A stray = or quote
+			text = text[1:]
+			continue
+		}
+		for text != "" && isHTMLSpace(text[0]) {
+			text = text[1:]
+		}
+		if text != "" && text[0] == '=' {
+			text = text[1:]
+			for text != "" && isHTMLSpace(text[0]) {
+				text = text[1:]
+			}
+			var value string
+			if text != "" && (text[0] == '"' || text[0] == '\'') {
+				end := strings.IndexByte(text[1:], text[0])
+				if end < 0 {
+					return token, "", false
+				}
+				value, text = text[1:end+1], text[end+2:]
+			} else {
+				i := 0
+				for i < len(text) && !isHTMLSpace(text[i]) && text[i] != '>' {
+					i++
+				}
+				value, text = text[:i], text[i:]
+			}
+			attr.value = html.UnescapeString(value)
+		}
+		token.attrs = append(token.attrs, attr)
+	}
+}
+
+//Cleans up HTML from a feed so that it's safe to show in Pogo: scripts, frames, styles,
+//forms and event handlers are removed, links open in a new tab and tracking pixels are
+//taken out. Tags are always balanced, so a description can't break the page around it
+func SanitiseHTML(text string) template.HTML {
+	var out strings.Builder
+	open := make([]string, 0)
+	//The dropped tag we're inside, and how deeply
+	skipping, depth := "", 0
+	for _, token := range tokenizeHTML(text) {
+		if skipping != "" {
+			if token.name == skipping && token.kind == startTagToken && !voidTags[token.name] {
+				depth++
+			} else if token.name == skipping && token.kind == endTagToken {
+				depth--
+				if depth == 0 {
+					skipping = ""
+				}
+			}
+			continue
+		}
+		switch token.kind {
+		case textToken:
+			out.WriteString(html.EscapeString(html.UnescapeString(token.text)))
+		case startTagToken:
+			if droppedTags[token.name] {
+				if !voidTags[token.name] {
+					skipping, depth = token.name, 1
+				}
+				continue
+			}
+			allowed, ok := allowedTags[token.name]
+			if !ok || (token.name == "img" && (cleanURL(token.attr("src"), "http", "https") == "" || trackingPixel(token))) {
+				continue
+			}
+			//A new list item ends the one before it (and so on), like it would in a browser
+			for top := len(open) - 1; top >= 0 && implicitlyClosed[open[top]][token.name]; top-- {
+				out.WriteString("</" + open[top] + ">")
+				open = open[:top]
+			}
+			out.WriteString("<" + token.name)
+			for _, name := range allowed {
+				value := token.attr(name)
+				switch {
+				case name == "href":
+					value = cleanURL(value, "http", "https", "mailto")
+				case name == "src":
+					value = cleanURL(value, "http", "https")
+				case numberAttributes[name]:
+					if _, err := strconv.Atoi(value); err != nil {
+						value = ""
+					}
+				}
+				if value != "" {
+					out.WriteString(" " + name + `="` + html.EscapeString(value) + `"`)
+				}
+				//Links open outside of Pogo (which also stops them from being loaded into
+				//the page by the player)
+				if name == "href" && value != "" {
+					out.WriteString(` target="_blank" rel="noopener noreferrer nofollow"`)
+				}
+			}
+			out.WriteString(">")
+			if !voidTags[token.name] {
+				open = append(open, token.name)
+			}
+		case endTagToken:
+			//Close anything that was left open inside it too
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == token.name {
+					for j := len(open) - 1; j >= i; j-- {
+						out.WriteString("</" + open[j] + ">")
+					}
+					open = open[:i]
+					break
+				}
+			}
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		out.WriteString("</" + open[i] + ">")
+	}
+	return template.HTML(out.String())
+}
+
+//Checks that a URL from a feed is absolute and uses one of the given schemes, so that
+//javascript: links and the like never make it through. Returns "" if it isn't allowed
+func cleanURL(raw string, schemes ...string) string {
+	//Browsers ignore tabs and newlines in URLs, so java&#9;script: is still javascript:
+	raw = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(raw))
+	if strings.HasPrefix(raw, "//") {
+		raw = "https:" + raw
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	scheme := strings.ToLower(parsed.Scheme)
+	for _, allowed := range schemes {
+		if scheme == allowed && (scheme == "mailto" || parsed.Host != "") {
+			return raw
+		}
+	}
+	return ""
+}
+
+//Whether or not an image is a tracking pixel: tiny, or from somewhere that only serves them
+func trackingPixel(token htmlToken) bool {
+	for _, size := range []string{token.attr("width"), token.attr("height")} {
+		if pixels, err := strconv.Atoi(strings.TrimSuffix(size, "px")); err == nil && pixels <= 1 {
+			return true
+		}
+	}
+	parsed, err := url.Parse(cleanURL(token.attr("src"), "http", "https"))
+	if err != nil {
+		return true
+	}
+	host := strings.ToLower(parsed.Hostname())
+	for _, tracker := range trackingHosts {
+		if host == tracker || strings.HasSuffix(host, "."+tracker) {
+			return true
+		}
+	}
+	return false
+}
+
+//Tags that start a new paragraph in plain text
+var paragraphTags = map[string]bool{
+	"blockquote": true, "div": true, "dl": true, "figure": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "hr": true, "ol": true, "p": true, "pre": true,
+	"table": true, "ul": true,
+}
+
+//Tags that start a new line in plain text
+var lineTags = map[string]bool{
+	"br": true, "dd": true, "dt": true, "figcaption": true, "li": true, "tr": true,
+}
+
+//Converts HTML to plain text the way a browser would show it: tags go, entities are decoded,
+//whitespace is collapsed (apart from the lines in <pre>) and paragraphs, list items and line
+//breaks become new lines
+func HTMLToText(text string) string {
+	var out strings.Builder
+	skipping, depth, pre := "", 0, 0
+	for _, token := range tokenizeHTML(text) {
+		if skipping != "" {
+			if token.name == skipping && token.kind == startTagToken {
+				depth++
+			} else if token.name == skipping && token.kind == endTagToken {
+				depth--
+				if depth == 0 {
+					skipping = ""
+				}
+			}
+			continue
+		}
+		switch {
+		case token.kind == textToken && pre > 0:
+			out.WriteString(html.UnescapeString(token.text))
+		case token.kind == textToken:
+			//Outside of <pre> new lines are just spaces
+			out.WriteString(strings.NewReplacer("\r", " ", "\n", " ").Replace(html.UnescapeString(token.text)))
+		case token.kind == startTagToken && droppedTags[token.name] && !voidTags[token.name]:
+			skipping, depth = token.name, 1
+		case paragraphTags[token.name]:
+			out.WriteString("\n\n")
+			if token.name == "pre" && token.kind == startTagToken {
+				pre++
+			} else if token.name == "pre" && pre > 0 {
+				pre--
+			}
+		case lineTags[token.name] && (token.kind == startTagToken || token.name != "li"):
+			out.WriteString("\n")
+			if token.name == "li" {
+				out.WriteString("• ")
+			}
+		case token.name == "td" || token.name == "th":
+			out.WriteString(" ")
+		}
+	}
+	//Now tidy up the whitespace: each line is collapsed, and there's never more than one
+	//blank line in a row
+	lines := make([]string, 0)
+	blank := true
+	for _, line := range strings.Split(out.String(), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			if !blank {
+				lines = append(lines, "")
+			}
+			blank = true
+			continue
+		}
+		lines = append(lines, line)
+		blank = false
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+//Cleans up the descriptions of episodes that were saved before descriptions were sanitised
+//when feeds were fetched
+func (catcher *Catcher) sanitiseSavedDescriptions() {
+	for i := range catcher.Podcasts {
+		for j := range catcher.Podcasts[i].PodcastEpisodes {
+			episode := &catcher.Podcasts[i].PodcastEpisodes[j]
+			episode.Description = SanitiseHTML(string(episode.Description))
+		}
+	}
+}
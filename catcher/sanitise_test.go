@@ -0,0 +1,98 @@
+package catcher
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitiseHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain text", "Hello & goodbye", "Hello &amp; goodbye"},
+		{"entities", "caf&eacute; &lt;3 &#x263A;", "café &lt;3 ☺"},
+		{"allowed tags", "<p>One <b>two</b></p>", "<p>One <b>two</b></p>"},
+		{"upper case tags", "<P>One <B>two</B></P>", "<p>One <b>two</b></p>"},
+		{"unknown tags keep their text", "<blink>Look</blink>", "Look"},
+		{"script is dropped", "a<script>alert(1)</script>b", "ab"},
+		{"script with a tag inside", "a<script>if (1 < 2) { document.write('<p>') }</script>b", "ab"},
+		{"upper case end tag", "a<SCRIPT>x</ScRiPt>b", "ab"},
+		{"multibyte text in title", "<title>" + strings.Repeat("Ⱥ", 40) + "</title>after", "after"},
+		{"multibyte text in script", "<script>" + strings.Repeat("Ⱥ", 40) + "</script>after", "after"},
+		{"multibyte text in style", "<style>" + strings.Repeat("ẞ", 40) + "</STYLE>after", "after"},
+		{"unclosed script", "before<script>" + strings.Repeat("Ⱥ", 10), "before"},
+		{"unclosed tag", "before<p", "before"},
+		{"unclosed attribute", `before<a href="http://x`, "before"},
+		{"unclosed comment", "before<!-- never ends", "before"},
+		{"comment", "a<!-- <b>hidden</b> -->b", "ab"},
+		{"tags left open are closed", "<p><b>bold", "<p><b>bold</b></p>"},
+		{"stray end tag", "a</b>b", "ab"},
+		{"list items close each other", "<ul><li>one<li>two</ul>", "<ul><li>one</li><li>two</li></ul>"},
+		{"lone less than", "1 < 2", "1 &lt; 2"},
+		{"link", `<a href="https://example.com/?a=1&amp;b=2" onclick="x()">link</a>`, `<a href="https://example.com/?a=1&amp;b=2" target="_blank" rel="noopener noreferrer nofollow">link</a>`},
+		{"javascript link", `<a href="javascript:alert(1)">link</a>`, "<a>link</a>"},
+		{"unquoted attribute", `<ol start=3><li>three</ol>`, `<ol start="3"><li>three</li></ol>`},
+		{"number attribute that isn't a number", `<td colspan="x">a</td>`, "<td>a</td>"},
+		{"image", `<img src="https://example.com/a.png" alt='"hi"'>`, `<img src="https://example.com/a.png" alt="&#34;hi&#34;">`},
+		{"image without a safe source", `<img src="data:image/png;base64,AAAA">`, ""},
+		{"tracking pixel", `<img src="https://stats.wordpress.com/b.gif">`, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(SanitiseHTML(test.in)); got != test.want {
+				t.Errorf("SanitiseHTML(%q) = %q, want %q", test.in, got, test.want)
+			}
+		})
+	}
+}
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain text", "Hello", "Hello"},
+		{"entities", "Tom &amp; Jerry&#39;s &quot;show&quot;", `Tom & Jerry's "show"`},
+		{"whitespace", "  lots\n of   space ", "lots of space"},
+		{"paragraphs", "<p>One</p><p>Two</p>", "One\n\nTwo"},
+		{"line breaks", "One<br>Two", "One\nTwo"},
+		{"list", "<ul><li>One</li><li>Two</li></ul>", "• One\n• Two"},
+		{"pre keeps its lines", "<pre>a\n  b</pre>", "a\nb"},
+		{"script is dropped", "a<script>x < y</script>b", "ab"},
+		{"multibyte text in title", "<title>" + strings.Repeat("Ⱥ", 40) + "</title>after", "after"},
+		{"multibyte text in script", "before <script>" + strings.Repeat("Ⱥ", 40) + "</script>", "before"},
+		{"unclosed script", "before <script>" + strings.Repeat("Ⱥ", 10), "before"},
+		{"unclosed tag", "before <b", "before"},
+		{"attributes", `<a href="https://example.com" title="x">link</a>`, "link"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := HTMLToText(test.in); got != test.want {
+				t.Errorf("HTMLToText(%q) = %q, want %q", test.in, got, test.want)
+			}
+		})
+	}
+}
+
+func TestIndexFold(t *testing.T) {
+	tests := []struct {
+		text   string
+		substr string
+		want   int
+	}{
+		{"abc</script>", "</script", 3},
+		{"abc</SCRIPT>", "</script", 3},
+		{"ȺȺȺ</title>", "</title", 6},
+		{"ȺȺȺ", "</title", -1},
+		{"</ti", "</title", -1},
+		{"", "</title", -1},
+	}
+	for _, test := range tests {
+		if got := indexFold(test.text, test.substr); got != test.want {
+			t.Errorf("indexFold(%q, %q) = %d, want %d", test.text, test.substr, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package catcher
+
+import (
+	"errors"
+	"fmt"
+	"github.com/programmingthomas/Pogo/pogoutils"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//When the catcher refreshes feeds and downloads episodes
+type Schedule struct {
+	//How often feeds are refreshed when they don't have their own interval and haven't
+	//published enough episodes to work one out
+	RefreshInterval time.Duration
+	//Times of day when nothing is refreshed or downloaded
+	QuietHours []Window
+	//Times of day when episodes can be downloaded. If there aren't any, episodes can be
+	//downloaded whenever (outside of quiet hours)
+	DownloadWindows []Window
+	//How many bytes can be downloaded in each download window (or each day if there aren't
+	//any windows). 0 means there isn't a cap
+	DownloadCap int64
+}
+
+//How often the refresher looks for feeds that need refreshing
+const checkInterval = time.Minute
+
+//Automatic refresh intervals are kept between these so that we don't hammer feeds that
+//publish all the time or forget about feeds that hardly ever do
+const (
+	minRefreshInterval = 15 * time.Minute
+	maxRefreshInterval = 12 * time.Hour
+)
+
+//How many of the latest episodes are looked at to work out how often a podcast publishes
+const cadenceEpisodes = 10
+
+var ErrNoPodcast = errors.New("no such podcast")
+
+var ErrNoEpisode = errors.New("no such episode")
+
+//Returned when a download stops because the download cap has been used up. What has been
+//downloaded so far is kept, so it carries on from there once there's some cap left
+var errDownloadCap = fmt.Errorf("download cap reached: %w", pogoutils.ErrDownloadPaused)
+
+//A time of day range, e.g. 01:00-06:00. Windows that end before they start go over midnight,
+//and windows that start and end at the same time last all day
+type Window struct {
+	//Since midnight
+	Start time.Duration
+	End   time.Duration
+}
+
+//Parses a comma separated list of windows, e.g. "01:00-06:00,13:00-14:00"
+func ParseWindows(text string) ([]Window, error) {
+	windows := make([]Window, 0)
+	for _, part := range strings.Split(text, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		times := strings.Split(part, "-")
+		if len(times) != 2 {
+			return nil, fmt.Errorf("%q should look like 01:00-06:00", part)
+		}
+		start, err := parseTimeOfDay(times[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(times[1])
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, Window{Start: start, End: end})
+	}
+	return windows, nil
+}
+
+//Parses a 24 hour time like 6:00 or 23:30
+func parseTimeOfDay(text string) (time.Duration, error) {
+	text = strings.TrimSpace(text)
+	parts := strings.Split(text, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("%q isn't a time like 06:00", text)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil || hours < 0 || hours > 24 {
+		return 0, fmt.Errorf("%q isn't a time like 06:00", text)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || minutes < 0 || minutes > 59 || (hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("%q isn't a time like 06:00", text)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+func (window Window) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return format(window.Start) + "-" + format(window.End)
+}
+
+//Gets when the window that t is in opened, or false if t isn't in the window
+func (window Window) opened(t time.Time) (time.Time, bool) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	since := t.Sub(midnight)
+	switch {
+	case window.Start == window.End:
+		return midnight, true
+	case window.Start < window.End:
+		return midnight.Add(window.Start), since >= window.Start && since < window.End
+	case since >= window.Start:
+		return midnight.Add(window.Start), true
+	case since < window.End:
+		return midnight.AddDate(0, 0, -1).Add(window.Start), true
+	}
+	return time.Time{}, false
+}
+
+//Whether or not it's quiet hours at the given time
+func (schedule Schedule) quiet(t time.Time) bool {
+	for _, window := range schedule.QuietHours {
+		if _, ok := window.opened(t); ok {
+			return true
+		}
+	}
+	return false
+}
+
+//Gets when the download window that t is in opened, or false if episodes can't be downloaded
+//at t. Without any windows the window is the whole day
+func (schedule Schedule) downloadWindow(t time.Time) (time.Time, bool) {
+	if schedule.quiet(t) {
+		return time.Time{}, false
+	}
+	if len(schedule.DownloadWindows) == 0 {
+		return Window{}.opened(t)
+	}
+	for _, window := range schedule.DownloadWindows {
+		if opened, ok := window.opened(t); ok {
+			return opened, true
+		}
+	}
+	return time.Time{}, false
+}
+
+//Whether or not an episode can start downloading now. Downloads that aren't allowed yet are
+//remembered so that they're started as soon as they are. Hold downloadingLock when calling this
+func (catcher *Catcher) canDownload() bool {
+	opened, ok := catcher.schedule.downloadWindow(time.Now())
+	if ok && catcher.schedule.DownloadCap > 0 {
+		if opened.Equal(catcher.capWindow) && catcher.capUsed >= catcher.schedule.DownloadCap {
+			ok = false
+		}
+	}
+	if !ok {
+		catcher.deferred = true
+	}
+	return ok
+}
+
+//Counts some downloaded bytes against the download cap. Returns false if the cap has been
+//used up
+func (catcher *Catcher) useDownloadCap(n int64) bool {
+	if catcher.schedule.DownloadCap <= 0 {
+		return true
+	}
+	catcher.downloadingLock.Lock()
+	defer catcher.downloadingLock.Unlock()
+	//Downloads that carry on after their window closes count against the window they
+	//started in
+	if opened, ok := catcher.schedule.downloadWindow(time.Now()); ok && !opened.Equal(catcher.capWindow) {
+		catcher.capWindow = opened
+		catcher.capUsed = 0
+	}
+	catcher.capUsed += n
+	if catcher.capUsed > catcher.schedule.DownloadCap {
+		catcher.deferred = true
+		return false
+	}
+	return true
+}
+
+//Reads a download, stopping it once the download cap has been used up
+type capReader struct {
+	reader  io.Reader
+	catcher *Catcher
+}
+
+func (reader capReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	if n > 0 && !reader.catcher.useDownloadCap(int64(n)) {
+		return n, errDownloadCap
+	}
+	return n, err
+}
+
+//Starts any downloads that had to wait for a download window (or for the cap to reset)
+func (catcher *Catcher) downloadDeferred() {
+	catcher.downloadingLock.Lock()
+	deferred := catcher.deferred
+	catcher.deferred = false
+	catcher.downloadingLock.Unlock()
+	if !deferred {
+		return
+	}
+	for _, podcast := range catcher.AllPodcasts() {
+		for _, episode := range podcast.PodcastEpisodes {
+			if episode.ShouldDownloadIfNotDownloaded && !episode.Downloaded() {
+				catcher.download(episode.URL, episode.DownloadedFilename())
+			}
+		}
+	}
+}
+
+//Works out how often a podcast publishes episodes (the median time between its latest
+//episodes). Returns false if it hasn't published enough episodes to tell
+func (podFeed PodFeed) publishingInterval() (time.Duration, bool) {
+	dates := make([]time.Time, 0, len(podFeed.PodcastEpisodes))
+	for _, episode := range podFeed.PodcastEpisodes {
+		if episode.HasPubDate() {
+			dates = append(dates, episode.Published)
+		}
+	}
+	sort.Slice(dates, func(i, j int) bool {
+		return dates[i].After(dates[j])
+	})
+	if len(dates) > cadenceEpisodes {
+		dates = dates[:cadenceEpisodes]
+	}
+	if len(dates) < 3 {
+		return 0, false
+	}
+	gaps := make([]time.Duration, 0, len(dates)-1)
+	for i := 1; i < len(dates); i++ {
+		gaps = append(gaps, dates[i-1].Sub(dates[i]))
+	}
+	sort.Slice(gaps, func(i, j int) bool {
+		return gaps[i] < gaps[j]
+	})
+	return gaps[len(gaps)/2], true
+}
+
+//Gets how often a podcast is refreshed: its own interval if it has one, otherwise often
+//enough to notice a new episode fairly soon after it comes out
+func (catcher *Catcher) RefreshIntervalFor(podFeed PodFeed) time.Duration {
+	if podFeed.RefreshInterval > 0 {
+		return podFeed.RefreshInterval
+	}
+	published, ok := podFeed.publishingInterval()
+	if !ok {
+		return catcher.schedule.RefreshInterval
+	}
+	interval := published / 8
+	if interval < minRefreshInterval {
+		interval = minRefreshInterval
+	}
+	if interval > maxRefreshInterval {
+		interval = maxRefreshInterval
+	}
+	return interval
+}
+
+//Whether or not a podcast is due to be refreshed
+func (catcher *Catcher) due(podFeed PodFeed, now time.Time) bool {
+	return now.Sub(podFeed.LastChecked) >= catcher.RefreshIntervalFor(podFeed)
+}
+
+//Sets how often a podcast is refreshed. 0 works it out from how often it publishes episodes
+func (catcher *Catcher) SetRefreshInterval(feedURL string, interval time.Duration) error {
+	if interval < 0 {
+		return errors.New("the refresh interval can't be negative")
+	}
+	catcher.mutex.Lock()
+	found := false
+	for i := range catcher.Podcasts {
+		if catcher.Podcasts[i].FeedURL == feedURL {
+			catcher.Podcasts[i].RefreshInterval = interval
+			found = true
+			break
+		}
+	}
+	catcher.mutex.Unlock()
+	if !found {
+		return ErrNoPodcast
+	}
+	go catcher.SaveData()
+	return nil
+}
@@ -1,6 +1,9 @@
 package catcher
 
-import "sort"
+import (
+	"sort"
+	"strings"
+)
 
 type PodcastsBy func(p1, p2 * PodFeed) bool
 
@@ -43,7 +46,8 @@ func (by EpisodesBy) sort(episodes []PodEpisode) {
 		episodes: episodes,
 		by : by,
 	}
-	sort.Sort(ps)
+	//Stable so that episodes without dates stay in the order the feed had them in
+	sort.Stable(ps)
 }
 
 type episodeSorter struct {
@@ -63,9 +67,33 @@ func (s * episodeSorter) Less(i, j int) bool {
 	return s.by(&s.episodes[i], &s.episodes[j])
 }
 
-func (feed * PodFeed) SortEpisodesByDate() {
-	dateSorter := func(p1, p2 * PodEpisode) bool {
-		return p1.ReleaseDate().Before(p2.ReleaseDate())
+//Sorts some episodes (which don't have to be from the same feed)
+func (by EpisodesBy) Sort(episodes []PodEpisode) {
+	by.sort(episodes)
+}
+
+//Sorts the other way around. Episodes that are the same still stay in the order they were in
+func (by EpisodesBy) Reverse() EpisodesBy {
+	return func(p1, p2 * PodEpisode) bool {
+		return by(p2, p1)
 	}
-	EpisodesBy(dateSorter).sort(feed.PodcastEpisodes)
+}
+
+//Oldest first. Episodes without a date come before everything else
+func EpisodesByDate(p1, p2 * PodEpisode) bool {
+	return p1.ReleaseDate().Before(p2.ReleaseDate())
+}
+
+//A to Z, ignoring case
+func EpisodesByTitle(p1, p2 * PodEpisode) bool {
+	return strings.ToLower(p1.Title) < strings.ToLower(p2.Title)
+}
+
+//Shortest first. Episodes that we don't know the length of come first
+func EpisodesByLength(p1, p2 * PodEpisode) bool {
+	return p1.Length < p2.Length
+}
+
+func (feed * PodFeed) SortEpisodesByDate() {
+	EpisodesBy(EpisodesByDate).sort(feed.PodcastEpisodes)
 }
\ No newline at end of file
@@ -0,0 +1,178 @@
+package catcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/programmingthomas/Pogo/media"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+//A transcript that a feed links to with podcast:transcript
+type TranscriptLink struct {
+	URL      string
+	Type     string
+	Language string
+}
+
+//Where transcripts are saved once they have been fetched. They can be big, so they're kept
+//out of pogoconfig.json
+const transcriptFolder = "downloads/transcripts"
+
+//Some transcripts are huge, but not this huge
+const maxTranscriptSize = 16 << 20
+
+//How long to wait before trying to fetch a transcript again when it couldn't be fetched
+const transcriptRetryInterval = 6 * time.Hour
+
+//Gets the transcript for an episode, fetching it if it hasn't been fetched yet. Episodes
+//without a transcript give an empty list
+func (catcher *Catcher) Transcript(episodeURL string) []media.Cue {
+	episode, ok := catcher.episode(episodeURL)
+	if !ok {
+		return []media.Cue{}
+	}
+	if !episode.TranscriptLoaded {
+		if !episode.transcriptDue() {
+			return []media.Cue{}
+		}
+		filename, loaded := catcher.loadTranscript(episode)
+		if !loaded {
+			return []media.Cue{}
+		}
+		catcher.updateEpisode(episodeURL, func(stored *PodEpisode) {
+			stored.TranscriptFile = filename
+			stored.TranscriptLoaded = true
+		})
+		episode.TranscriptFile = filename
+	}
+	cues, err := readTranscript(episode.TranscriptFile)
+	if err != nil {
+		fmt.Println("Error reading transcript", episode.TranscriptFile, err)
+		return []media.Cue{}
+	}
+	return cues
+}
+
+//Whether or not the transcript should be fetched now: it hasn't been fetched yet and it didn't
+//fail recently
+func (episode PodEpisode) transcriptDue() bool {
+	return !episode.TranscriptLoaded && len(episode.Transcripts) > 0 && time.Since(episode.TranscriptFailed) >= transcriptRetryInterval
+}
+
+//Picks the transcript with the most useful format
+func bestTranscript(links []TranscriptLink) (TranscriptLink, bool) {
+	for _, preferred := range media.TranscriptTypes {
+		for _, link := range links {
+			if strings.HasPrefix(link.Type, preferred) {
+				return link, true
+			}
+		}
+	}
+	if len(links) > 0 {
+		return links[0], true
+	}
+	return TranscriptLink{}, false
+}
+
+//Fetches, parses and saves the transcript for an episode, returning where it was saved.
+//Returns false if it couldn't be fetched so that we try again later (but not until
+//transcriptRetryInterval has passed)
+func (catcher *Catcher) loadTranscript(episode PodEpisode) (string, bool) {
+	link, ok := bestTranscript(episode.Transcripts)
+	if !ok {
+		return "", false
+	}
+	contents, contentType, err := catcher.fetchFile(link.URL, maxTranscriptSize)
+	if err != nil {
+		if err != ErrOffline {
+			fmt.Println("Error fetching transcript for", episode.Title, err)
+		}
+		//It's not the transcript's fault if Pogo went offline or stopped halfway through
+		if !catcher.Offline() && !catcher.stopping() {
+			catcher.updateEpisode(episode.URL, func(stored *PodEpisode) {
+				stored.TranscriptFailed = time.Now()
+			})
+		}
+		return "", false
+	}
+	if link.Type != "" {
+		contentType = link.Type
+	}
+	cues, err := media.ParseTranscript(contents, contentType)
+	if err != nil {
+		//Trying again won't help if we can't understand it
+		fmt.Println("Error parsing transcript for", episode.Title, err)
+		return "", true
+	}
+	_, filename := path.Split(episode.DownloadedFilename())
+	filename = transcriptFolder + "/" + filename + ".json"
+	b, err := json.Marshal(cues)
+	if err == nil {
+		err = os.MkdirAll(transcriptFolder, 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(filename, b, 0644)
+	}
+	if err != nil {
+		fmt.Println("Error saving transcript for", episode.Title, err)
+		return "", false
+	}
+	return filename, true
+}
+
+//Reads a saved transcript
+func readTranscript(filename string) ([]media.Cue, error) {
+	cues := make([]media.Cue, 0)
+	if filename == "" {
+		return cues, nil
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return cues, err
+	}
+	err = json.Unmarshal(b, &cues)
+	return cues, err
+}
+
+//Somewhere that some text was said
+type TranscriptMatch struct {
+	Podcast PodFeed
+	Episode PodEpisode
+	Cue     media.Cue
+}
+
+//Searches the transcripts that have been fetched for some text (ignoring case). Only the
+//podcasts that include says yes to are searched, and at most limit matches are returned
+func (catcher *Catcher) SearchTranscripts(query string, include func(feedURL string) bool, limit int) []TranscriptMatch {
+	matches := make([]TranscriptMatch, 0)
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return matches
+	}
+	for _, podcast := range catcher.AllPodcasts() {
+		if !include(podcast.FeedURL) {
+			continue
+		}
+		for _, episode := range podcast.PodcastEpisodes {
+			if episode.TranscriptFile == "" {
+				continue
+			}
+			cues, err := readTranscript(episode.TranscriptFile)
+			if err != nil {
+				continue
+			}
+			for _, cue := range cues {
+				if strings.Contains(strings.ToLower(cue.Text), query) || strings.Contains(strings.ToLower(cue.Speaker), query) {
+					matches = append(matches, TranscriptMatch{Podcast: podcast, Episode: episode, Cue: cue})
+					if len(matches) >= limit {
+						return matches
+					}
+				}
+			}
+		}
+	}
+	return matches
+}
@@ -0,0 +1,107 @@
+package media
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+//A chapter of an episode. End is zero when we don't know where the chapter finishes
+type Chapter struct {
+	Title string
+	Start time.Duration
+	End   time.Duration
+	URL   string
+	Image string
+}
+
+//Gets the start of the chapter like 13:37 or 1:02:03
+func (chapter Chapter) StartText() string {
+	return FormatTime(chapter.Start)
+}
+
+//Formats a position in an episode like 13:37 or 1:02:03
+func FormatTime(d time.Duration) string {
+	seconds := int64(d / time.Second)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+//Reads the chapters embedded in a media file. MP3s can have ID3v2 CHAP frames and MP4s
+//(including M4A and M4B audiobooks) can have Nero or QuickTime chapters. Files without any
+//chapters give an empty list
+func ReadChapters(filename string) ([]Chapter, error) {
+	file, size, format, err := openMedia(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	switch format {
+	case formatMP3:
+		tag, err := readID3(file)
+		if err == errNoID3 {
+			return []Chapter{}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return tag.chapters(), nil
+	case formatMP4:
+		return readMP4Chapters(file, size)
+	}
+	return []Chapter{}, nil
+}
+
+//The podcast:chapters JSON format (https://github.com/Podcastindex-org/podcast-namespace)
+type chaptersJSON struct {
+	Version  string `json:"version"`
+	Chapters []struct {
+		StartTime float64 `json:"startTime"`
+		EndTime   float64 `json:"endTime"`
+		Title     string  `json:"title"`
+		Img       string  `json:"img"`
+		URL       string  `json:"url"`
+		TOC       *bool   `json:"toc"`
+	} `json:"chapters"`
+}
+
+//Parses chapters in the podcast:chapters JSON format. Chapters that are marked as not being
+//part of the table of contents are left out
+func ParseChaptersJSON(data []byte) ([]Chapter, error) {
+	var parsed chaptersJSON
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, err
+	}
+	chapters := make([]Chapter, 0, len(parsed.Chapters))
+	for _, c := range parsed.Chapters {
+		if c.TOC != nil && !*c.TOC {
+			continue
+		}
+		chapters = append(chapters, Chapter{
+			Title: strings.TrimSpace(c.Title),
+			Start: seconds(c.StartTime),
+			End:   seconds(c.EndTime),
+			URL:   c.URL,
+			Image: c.Img,
+		})
+	}
+	sortChapters(chapters)
+	return chapters, nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+//Sorts chapters by when they start and fills in missing ends from the start of the next one
+func sortChapters(chapters []Chapter) {
+	sort.SliceStable(chapters, func(i, j int) bool { return chapters[i].Start < chapters[j].Start })
+	for i := range chapters {
+		if chapters[i].End <= chapters[i].Start && i+1 < len(chapters) {
+			chapters[i].End = chapters[i+1].Start
+		}
+	}
+}
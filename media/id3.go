@@ -0,0 +1,243 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+//A frame from an ID3v2 tag
+type id3Frame struct {
+	ID   string
+	Data []byte
+}
+
+//The parts of an ID3v2 tag that we care about
+type id3Tag struct {
+	Version byte
+	Size    int64
+	Frames  []id3Frame
+}
+
+var errNoID3 = errors.New("no ID3v2 tag")
+
+//Gets a syncsafe integer (7 bits per byte)
+func syncsafe(b []byte) int64 {
+	var n int64
+	for _, c := range b {
+		n = n<<7 | int64(c&0x7f)
+	}
+	return n
+}
+
+//Undoes ID3 unsynchronisation, which inserts a zero after every 0xff
+func unsynchronise(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte{0xff, 0x00}, []byte{0xff})
+}
+
+//Reads the ID3v2 tag at the start of a file. Versions 2.3 and 2.4 are supported; 2.2 tags
+//are skipped over but their frames aren't read
+func readID3(r io.ReaderAt) (id3Tag, error) {
+	header := make([]byte, 10)
+	if _, err := r.ReadAt(header, 0); err != nil || string(header[:3]) != "ID3" {
+		return id3Tag{}, errNoID3
+	}
+	tag := id3Tag{Version: header[3], Size: 10 + syncsafe(header[6:10])}
+	flags := header[5]
+	if flags&0x10 != 0 {
+		//Footer
+		tag.Size += 10
+	}
+	if tag.Version < 3 || tag.Version > 4 {
+		return tag, nil
+	}
+	data := make([]byte, syncsafe(header[6:10]))
+	n, err := r.ReadAt(data, 10)
+	if err != nil && err != io.EOF {
+		return tag, err
+	}
+	//Don't read frames out of the zeros after the end of a file that's been cut off
+	data = data[:n]
+	if tag.Version == 3 && flags&0x80 != 0 {
+		data = unsynchronise(data)
+	}
+	if flags&0x40 != 0 && len(data) >= 4 {
+		//Skip the extended header
+		var size int64
+		if tag.Version == 3 {
+			size = int64(binary.BigEndian.Uint32(data)) + 4
+		} else {
+			size = syncsafe(data[:4])
+		}
+		if size > int64(len(data)) {
+			return tag, nil
+		}
+		data = data[size:]
+	}
+	tag.Frames = readID3Frames(data, tag.Version)
+	return tag, nil
+}
+
+//Reads a run of frames, which is either the body of a tag or the sub-frames of a CHAP frame
+func readID3Frames(data []byte, version byte) []id3Frame {
+	frames := make([]id3Frame, 0)
+	for len(data) >= 10 && data[0] != 0 {
+		id := string(data[:4])
+		var size int64
+		if version == 4 {
+			size = syncsafe(data[4:8])
+		} else {
+			size = int64(binary.BigEndian.Uint32(data[4:8]))
+		}
+		formatFlags := data[9]
+		if size < 0 || size > int64(len(data)-10) {
+			break
+		}
+		body := data[10 : 10+size]
+		data = data[10+size:]
+		if version == 4 {
+			if formatFlags&0x01 != 0 && len(body) >= 4 {
+				//Data length indicator
+				body = body[4:]
+			}
+			if formatFlags&0x02 != 0 {
+				body = unsynchronise(body)
+			}
+		}
+		if formatFlags&0x0c != 0 {
+			//Compressed or encrypted, which nobody really uses
+			continue
+		}
+		frames = append(frames, id3Frame{ID: id, Data: body})
+	}
+	return frames
+}
+
+//Gets the first frame with the given ID
+func (tag id3Tag) frame(id string) (id3Frame, bool) {
+	for _, frame := range tag.Frames {
+		if frame.ID == id {
+			return frame, true
+		}
+	}
+	return id3Frame{}, false
+}
+
+//Gets the text of a text frame such as TIT2
+func (tag id3Tag) text(id string) string {
+	if frame, ok := tag.frame(id); ok && len(frame.Data) > 0 {
+		text, _ := id3String(frame.Data[0], frame.Data[1:])
+		return text
+	}
+	return ""
+}
+
+//Decodes a string in one of the ID3 encodings up to its terminator, returning whatever is
+//left over after it
+func id3String(encoding byte, data []byte) (string, []byte) {
+	wide := encoding == 1 || encoding == 2
+	end, next := len(data), len(data)
+	if wide {
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				end, next = i, i+2
+				break
+			}
+		}
+	} else if i := bytes.IndexByte(data, 0); i >= 0 {
+		end, next = i, i+1
+	}
+	raw, rest := data[:end], data[next:]
+	switch encoding {
+	case 1, 2:
+		bigEndian := encoding == 2
+		if len(raw) >= 2 && raw[0] == 0xff && raw[1] == 0xfe {
+			bigEndian, raw = false, raw[2:]
+		} else if len(raw) >= 2 && raw[0] == 0xfe && raw[1] == 0xff {
+			bigEndian, raw = true, raw[2:]
+		}
+		units := make([]uint16, len(raw)/2)
+		for i := range units {
+			if bigEndian {
+				units[i] = binary.BigEndian.Uint16(raw[2*i:])
+			} else {
+				units[i] = binary.LittleEndian.Uint16(raw[2*i:])
+			}
+		}
+		return strings.TrimSpace(string(utf16.Decode(units))), rest
+	case 3:
+		return strings.TrimSpace(string(raw)), rest
+	}
+	//ISO-8859-1 maps straight onto the first 256 code points
+	runes := make([]rune, len(raw))
+	for i, c := range raw {
+		runes[i] = rune(c)
+	}
+	return strings.TrimSpace(string(runes)), rest
+}
+
+//Gets the chapters from CHAP frames. If there is a top level table of contents (CTOC frame)
+//then that decides the order, otherwise they are sorted by start time
+func (tag id3Tag) chapters() []Chapter {
+	byID := make(map[string]Chapter)
+	ids := make([]string, 0)
+	var toc []string
+	for _, frame := range tag.Frames {
+		switch frame.ID {
+		case "CHAP":
+			id, rest := id3String(0, frame.Data)
+			if len(rest) < 16 {
+				continue
+			}
+			chapter := Chapter{
+				Start: time.Duration(binary.BigEndian.Uint32(rest[0:4])) * time.Millisecond,
+				End:   time.Duration(binary.BigEndian.Uint32(rest[4:8])) * time.Millisecond,
+			}
+			sub := id3Tag{Frames: readID3Frames(rest[16:], tag.Version)}
+			chapter.Title = sub.text("TIT2")
+			if chapter.Title == "" {
+				chapter.Title = sub.text("TIT3")
+			}
+			if link, ok := sub.frame("WXXX"); ok && len(link.Data) > 0 {
+				_, url := id3String(link.Data[0], link.Data[1:])
+				chapter.URL, _ = id3String(0, url)
+			}
+			if _, seen := byID[id]; !seen {
+				ids = append(ids, id)
+			}
+			byID[id] = chapter
+		case "CTOC":
+			_, rest := id3String(0, frame.Data)
+			if len(rest) < 2 || rest[0]&0x02 == 0 || toc != nil {
+				//Only the top level table of contents matters
+				continue
+			}
+			count := int(rest[1])
+			rest = rest[2:]
+			toc = make([]string, 0, count)
+			for i := 0; i < count && len(rest) > 0; i++ {
+				var child string
+				child, rest = id3String(0, rest)
+				toc = append(toc, child)
+			}
+		}
+	}
+	chapters := make([]Chapter, 0, len(byID))
+	if len(toc) > 0 {
+		for _, id := range toc {
+			if chapter, ok := byID[id]; ok {
+				chapters = append(chapters, chapter)
+			}
+		}
+		return chapters
+	}
+	for _, id := range ids {
+		chapters = append(chapters, byID[id])
+	}
+	sortChapters(chapters)
+	return chapters
+}
@@ -0,0 +1,226 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func syncsafeBytes(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+//Makes a frame for a tag of the given version
+func testID3Frame(version byte, id string, flags byte, body []byte) []byte {
+	frame := []byte(id)
+	if version == 4 {
+		frame = append(frame, syncsafeBytes(len(body))...)
+	} else {
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(body)))
+	}
+	frame = append(frame, 0, flags)
+	return append(frame, body...)
+}
+
+//Makes a whole tag out of some frames
+func testID3Tag(version, flags byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	tag := append([]byte("ID3"), version, 0, flags)
+	tag = append(tag, syncsafeBytes(len(body))...)
+	return append(tag, body...)
+}
+
+//A text frame in UTF-8
+func testTextFrame(version byte, id, text string) []byte {
+	return testID3Frame(version, id, 0, append([]byte{3}, text...))
+}
+
+//A CHAP frame. Times are in milliseconds
+func testChapFrame(version byte, id string, start, end uint32, sub ...[]byte) []byte {
+	body := append([]byte(id), 0)
+	body = binary.BigEndian.AppendUint32(body, start)
+	body = binary.BigEndian.AppendUint32(body, end)
+	body = append(body, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	return testID3Frame(version, "CHAP", 0, append(body, bytes.Join(sub, nil)...))
+}
+
+//A CTOC frame listing some chapters
+func testCTOCFrame(version byte, id string, flags byte, children ...string) []byte {
+	body := append([]byte(id), 0, flags, byte(len(children)))
+	for _, child := range children {
+		body = append(append(body, child...), 0)
+	}
+	return testID3Frame(version, "CTOC", 0, body)
+}
+
+func TestSyncsafe(t *testing.T) {
+	tests := []struct {
+		b    []byte
+		want int64
+	}{
+		{[]byte{0, 0, 0, 0}, 0},
+		{[]byte{0, 0, 0, 0x7f}, 127},
+		{[]byte{0, 0, 1, 0}, 128},
+		{[]byte{0x7f, 0x7f, 0x7f, 0x7f}, 1<<28 - 1},
+		//The top bit of each byte is ignored
+		{[]byte{0, 0, 0x81, 0x80}, 128},
+	}
+	for _, test := range tests {
+		if got := syncsafe(test.b); got != test.want {
+			t.Errorf("syncsafe(%v) = %d, want %d", test.b, got, test.want)
+		}
+	}
+}
+
+func TestReadID3(t *testing.T) {
+	long := string(bytes.Repeat([]byte("a"), 200))
+	unsynchronised := testID3Tag(3, 0x80, testID3Frame(3, "TIT2", 0, []byte{0, 'a', 0xff, 0x00, 'b'}))
+	//The frame size is after unsynchronisation has been undone
+	unsynchronised[10+7] = 4
+	tests := []struct {
+		name   string
+		data   []byte
+		err    error
+		size   int64
+		frames int
+		title  string
+	}{
+		{"not a tag", []byte("RIFF....WAVEfmt "), errNoID3, 0, 0, ""},
+		{"too short", []byte("ID3\x03"), errNoID3, 0, 0, ""},
+		{"empty", nil, errNoID3, 0, 0, ""},
+		{"v2.3", testID3Tag(3, 0, testTextFrame(3, "TIT2", "Episode 1"), testTextFrame(3, "TLEN", "1000")), nil, 10 + 2*10 + 10 + 5, 2, "Episode 1"},
+		{"v2.4 syncsafe frame sizes", testID3Tag(4, 0, testTextFrame(4, "TIT2", long)), nil, 10 + 10 + 201, 1, long},
+		{"v2.2 is skipped", append([]byte("ID3\x02\x00\x00"), syncsafeBytes(100)...), nil, 110, 0, ""},
+		{"v2.5 is skipped", append([]byte("ID3\x05\x00\x00"), syncsafeBytes(100)...), nil, 110, 0, ""},
+		{"footer", testID3Tag(4, 0x10, testTextFrame(4, "TIT2", "x")), nil, 10 + 12 + 10, 1, "x"},
+		{"padding", testID3Tag(3, 0, testTextFrame(3, "TIT2", "x"), make([]byte, 50)), nil, 10 + 12 + 50, 1, "x"},
+		{"unsynchronised", unsynchronised, nil, int64(len(unsynchronised)), 1, "aÿb"},
+		{"v2.3 extended header", testID3Tag(3, 0x40, []byte{0, 0, 0, 6, 0, 0, 0, 0, 0, 0}, testTextFrame(3, "TIT2", "x")), nil, 10 + 10 + 12, 1, "x"},
+		{"v2.4 extended header", testID3Tag(4, 0x40, []byte{0, 0, 0, 6, 1, 0}, testTextFrame(4, "TIT2", "x")), nil, 10 + 6 + 12, 1, "x"},
+		{"extended header bigger than the tag", testID3Tag(3, 0x40, []byte{0x7f, 0, 0, 0}, testTextFrame(3, "TIT2", "x")), nil, 10 + 4 + 12, 0, ""},
+		{"compressed frame", testID3Tag(3, 0, testID3Frame(3, "TIT2", 0x80|0x08, []byte{3, 'x'}), testTextFrame(3, "TALB", "y")), nil, 10 + 12 + 12, 1, ""},
+		{"v2.4 data length indicator", testID3Tag(4, 0, testID3Frame(4, "TIT2", 0x01, []byte{0, 0, 0, 2, 3, 'x'})), nil, 10 + 16, 1, "x"},
+		{"frame bigger than the tag", testID3Tag(3, 0, testTextFrame(3, "TIT2", "x"), []byte{'T', 'A', 'L', 'B', 0, 0, 0x10, 0, 0, 0, 3}), nil, 10 + 12 + 11, 1, "x"},
+		{"cut off", testID3Tag(3, 0, testTextFrame(3, "TIT2", "Episode 1"))[:20], nil, 10 + 20, 0, ""},
+		{"size says more than there is", append(append([]byte("ID3\x03\x00\x00"), syncsafeBytes(1000)...), testTextFrame(3, "TIT2", "x")...), nil, 1010, 1, "x"},
+	}
+	for _, test := range tests {
+		tag, err := readID3(bytes.NewReader(test.data))
+		if err != test.err {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if tag.Size != test.size || len(tag.Frames) != test.frames || tag.text("TIT2") != test.title {
+			t.Errorf("%s: got size %d, %d frames and title %q, want %d, %d and %q", test.name, tag.Size, len(tag.Frames), tag.text("TIT2"), test.size, test.frames, test.title)
+		}
+	}
+}
+
+func TestID3String(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding byte
+		data     []byte
+		want     string
+		rest     []byte
+	}{
+		{"ISO-8859-1", 0, []byte("caf\xe9\x00rest"), "café", []byte("rest")},
+		{"UTF-8", 3, []byte("caf\xc3\xa9\x00rest"), "café", []byte("rest")},
+		{"no terminator", 3, []byte(" title "), "title", []byte{}},
+		{"UTF-16 little endian", 1, []byte{0xff, 0xfe, 'h', 0, 'i', 0, 0, 0, 'x'}, "hi", []byte("x")},
+		{"UTF-16 big endian", 1, []byte{0xfe, 0xff, 0, 'h', 0, 'i', 0, 0}, "hi", []byte{}},
+		{"UTF-16BE without a byte order mark", 2, []byte{0, 'h', 0, 'i'}, "hi", []byte{}},
+		{"UTF-16 surrogate pair", 1, []byte{0xff, 0xfe, 0x3d, 0xd8, 0x99, 0xde}, "\U0001f699", []byte{}},
+		//The zero in the middle of a character isn't a terminator
+		{"UTF-16 odd zero", 1, []byte{0xff, 0xfe, 0, 1, 0, 0, 'x'}, "Ā", []byte("x")},
+		{"UTF-16 odd length", 1, []byte{0xff, 0xfe, 'h', 0, 'i'}, "h", []byte{}},
+		{"empty", 0, nil, "", []byte{}},
+		{"unknown encoding", 9, []byte("abc"), "abc", []byte{}},
+	}
+	for _, test := range tests {
+		got, rest := id3String(test.encoding, test.data)
+		if got != test.want || !bytes.Equal(rest, test.rest) {
+			t.Errorf("%s: got %q with %q left, want %q with %q", test.name, got, rest, test.want, test.rest)
+		}
+	}
+}
+
+func TestID3Chapters(t *testing.T) {
+	ms := time.Millisecond
+	wxxx := testID3Frame(3, "WXXX", 0, append([]byte{0, 'l', 'i', 'n', 'k', 0}, "https://example.com"...))
+	tests := []struct {
+		name    string
+		version byte
+		frames  [][]byte
+		want    []Chapter
+	}{
+		{"none", 3, [][]byte{testTextFrame(3, "TIT2", "x")}, []Chapter{}},
+		{"sorted by start without a table of contents", 3, [][]byte{
+			testChapFrame(3, "c2", 60000, 120000, testTextFrame(3, "TIT2", "Two")),
+			testChapFrame(3, "c1", 0, 60000, testTextFrame(3, "TIT2", "One"), wxxx),
+		}, []Chapter{
+			{Title: "One", Start: 0, End: 60000 * ms, URL: "https://example.com"},
+			{Title: "Two", Start: 60000 * ms, End: 120000 * ms},
+		}},
+		{"missing ends are filled in", 4, [][]byte{
+			testChapFrame(4, "c1", 0, 0, testTextFrame(4, "TIT2", "One")),
+			testChapFrame(4, "c2", 5000, 0, testTextFrame(4, "TIT3", "Two")),
+		}, []Chapter{
+			{Title: "One", Start: 0, End: 5000 * ms},
+			{Title: "Two", Start: 5000 * ms},
+		}},
+		{"table of contents decides the order", 3, [][]byte{
+			testCTOCFrame(3, "toc", 0x03, "c2", "missing", "c1"),
+			testChapFrame(3, "c1", 0, 1000, testTextFrame(3, "TIT2", "One")),
+			testChapFrame(3, "c2", 1000, 2000, testTextFrame(3, "TIT2", "Two")),
+		}, []Chapter{
+			{Title: "Two", Start: 1000 * ms, End: 2000 * ms},
+			{Title: "One", Start: 0, End: 1000 * ms},
+		}},
+		{"only the top level table of contents counts", 3, [][]byte{
+			testCTOCFrame(3, "sub", 0x01, "c2"),
+			testChapFrame(3, "c1", 0, 1000, testTextFrame(3, "TIT2", "One")),
+			testChapFrame(3, "c2", 1000, 2000, testTextFrame(3, "TIT2", "Two")),
+		}, []Chapter{
+			{Title: "One", Start: 0, End: 1000 * ms},
+			{Title: "Two", Start: 1000 * ms, End: 2000 * ms},
+		}},
+		{"table of contents with more children than it has", 3, [][]byte{
+			testID3Frame(3, "CTOC", 0, []byte{'t', 0, 0x02, 200, 'c', '1', 0}),
+			testChapFrame(3, "c1", 0, 1000, testTextFrame(3, "TIT2", "One")),
+		}, []Chapter{
+			{Title: "One", Start: 0, End: 1000 * ms},
+		}},
+		{"same ID twice", 3, [][]byte{
+			testChapFrame(3, "c1", 0, 1000, testTextFrame(3, "TIT2", "Old")),
+			testChapFrame(3, "c1", 0, 1000, testTextFrame(3, "TIT2", "New")),
+		}, []Chapter{
+			{Title: "New", Start: 0, End: 1000 * ms},
+		}},
+		{"cut off CHAP", 3, [][]byte{
+			testID3Frame(3, "CHAP", 0, []byte{'c', '1', 0, 0, 0, 0, 0}),
+			testID3Frame(3, "CHAP", 0, []byte{'c', '2'}),
+			testID3Frame(3, "CTOC", 0, []byte{'t', 0}),
+		}, []Chapter{}},
+		{"cut off sub-frames", 3, [][]byte{
+			testChapFrame(3, "c1", 0, 1000, testTextFrame(3, "TIT2", "One")[:12]),
+		}, []Chapter{
+			{Start: 0, End: 1000 * ms},
+		}},
+	}
+	for _, test := range tests {
+		tag, err := readID3(bytes.NewReader(testID3Tag(test.version, 0, test.frames...)))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := tag.chapters(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//What we can find out about a media file. Anything that couldn't be worked out is left empty
+type Metadata struct {
+	Title    string
+	Duration time.Duration
+	//Average bitrate in bits per second
+	Bitrate int
+	//Embedded artwork (e.g. the ID3 APIC frame) and its MIME type
+	Artwork     []byte
+	ArtworkType string
+}
+
+//The kinds of file that we know how to read
+const (
+	formatUnknown = iota
+	formatMP3
+	formatMP4
+)
+
+//Works out what kind of file something is from its first few bytes
+func detectFormat(header []byte) int {
+	switch {
+	case len(header) >= 3 && string(header[:3]) == "ID3":
+		return formatMP3
+	case len(header) >= 8 && string(header[4:8]) == "ftyp":
+		return formatMP4
+	case len(header) >= 2 && header[0] == 0xff && header[1]&0xe0 == 0xe0:
+		return formatMP3
+	}
+	return formatUnknown
+}
+
+//Opens a file and works out what kind of file it is
+func openMedia(filename string) (*os.File, int64, int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, formatUnknown, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, formatUnknown, err
+	}
+	header := make([]byte, 12)
+	n, _ := file.ReadAt(header, 0)
+	return file, info.Size(), detectFormat(header[:n]), nil
+}
+
+//Reads the duration, bitrate, title and artwork of an MP3 or MP4 file
+func ReadMetadata(filename string) (Metadata, error) {
+	file, size, format, err := openMedia(filename)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer file.Close()
+	var metadata Metadata
+	switch format {
+	case formatMP3:
+		var audioStart int64
+		if tag, err := readID3(file); err == nil {
+			audioStart = tag.Size
+			metadata.Title = tag.text("TIT2")
+			if ms, err := strconv.ParseInt(tag.text("TLEN"), 10, 64); err == nil && ms > 0 {
+				metadata.Duration = time.Duration(ms) * time.Millisecond
+			}
+			metadata.Artwork, metadata.ArtworkType = tag.artwork()
+		}
+		//The frames are more trustworthy than TLEN, which is often wrong
+		if duration, bitrate, ok := readMPEG(file, audioStart, size); ok {
+			metadata.Duration = duration
+			metadata.Bitrate = bitrate
+		}
+	case formatMP4:
+		moov, err := readMoov(file, size)
+		if err != nil {
+			return metadata, err
+		}
+		metadata.Duration = movieDuration(moov)
+		if seconds := metadata.Duration.Seconds(); seconds > 0 {
+			metadata.Bitrate = int(float64(size) * 8 / seconds)
+		}
+		metadata.Title, metadata.Artwork, metadata.ArtworkType = mp4Tags(moov)
+	}
+	return metadata, nil
+}
+
+//Gets the artwork from an APIC frame, preferring the front cover
+func (tag id3Tag) artwork() ([]byte, string) {
+	var data []byte
+	var mimeType string
+	for _, frame := range tag.Frames {
+		if frame.ID != "APIC" || len(frame.Data) < 4 {
+			continue
+		}
+		encoding := frame.Data[0]
+		mime, rest := id3String(0, frame.Data[1:])
+		if len(rest) < 1 {
+			continue
+		}
+		pictureType := rest[0]
+		_, picture := id3String(encoding, rest[1:])
+		if len(picture) == 0 {
+			continue
+		}
+		if !strings.Contains(mime, "/") {
+			//Old tags have things like JPG instead of a MIME type
+			mime = "image/" + strings.ToLower(strings.Replace(mime, "JPG", "jpeg", 1))
+		}
+		if data == nil || pictureType == 3 {
+			data, mimeType = picture, mime
+		}
+		if pictureType == 3 {
+			break
+		}
+	}
+	return data, mimeType
+}
+
+//Gets the title and cover from the iTunes style tags in moov/udta/meta/ilst
+func mp4Tags(moov []mp4Atom) (string, []byte, string) {
+	meta, ok := mp4Child(moov, "udta", "meta")
+	if !ok || len(meta.Data) < 4 {
+		return "", nil, ""
+	}
+	data := meta.Data
+	if bytes.Equal(data[:4], []byte{0, 0, 0, 0}) {
+		//meta is usually a full atom with a version and flags
+		data = data[4:]
+	}
+	ilst, ok := mp4Child(mp4Atoms(data), "ilst")
+	if !ok {
+		return "", nil, ""
+	}
+	var title, artworkType string
+	var artwork []byte
+	for _, item := range mp4Atoms(ilst.Data) {
+		value, ok := mp4Child(mp4Atoms(item.Data), "data")
+		if !ok || len(value.Data) < 8 {
+			continue
+		}
+		kind := binary.BigEndian.Uint32(value.Data) & 0xffffff
+		switch item.Type {
+		case "\xa9nam":
+			title = strings.TrimSpace(string(value.Data[8:]))
+		case "covr":
+			if artwork == nil {
+				artwork = value.Data[8:]
+				artworkType = "image/jpeg"
+				if kind == 14 {
+					artworkType = "image/png"
+				}
+			}
+		}
+	}
+	return title, artwork, artworkType
+}
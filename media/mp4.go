@@ -0,0 +1,340 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+	"unicode/utf16"
+)
+
+//An MP4 atom (box). Data doesn't include the header
+type mp4Atom struct {
+	Type string
+	Data []byte
+}
+
+//The moov atom is usually a few hundred KB, but don't read anything silly into memory
+const maxMoovSize = 64 << 20
+
+var errNoMoov = errors.New("no moov atom")
+
+//Splits some data up into the atoms it contains
+func mp4Atoms(data []byte) []mp4Atom {
+	atoms := make([]mp4Atom, 0)
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		header := uint64(8)
+		if size == 1 && len(data) >= 16 {
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		} else if size == 0 {
+			size = uint64(len(data))
+		}
+		if size < header || size > uint64(len(data)) {
+			break
+		}
+		atoms = append(atoms, mp4Atom{Type: string(data[4:8]), Data: data[header:size]})
+		data = data[size:]
+	}
+	return atoms
+}
+
+//Finds an atom by its path, e.g. mp4Child(moov, "udta", "chpl")
+func mp4Child(atoms []mp4Atom, path ...string) (mp4Atom, bool) {
+	for i, name := range path {
+		found := false
+		for _, atom := range atoms {
+			if atom.Type == name {
+				if i == len(path)-1 {
+					return atom, true
+				}
+				atoms = mp4Atoms(atom.Data)
+				found = true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return mp4Atom{}, false
+}
+
+//Reads the moov atom, which has everything but the media data in it. It can be at the
+//start or the end of the file, so we skip over everything else to find it
+func readMoov(r io.ReaderAt, size int64) ([]mp4Atom, error) {
+	header := make([]byte, 16)
+	for offset := int64(0); offset+8 <= size; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return nil, err
+		}
+		atomSize := int64(binary.BigEndian.Uint32(header))
+		headerSize := int64(8)
+		if atomSize == 1 {
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, err
+			}
+			atomSize = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		} else if atomSize == 0 {
+			atomSize = size - offset
+		}
+		if atomSize < headerSize {
+			break
+		}
+		if string(header[4:8]) == "moov" {
+			if atomSize > maxMoovSize {
+				return nil, errors.New("moov atom is too big")
+			}
+			data := make([]byte, atomSize-headerSize)
+			if _, err := r.ReadAt(data, offset+headerSize); err != nil && err != io.EOF {
+				return nil, err
+			}
+			return mp4Atoms(data), nil
+		}
+		offset += atomSize
+	}
+	return nil, errNoMoov
+}
+
+//Gets the chapters from an MP4 file. Nero chapters (moov/udta/chpl) are simplest so they're
+//used if they're there, otherwise we look for a QuickTime chapter track
+func readMP4Chapters(r io.ReaderAt, size int64) ([]Chapter, error) {
+	moov, err := readMoov(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if chpl, ok := mp4Child(moov, "udta", "chpl"); ok {
+		return neroChapters(chpl.Data, movieDuration(moov)), nil
+	}
+	return quickTimeChapters(r, moov), nil
+}
+
+//Gets the length of the whole movie from mvhd
+func movieDuration(moov []mp4Atom) time.Duration {
+	mvhd, ok := mp4Child(moov, "mvhd")
+	if !ok {
+		return 0
+	}
+	timescale, duration := headerTimes(mvhd.Data)
+	if timescale == 0 {
+		return 0
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+}
+
+//Gets the timescale and duration from an mvhd or mdhd atom
+func headerTimes(data []byte) (uint32, uint64) {
+	if len(data) < 1 {
+		return 0, 0
+	}
+	if data[0] == 1 {
+		if len(data) < 32 {
+			return 0, 0
+		}
+		return binary.BigEndian.Uint32(data[20:]), binary.BigEndian.Uint64(data[24:])
+	}
+	if len(data) < 20 {
+		return 0, 0
+	}
+	return binary.BigEndian.Uint32(data[12:]), uint64(binary.BigEndian.Uint32(data[16:]))
+}
+
+//Parses a Nero chpl atom. Start times are in 100ns units
+func neroChapters(data []byte, duration time.Duration) []Chapter {
+	chapters := make([]Chapter, 0)
+	if len(data) < 5 {
+		return chapters
+	}
+	offset := 4
+	if data[0] == 1 {
+		offset += 4
+	}
+	if offset >= len(data) {
+		return chapters
+	}
+	count := int(data[offset])
+	offset++
+	for i := 0; i < count && offset+9 <= len(data); i++ {
+		start := binary.BigEndian.Uint64(data[offset:])
+		length := int(data[offset+8])
+		offset += 9
+		if offset+length > len(data) {
+			break
+		}
+		chapters = append(chapters, Chapter{
+			Title: string(data[offset : offset+length]),
+			Start: time.Duration(start) * 100,
+		})
+		offset += length
+	}
+	sortChapters(chapters)
+	if len(chapters) > 0 && duration > chapters[len(chapters)-1].Start {
+		chapters[len(chapters)-1].End = duration
+	}
+	return chapters
+}
+
+//Gets the ID of a track from its tkhd atom
+func trackID(trak []mp4Atom) uint32 {
+	tkhd, ok := mp4Child(trak, "tkhd")
+	if !ok || len(tkhd.Data) < 1 {
+		return 0
+	}
+	offset := 12
+	if tkhd.Data[0] == 1 {
+		offset = 20
+	}
+	if len(tkhd.Data) < offset+4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(tkhd.Data[offset:])
+}
+
+//Reads the table in an stts, stsz, stsc, stco or co64 atom, skipping the version, flags and
+//entry count
+func mp4Table(atom mp4Atom, skip int, entrySize int) [][]byte {
+	data := atom.Data
+	if len(data) < skip+4 {
+		return nil
+	}
+	count := int(binary.BigEndian.Uint32(data[skip:]))
+	data = data[skip+4:]
+	entries := make([][]byte, 0)
+	for i := 0; i < count && len(data) >= entrySize; i++ {
+		entries = append(entries, data[:entrySize])
+		data = data[entrySize:]
+	}
+	return entries
+}
+
+//Gets chapters from a QuickTime chapter track. A track points at its chapter track with a
+//tref/chap atom, and each sample in the chapter track is the title of a chapter
+func quickTimeChapters(r io.ReaderAt, moov []mp4Atom) []Chapter {
+	chapters := make([]Chapter, 0)
+	tracks := make(map[uint32][]mp4Atom)
+	var chapterTrack uint32
+	for _, atom := range moov {
+		if atom.Type != "trak" {
+			continue
+		}
+		trak := mp4Atoms(atom.Data)
+		tracks[trackID(trak)] = trak
+		if chap, ok := mp4Child(trak, "tref", "chap"); ok && len(chap.Data) >= 4 && chapterTrack == 0 {
+			chapterTrack = binary.BigEndian.Uint32(chap.Data)
+		}
+	}
+	trak, ok := tracks[chapterTrack]
+	if chapterTrack == 0 || !ok {
+		return chapters
+	}
+	mdhd, _ := mp4Child(trak, "mdia", "mdhd")
+	timescale, duration := headerTimes(mdhd.Data)
+	stbl, ok := mp4Child(trak, "mdia", "minf", "stbl")
+	if timescale == 0 || !ok {
+		return chapters
+	}
+	sampleTable := mp4Atoms(stbl.Data)
+
+	//When each sample starts
+	starts := make([]uint64, 0)
+	var t uint64
+	stts, _ := mp4Child(sampleTable, "stts")
+	for _, entry := range mp4Table(stts, 4, 8) {
+		count, delta := binary.BigEndian.Uint32(entry), binary.BigEndian.Uint32(entry[4:])
+		for i := uint32(0); i < count && len(starts) < 10000; i++ {
+			starts = append(starts, t)
+			t += uint64(delta)
+		}
+	}
+
+	//Where each sample is
+	offsets := make([]uint64, 0)
+	chunks := make([]uint64, 0)
+	if stco, ok := mp4Child(sampleTable, "stco"); ok {
+		for _, entry := range mp4Table(stco, 4, 4) {
+			chunks = append(chunks, uint64(binary.BigEndian.Uint32(entry)))
+		}
+	} else if co64, ok := mp4Child(sampleTable, "co64"); ok {
+		for _, entry := range mp4Table(co64, 4, 8) {
+			chunks = append(chunks, binary.BigEndian.Uint64(entry))
+		}
+	}
+	stsz, _ := mp4Child(sampleTable, "stsz")
+	var fixedSize uint32
+	if len(stsz.Data) >= 8 {
+		fixedSize = binary.BigEndian.Uint32(stsz.Data[4:])
+	}
+	sizes := mp4Table(stsz, 8, 4)
+	sampleSize := func(i int) uint64 {
+		if fixedSize != 0 {
+			return uint64(fixedSize)
+		}
+		if i < len(sizes) {
+			return uint64(binary.BigEndian.Uint32(sizes[i]))
+		}
+		return 0
+	}
+	stsc, _ := mp4Child(sampleTable, "stsc")
+	runs := mp4Table(stsc, 4, 12)
+	sample := 0
+	for i, run := range runs {
+		firstChunk := int(binary.BigEndian.Uint32(run)) - 1
+		perChunk := int(binary.BigEndian.Uint32(run[4:]))
+		lastChunk := len(chunks)
+		if i+1 < len(runs) {
+			lastChunk = int(binary.BigEndian.Uint32(runs[i+1])) - 1
+		}
+		for chunk := firstChunk; chunk >= 0 && chunk < lastChunk && chunk < len(chunks); chunk++ {
+			offset := chunks[chunk]
+			for j := 0; j < perChunk && sample < len(starts); j++ {
+				offsets = append(offsets, offset)
+				offset += sampleSize(sample)
+				sample++
+			}
+		}
+	}
+
+	for i, offset := range offsets {
+		size := sampleSize(i)
+		if size < 2 || size > 4096 {
+			continue
+		}
+		data := make([]byte, size)
+		//Samples that point past the end of the file are skipped rather than read as zeros
+		if n, _ := r.ReadAt(data, int64(offset)); n < len(data) {
+			continue
+		}
+		chapters = append(chapters, Chapter{
+			Title: chapterText(data),
+			Start: time.Duration(float64(starts[i]) / float64(timescale) * float64(time.Second)),
+		})
+	}
+	sortChapters(chapters)
+	if len(chapters) > 0 {
+		end := time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+		if end > chapters[len(chapters)-1].Start {
+			chapters[len(chapters)-1].End = end
+		}
+	}
+	return chapters
+}
+
+//Gets the text out of a text sample, which is a 16 bit length followed by either UTF-8 or
+//UTF-16 (with a byte order mark)
+func chapterText(data []byte) string {
+	length := int(binary.BigEndian.Uint16(data))
+	text := data[2:]
+	if length < len(text) {
+		text = text[:length]
+	}
+	if len(text) >= 2 && text[0] == 0xfe && text[1] == 0xff {
+		units := make([]uint16, (len(text)-2)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(text[2+2*i:])
+		}
+		return string(utf16.Decode(units))
+	}
+	return string(text)
+}
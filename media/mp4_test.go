@@ -0,0 +1,231 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//Makes an atom out of its type and contents
+func testAtom(kind string, contents ...[]byte) []byte {
+	body := bytes.Join(contents, nil)
+	atom := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(atom, kind...), body...)
+}
+
+//Makes a run of big endian 32 bit numbers, for the fixed size parts of atoms
+func testUint32s(numbers ...uint32) []byte {
+	b := make([]byte, 0, 4*len(numbers))
+	for _, n := range numbers {
+		b = binary.BigEndian.AppendUint32(b, n)
+	}
+	return b
+}
+
+var testFtyp = testAtom("ftyp", []byte("M4A "), testUint32s(0), []byte("M4A isom"))
+
+//An mvhd or mdhd atom (version 0)
+func testHeaderAtom(kind string, timescale, duration uint32) []byte {
+	return testAtom(kind, testUint32s(0, 0, 0, timescale, duration))
+}
+
+//A Nero chpl atom. Start times are in 100ns units
+func testChpl(version byte, titles []string, starts []uint64) []byte {
+	body := []byte{version, 0, 0, 0}
+	if version == 1 {
+		body = append(body, 0, 0, 0, 0)
+	}
+	body = append(body, byte(len(titles)))
+	for i, title := range titles {
+		body = binary.BigEndian.AppendUint64(body, starts[i])
+		body = append(append(body, byte(len(title))), title...)
+	}
+	return testAtom("chpl", body)
+}
+
+func TestMP4Atoms(t *testing.T) {
+	large := append(testUint32s(1), "free"...)
+	large = binary.BigEndian.AppendUint64(large, 20)
+	large = append(large, 1, 2, 3, 4)
+	tests := []struct {
+		name  string
+		data  []byte
+		types []string
+	}{
+		{"empty", nil, []string{}},
+		{"two atoms", append(testAtom("free"), testAtom("skip", []byte{1, 2})...), []string{"free", "skip"}},
+		{"64 bit size", append(large, testAtom("free")...), []string{"free", "free"}},
+		{"size 0 runs to the end", append(testUint32s(0), "mdat\x01\x02"...), []string{"mdat"}},
+		{"size smaller than the header", append(testUint32s(4), "free"...), []string{}},
+		{"size bigger than the data", append(testAtom("free"), append(testUint32s(100), "mdat"...)...), []string{"free"}},
+		{"cut off header", append(testAtom("free"), 0, 0, 0), []string{"free"}},
+		{"cut off 64 bit size", append(testUint32s(1), "free\x00\x00"...), []string{}},
+	}
+	for _, test := range tests {
+		types := []string{}
+		for _, atom := range mp4Atoms(test.data) {
+			types = append(types, atom.Type)
+		}
+		if !reflect.DeepEqual(types, test.types) {
+			t.Errorf("%s: got %v, want %v", test.name, types, test.types)
+		}
+	}
+}
+
+func TestNeroChapters(t *testing.T) {
+	second := uint64(time.Second / 100)
+	tests := []struct {
+		name string
+		file []byte
+		want []Chapter
+		err  error
+	}{
+		{"version 1", bytes.Join([][]byte{testFtyp, testAtom("moov",
+			testHeaderAtom("mvhd", 1000, 90000),
+			testAtom("udta", testChpl(1, []string{"Two", "One", "Three"}, []uint64{30 * second, 0, 60 * second})),
+		)}, nil), []Chapter{
+			{Title: "One", Start: 0, End: 30 * time.Second},
+			{Title: "Two", Start: 30 * time.Second, End: 60 * time.Second},
+			{Title: "Three", Start: 60 * time.Second, End: 90 * time.Second},
+		}, nil},
+		{"version 0 without a duration", bytes.Join([][]byte{testFtyp, testAtom("moov",
+			testAtom("udta", testChpl(0, []string{"One", "Two"}, []uint64{0, 10 * second})),
+		)}, nil), []Chapter{
+			{Title: "One", Start: 0, End: 10 * time.Second},
+			{Title: "Two", Start: 10 * time.Second},
+		}, nil},
+		{"moov at the end", bytes.Join([][]byte{testFtyp, testAtom("mdat", make([]byte, 1000)), testAtom("moov",
+			testAtom("udta", testChpl(1, []string{"One"}, []uint64{0})),
+		)}, nil), []Chapter{
+			{Title: "One"},
+		}, nil},
+		{"count bigger than the chapters", bytes.Join([][]byte{testFtyp, testAtom("moov",
+			testAtom("udta", testAtom("chpl", []byte{1, 0, 0, 0, 0, 0, 0, 0, 200}, testUint32s(0, 0), []byte{3}, []byte("One"))),
+		)}, nil), []Chapter{
+			{Title: "One"},
+		}, nil},
+		{"title longer than the atom", bytes.Join([][]byte{testFtyp, testAtom("moov",
+			testAtom("udta", testAtom("chpl", []byte{1, 0, 0, 0, 0, 0, 0, 0, 2}, testUint32s(0, 0), []byte{3}, []byte("One"), testUint32s(0, 1), []byte{200}, []byte("Two"))),
+		)}, nil), []Chapter{
+			{Title: "One"},
+		}, nil},
+		{"cut off chpl", bytes.Join([][]byte{testFtyp, testAtom("moov",
+			testAtom("udta", testAtom("chpl", []byte{1, 0, 0, 0, 0, 0})),
+		)}, nil), []Chapter{}, nil},
+		{"no chapters", bytes.Join([][]byte{testFtyp, testAtom("moov", testHeaderAtom("mvhd", 1000, 90000))}, nil), []Chapter{}, nil},
+		{"no moov", bytes.Join([][]byte{testFtyp, testAtom("mdat", make([]byte, 100))}, nil), nil, errNoMoov},
+		{"broken atom size", append(testFtyp, testUint32s(3, 0)...), nil, errNoMoov},
+	}
+	for _, test := range tests {
+		got, err := readMP4Chapters(bytes.NewReader(test.file), int64(len(test.file)))
+		if err != test.err {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.err)
+		} else if err == nil && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+//Makes an MP4 with a QuickTime chapter track. The samples are put in an mdat straight after
+//the ftyp, and stbl is given the atoms that say where they are
+func testQuickTimeFile(chapterTrack uint32, samples [][]byte, stbl func(offset uint32) [][]byte) []byte {
+	offset := uint32(len(testFtyp) + 8)
+	audio := testAtom("trak",
+		testAtom("tkhd", testUint32s(0, 0, 0, 1)),
+		testAtom("tref", testAtom("chap", testUint32s(chapterTrack))),
+	)
+	text := testAtom("trak",
+		testAtom("tkhd", testUint32s(0, 0, 0, 2)),
+		testAtom("mdia",
+			testHeaderAtom("mdhd", 1000, 90000),
+			testAtom("minf", testAtom("stbl", stbl(offset)...)),
+		),
+	)
+	return bytes.Join([][]byte{testFtyp, testAtom("mdat", samples...), testAtom("moov", audio, text)}, nil)
+}
+
+//A text sample: a 16 bit length and then the text
+func testTextSample(text []byte) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(text))), text...)
+}
+
+func TestQuickTimeChapters(t *testing.T) {
+	one, two := testTextSample([]byte("One")), testTextSample([]byte{0xfe, 0xff, 0, 'T', 0, 'w', 0, 'o'})
+	three := testTextSample([]byte("Three"))
+	samples := [][]byte{one, two, three}
+	sizes := testUint32s(uint32(len(one)), uint32(len(two)), uint32(len(three)))
+	stts := testAtom("stts", testUint32s(0, 3, 1, 30000, 1, 20000, 1, 40000))
+	want := []Chapter{
+		{Title: "One", Start: 0, End: 30 * time.Second},
+		{Title: "Two", Start: 30 * time.Second, End: 50 * time.Second},
+		{Title: "Three", Start: 50 * time.Second, End: 90 * time.Second},
+	}
+	tests := []struct {
+		name  string
+		track uint32
+		stbl  func(offset uint32) [][]byte
+		want  []Chapter
+	}{
+		{"one chunk", 2, func(offset uint32) [][]byte {
+			return [][]byte{stts, testAtom("stsz", testUint32s(0, 0, 3), sizes), testAtom("stsc", testUint32s(0, 1, 1, 3, 1)), testAtom("stco", testUint32s(0, 1, offset))}
+		}, want},
+		{"a chunk for each sample with 64 bit offsets", 2, func(offset uint32) [][]byte {
+			co64 := testUint32s(0, 3, 0, offset, 0, offset+uint32(len(one)), 0, offset+uint32(len(one)+len(two)))
+			return [][]byte{stts, testAtom("stsz", testUint32s(0, 0, 3), sizes), testAtom("stsc", testUint32s(0, 1, 1, 1, 1)), testAtom("co64", co64)}
+		}, want},
+		{"runs of chunks", 2, func(offset uint32) [][]byte {
+			chunks := testUint32s(0, 2, offset, offset+uint32(len(one)))
+			return [][]byte{stts, testAtom("stsz", testUint32s(0, 0, 3), sizes), testAtom("stsc", testUint32s(0, 2, 1, 1, 1, 2, 2, 1)), testAtom("stco", chunks)}
+		}, want},
+		{"no chapter track", 0, func(offset uint32) [][]byte {
+			return [][]byte{stts}
+		}, []Chapter{}},
+		{"chapter track that isn't there", 7, func(offset uint32) [][]byte {
+			return [][]byte{stts}
+		}, []Chapter{}},
+		{"no sample table entries", 2, func(offset uint32) [][]byte {
+			return [][]byte{testAtom("stts", testUint32s(0, 0))}
+		}, []Chapter{}},
+		{"counts bigger than the tables", 2, func(offset uint32) [][]byte {
+			return [][]byte{testAtom("stts", testUint32s(0, 100, 1, 30000)), testAtom("stsz", testUint32s(0, 0, 100), sizes[:4]), testAtom("stsc", testUint32s(0, 100, 1, 3, 1)), testAtom("stco", testUint32s(0, 100, offset))}
+		}, []Chapter{
+			{Title: "One", Start: 0, End: 90 * time.Second},
+		}},
+		{"chunk before the start", 2, func(offset uint32) [][]byte {
+			return [][]byte{stts, testAtom("stsz", testUint32s(0, 0, 3), sizes), testAtom("stsc", testUint32s(0, 1, 0, 3, 1)), testAtom("stco", testUint32s(0, 1, offset))}
+		}, []Chapter{}},
+		{"samples past the end of the file", 2, func(offset uint32) [][]byte {
+			return [][]byte{stts, testAtom("stsz", testUint32s(0, 0, 3), sizes), testAtom("stsc", testUint32s(0, 1, 1, 3, 1)), testAtom("stco", testUint32s(0, 1, 1<<30))}
+		}, []Chapter{}},
+	}
+	for _, test := range tests {
+		file := testQuickTimeFile(test.track, samples, test.stbl)
+		got, err := readMP4Chapters(bytes.NewReader(file), int64(len(file)))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestChapterText(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want string
+	}{
+		{[]byte{0, 3, 'O', 'n', 'e'}, "One"},
+		{[]byte{0, 3, 'O', 'n', 'e', 0, 0, 0, 0}, "One"},
+		{[]byte{0, 100, 'O', 'n', 'e'}, "One"},
+		{[]byte{0, 0}, ""},
+		{[]byte{0, 6, 0xfe, 0xff, 0, 'h', 0, 'i'}, "hi"},
+		{[]byte{0, 5, 0xfe, 0xff, 0, 'h', 0}, "h"},
+	}
+	for _, test := range tests {
+		if got := chapterText(test.data); got != test.want {
+			t.Errorf("chapterText(%v) = %q, want %q", test.data, got, test.want)
+		}
+	}
+}
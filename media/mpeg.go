@@ -0,0 +1,152 @@
+package media
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+//Bitrates in kbps, indexed by [MPEG 1 or not][layer - 1][bitrate index]
+var mpegBitrates = [2][3][15]int{
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+}
+
+//Sample rates indexed by the version bits (MPEG 2.5, reserved, MPEG 2, MPEG 1)
+var mpegSampleRates = [4][3]int{
+	{11025, 12000, 8000},
+	{0, 0, 0},
+	{22050, 24000, 16000},
+	{44100, 48000, 32000},
+}
+
+//An MPEG audio frame header
+type mpegFrame struct {
+	Version    byte
+	Layer      int
+	Bitrate    int
+	SampleRate int
+	Padding    int
+	Mono       bool
+}
+
+//Parses a frame header, returning false if it isn't one
+func parseMPEGFrame(b []byte) (mpegFrame, bool) {
+	if len(b) < 4 || b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return mpegFrame{}, false
+	}
+	frame := mpegFrame{Version: (b[1] >> 3) & 3, Layer: 4 - int((b[1]>>1)&3)}
+	bitrateIndex, sampleRateIndex := b[2]>>4, (b[2]>>2)&3
+	if frame.Version == 1 || frame.Layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		return mpegFrame{}, false
+	}
+	table := 0
+	if frame.Version != 3 {
+		table = 1
+	}
+	frame.Bitrate = mpegBitrates[table][frame.Layer-1][bitrateIndex] * 1000
+	frame.SampleRate = mpegSampleRates[frame.Version][sampleRateIndex]
+	frame.Padding = int((b[2] >> 1) & 1)
+	frame.Mono = b[3]>>6 == 3
+	return frame, true
+}
+
+//How many samples there are in each frame
+func (frame mpegFrame) samples() int {
+	switch {
+	case frame.Layer == 1:
+		return 384
+	case frame.Layer == 3 && frame.Version != 3:
+		return 576
+	}
+	return 1152
+}
+
+//How many bytes the frame takes up, including its header
+func (frame mpegFrame) length() int {
+	if frame.Layer == 1 {
+		return (12*frame.Bitrate/frame.SampleRate + frame.Padding) * 4
+	}
+	return frame.samples()/8*frame.Bitrate/frame.SampleRate + frame.Padding
+}
+
+//Where the Xing/Info header would be, which is just after the side information
+func (frame mpegFrame) xingOffset() int {
+	if frame.Version == 3 {
+		if frame.Mono {
+			return 4 + 17
+		}
+		return 4 + 32
+	}
+	if frame.Mono {
+		return 4 + 9
+	}
+	return 4 + 17
+}
+
+//How far into the file to look for the first frame
+const mpegSearchSize = 64 << 10
+
+//Works out the duration and average bitrate of MPEG audio. VBR files normally have a Xing or
+//VBRI header in their first frame that says how many frames there are; without one the file
+//is assumed to be CBR
+func readMPEG(r io.ReaderAt, start, size int64) (time.Duration, int, bool) {
+	buf := make([]byte, mpegSearchSize)
+	n, _ := r.ReadAt(buf, start)
+	buf = buf[:n]
+	for i := 0; i+4 <= len(buf); i++ {
+		frame, ok := parseMPEGFrame(buf[i:])
+		if !ok {
+			continue
+		}
+		//Make sure that this wasn't just some data that looked like a frame by checking that
+		//another frame comes straight after it
+		next := i + frame.length()
+		if next+4 <= len(buf) {
+			if _, ok := parseMPEGFrame(buf[next:]); !ok {
+				continue
+			}
+		}
+		audioBytes := size - start - int64(i)
+		if size >= 128 {
+			tail := make([]byte, 3)
+			if _, err := r.ReadAt(tail, size-128); err == nil && string(tail) == "TAG" {
+				//ID3v1 tag at the end
+				audioBytes -= 128
+			}
+		}
+		var frames, bytes int64
+		if x := i + frame.xingOffset(); x+16 <= len(buf) && (string(buf[x:x+4]) == "Xing" || string(buf[x:x+4]) == "Info") {
+			flags := binary.BigEndian.Uint32(buf[x+4:])
+			x += 8
+			if flags&1 != 0 {
+				frames = int64(binary.BigEndian.Uint32(buf[x:]))
+				x += 4
+			}
+			if flags&2 != 0 && x+4 <= len(buf) {
+				bytes = int64(binary.BigEndian.Uint32(buf[x:]))
+			}
+		} else if v := i + 36; v+18 <= len(buf) && string(buf[v:v+4]) == "VBRI" {
+			bytes = int64(binary.BigEndian.Uint32(buf[v+10:]))
+			frames = int64(binary.BigEndian.Uint32(buf[v+14:]))
+		}
+		if frames > 0 {
+			seconds := float64(frames) * float64(frame.samples()) / float64(frame.SampleRate)
+			if bytes <= 0 {
+				bytes = audioBytes
+			}
+			return time.Duration(seconds * float64(time.Second)), int(float64(bytes) * 8 / seconds), true
+		}
+		seconds := float64(audioBytes) * 8 / float64(frame.Bitrate)
+		return time.Duration(seconds * float64(time.Second)), frame.Bitrate, true
+	}
+	return 0, 0, false
+}
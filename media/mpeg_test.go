@@ -0,0 +1,122 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestParseMPEGFrame(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   mpegFrame
+		ok     bool
+		length int
+	}{
+		{"MPEG 1 layer 3", []byte{0xff, 0xfb, 0x90, 0x00}, mpegFrame{Version: 3, Layer: 3, Bitrate: 128000, SampleRate: 44100}, true, 417},
+		{"padding and mono", []byte{0xff, 0xfb, 0x92, 0xc0}, mpegFrame{Version: 3, Layer: 3, Bitrate: 128000, SampleRate: 44100, Padding: 1, Mono: true}, true, 418},
+		{"MPEG 1 layer 2", []byte{0xff, 0xfd, 0x94, 0x00}, mpegFrame{Version: 3, Layer: 2, Bitrate: 160000, SampleRate: 48000}, true, 480},
+		{"MPEG 1 layer 1", []byte{0xff, 0xff, 0x98, 0x00}, mpegFrame{Version: 3, Layer: 1, Bitrate: 288000, SampleRate: 32000}, true, 432},
+		{"MPEG 2 layer 3", []byte{0xff, 0xf3, 0x80, 0xc0}, mpegFrame{Version: 2, Layer: 3, Bitrate: 64000, SampleRate: 22050, Mono: true}, true, 208},
+		{"MPEG 2.5 layer 3", []byte{0xff, 0xe3, 0x40, 0x00}, mpegFrame{Version: 0, Layer: 3, Bitrate: 32000, SampleRate: 11025}, true, 208},
+		{"reserved version", []byte{0xff, 0xeb, 0x90, 0x00}, mpegFrame{}, false, 0},
+		{"reserved layer", []byte{0xff, 0xf9, 0x90, 0x00}, mpegFrame{}, false, 0},
+		{"free bitrate", []byte{0xff, 0xfb, 0x00, 0x00}, mpegFrame{}, false, 0},
+		{"bad bitrate", []byte{0xff, 0xfb, 0xf0, 0x00}, mpegFrame{}, false, 0},
+		{"reserved sample rate", []byte{0xff, 0xfb, 0x9c, 0x00}, mpegFrame{}, false, 0},
+		{"no sync", []byte{0xff, 0x1b, 0x90, 0x00}, mpegFrame{}, false, 0},
+		{"ID3", []byte("ID3\x03"), mpegFrame{}, false, 0},
+		{"too short", []byte{0xff, 0xfb, 0x90}, mpegFrame{}, false, 0},
+	}
+	for _, test := range tests {
+		got, ok := parseMPEGFrame(test.header)
+		if ok != test.ok || got != test.want {
+			t.Errorf("%s: got %+v, %v, want %+v, %v", test.name, got, ok, test.want, test.ok)
+		} else if ok && got.length() != test.length {
+			t.Errorf("%s: length() = %d, want %d", test.name, got.length(), test.length)
+		}
+	}
+}
+
+//Makes some frames of silence with the given header. The first frame's body can be given,
+//for Xing and VBRI headers
+func testMPEGFrames(header []byte, count int, first []byte) []byte {
+	frame, _ := parseMPEGFrame(header)
+	frames := make([]byte, 0, count*frame.length())
+	for i := 0; i < count; i++ {
+		body := make([]byte, frame.length())
+		copy(body, header)
+		if i == 0 {
+			copy(body[4:], first)
+		}
+		frames = append(frames, body...)
+	}
+	return frames
+}
+
+//A Xing or Info header at the given offset into the frame, saying how many frames and bytes
+//there are
+func testXing(offset int, tag string, flags, frames, bytes uint32) []byte {
+	xing := make([]byte, offset-4)
+	xing = append(append(xing, tag...), testUint32s(flags)...)
+	if flags&1 != 0 {
+		xing = binary.BigEndian.AppendUint32(xing, frames)
+	}
+	if flags&2 != 0 {
+		xing = binary.BigEndian.AppendUint32(xing, bytes)
+	}
+	return xing
+}
+
+//A VBRI header, which is always 32 bytes after the side information
+func testVBRI(frames, bytes uint32) []byte {
+	vbri := append(make([]byte, 32), "VBRI\x00\x01\x00\x00\x00\x50"...)
+	return append(vbri, testUint32s(bytes, frames)...)
+}
+
+func TestReadMPEG(t *testing.T) {
+	stereo, mono := []byte{0xff, 0xfb, 0x90, 0x00}, []byte{0xff, 0xf3, 0x80, 0xc0}
+	id3v1 := append([]byte("TAG"), make([]byte, 125)...)
+	//A false sync: something that looks like a frame header without another frame after it
+	junk := append([]byte{0, 0xff, 0xfb, 0x90, 0x00}, make([]byte, 500)...)
+	cbr := func(bytes int) time.Duration {
+		return time.Duration(float64(bytes) * 8 / 128000 * float64(time.Second))
+	}
+	vbr := func(frames, samples, sampleRate int) time.Duration {
+		return time.Duration(float64(frames) * float64(samples) / float64(sampleRate) * float64(time.Second))
+	}
+	average := func(bytes, frames, samples, sampleRate int) int {
+		return int(float64(bytes) * 8 / (float64(frames) * float64(samples) / float64(sampleRate)))
+	}
+	tests := []struct {
+		name     string
+		data     []byte
+		start    int64
+		duration time.Duration
+		bitrate  int
+		ok       bool
+	}{
+		{"CBR", testMPEGFrames(stereo, 100, nil), 0, cbr(41700), 128000, true},
+		{"CBR after a tag", append(make([]byte, 1000), testMPEGFrames(stereo, 100, nil)...), 1000, cbr(41700), 128000, true},
+		{"CBR with an ID3v1 tag", append(testMPEGFrames(stereo, 100, nil), id3v1...), 0, cbr(41700), 128000, true},
+		{"CBR after junk", append(junk, testMPEGFrames(stereo, 100, nil)...), 0, cbr(41700), 128000, true},
+		{"single frame", testMPEGFrames(stereo, 1, nil), 0, cbr(417), 128000, true},
+		{"Xing", testMPEGFrames(stereo, 10, testXing(36, "Xing", 3, 1000, 400000)), 0, vbr(1000, 1152, 44100), average(400000, 1000, 1152, 44100), true},
+		{"Info without a byte count", testMPEGFrames(stereo, 10, testXing(36, "Info", 1, 1000, 0)), 0, vbr(1000, 1152, 44100), average(4170, 1000, 1152, 44100), true},
+		{"Xing without a frame count", testMPEGFrames(stereo, 10, testXing(36, "Xing", 2, 0, 400000)), 0, cbr(4170), 128000, true},
+		{"MPEG 2 mono Xing", testMPEGFrames(mono, 10, testXing(13, "Xing", 1, 500, 0)), 0, vbr(500, 576, 22050), average(2080, 500, 576, 22050), true},
+		{"VBRI", testMPEGFrames(stereo, 10, testVBRI(1000, 400000)), 0, vbr(1000, 1152, 44100), average(400000, 1000, 1152, 44100), true},
+		{"cut off Xing", testMPEGFrames(stereo, 1, testXing(36, "Xing", 3, 1000, 400000))[:48], 0, cbr(48), 128000, true},
+		{"not MPEG", bytes.Repeat([]byte{0x12, 0x34}, 1000), 0, 0, 0, false},
+		{"only junk", junk, 0, 0, 0, false},
+		{"empty", nil, 0, 0, 0, false},
+	}
+	for _, test := range tests {
+		duration, bitrate, ok := readMPEG(bytes.NewReader(test.data), test.start, int64(len(test.data)))
+		if ok != test.ok || duration != test.duration || bitrate != test.bitrate {
+			t.Errorf("%s: got %v at %d bps (%v), want %v at %d bps (%v)", test.name, duration, bitrate, ok, test.duration, test.bitrate, test.ok)
+		}
+	}
+}
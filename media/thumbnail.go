@@ -0,0 +1,99 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+//The most pixels an image can have. Images say how big they are before any pixels are read,
+//so a tiny file claiming to be enormous can't make us allocate gigabytes decoding it
+const MaxImagePixels = 40 * 1000 * 1000
+
+var ErrImageTooBig = errors.New("image is too big")
+
+//Checks that some data is a JPEG, PNG or GIF image that isn't too big to decode, and says which
+//of them it is
+func ImageFormat(r io.Reader) (string, error) {
+	config, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return "", err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > MaxImagePixels/config.Height {
+		return "", ErrImageTooBig
+	}
+	return format, nil
+}
+
+//Decodes a JPEG, PNG or GIF image, along with which of them it is
+func DecodeImage(data []byte) (image.Image, string, error) {
+	if _, err := ImageFormat(bytes.NewReader(data)); err != nil {
+		return nil, "", err
+	}
+	return image.Decode(bytes.NewReader(data))
+}
+
+//Shrinks an image so that its longest side is at most size pixels, keeping its shape.
+//Images that are already small enough are left the same size. Transparent parts end up white
+//because thumbnails are saved as JPEGs
+func Thumbnail(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, bounds.Dy()*size/bounds.Dx())
+		} else {
+			width, height = max(1, bounds.Dx()*size/bounds.Dy()), size
+		}
+	}
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Over)
+	return shrink(src, width, height)
+}
+
+//Resizes an image down by averaging the pixels that end up in each new pixel, which looks a
+//lot better than just picking one of them
+func shrink(src *image.RGBA, width, height int) *image.RGBA {
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if width == srcWidth && height == srcHeight {
+		copy(dst.Pix, src.Pix)
+		return dst
+	}
+	for y := 0; y < height; y++ {
+		y0, y1 := y*srcHeight/height, max((y+1)*srcHeight/height, y*srcHeight/height+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := x*srcWidth/width, max((x+1)*srcWidth/width, x*srcWidth/width+1)
+			var r, g, b, count int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					r += int(row[sx*4])
+					g += int(row[sx*4+1])
+					b += int(row[sx*4+2])
+					count++
+				}
+			}
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / count)
+			dst.Pix[i+1] = uint8(g / count)
+			dst.Pix[i+2] = uint8(b / count)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+	return dst
+}
+
+//Encodes a thumbnail as a JPEG
+func EncodeThumbnail(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	return buf.Bytes(), err
+}
@@ -0,0 +1,216 @@
+package media
+
+import (
+	"encoding/json"
+	"errors"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//A line (or a few) of a transcript. Transcripts without timings (e.g. plain HTML ones) have
+//cues that all start at 0
+type Cue struct {
+	Start   time.Duration
+	End     time.Duration
+	Speaker string
+	Text    string
+}
+
+//Gets the start of the cue like 13:37 or 1:02:03
+func (cue Cue) StartText() string {
+	return FormatTime(cue.Start)
+}
+
+//Transcript formats, in order of preference. Formats with timings are better because they
+//can follow along with the player
+var TranscriptTypes = []string{"application/json", "text/vtt", "application/x-subrip", "application/srt", "text/html", "text/plain"}
+
+var errUnknownTranscript = errors.New("unknown transcript format")
+
+//Parses a transcript. The type is the MIME type given in the feed, but feeds get these wrong
+//often enough that the content is checked as well
+func ParseTranscript(data []byte, mimeType string) ([]Cue, error) {
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	trimmed := strings.TrimSpace(text)
+	var cues []Cue
+	var err error
+	switch {
+	case strings.HasPrefix(trimmed, "WEBVTT"):
+		cues = parseTimedText(text, true)
+	case strings.Contains(mimeType, "json"):
+		cues, err = parseJSONTranscript(data)
+	case strings.Contains(mimeType, "vtt"):
+		cues = parseTimedText(text, true)
+	case strings.Contains(mimeType, "srt") || strings.Contains(mimeType, "subrip"):
+		cues = parseTimedText(text, false)
+	case strings.Contains(mimeType, "html"):
+		cues = parseHTMLTranscript(text)
+	case strings.HasPrefix(trimmed, "{"):
+		cues, err = parseJSONTranscript(data)
+	case timingLine.MatchString(text):
+		cues = parseTimedText(text, false)
+	case strings.HasPrefix(trimmed, "<"):
+		cues = parseHTMLTranscript(text)
+	case strings.HasPrefix(mimeType, "text/"):
+		cues = parsePlainTranscript(text)
+	default:
+		return nil, errUnknownTranscript
+	}
+	if err != nil {
+		return nil, err
+	}
+	fillCueEnds(cues)
+	return cues, nil
+}
+
+//Gives cues without an end the start of the next cue as their end
+func fillCueEnds(cues []Cue) {
+	for i := range cues {
+		if cues[i].End <= cues[i].Start && i+1 < len(cues) && cues[i+1].Start > cues[i].Start {
+			cues[i].End = cues[i+1].Start
+		}
+	}
+}
+
+//Parses a timestamp like 01:02:03,456 (SRT), 02:03.456 (WebVTT) or 1:02:03 (HTML)
+func parseTimestamp(s string) (time.Duration, bool) {
+	parts := strings.Split(strings.Replace(strings.TrimSpace(s), ",", ".", 1), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+	var seconds float64
+	for _, part := range parts {
+		t, err := strconv.ParseFloat(part, 64)
+		if err != nil || t < 0 {
+			return 0, false
+		}
+		seconds = seconds*60 + t
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+var timingLine = regexp.MustCompile(`([\d:.,]+)\s*-->\s*([\d:.,]+)`)
+var voiceTag = regexp.MustCompile(`^<v(?:\.[^ >]*)?\s+([^>]*)>`)
+var tags = regexp.MustCompile(`<[^>]*>`)
+
+//Parses SRT and WebVTT, which are both blocks of a timing line followed by some text
+func parseTimedText(text string, vtt bool) []Cue {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	cues := make([]Cue, 0)
+	for _, block := range regexp.MustCompile(`\n\s*\n`).Split(text, -1) {
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+		timing := -1
+		for i, line := range lines {
+			if timingLine.MatchString(line) {
+				timing = i
+				break
+			}
+		}
+		//Headers, NOTE, STYLE and REGION blocks don't have timings
+		if timing < 0 {
+			continue
+		}
+		times := timingLine.FindStringSubmatch(lines[timing])
+		start, ok := parseTimestamp(times[1])
+		end, ok2 := parseTimestamp(times[2])
+		if !ok || !ok2 {
+			continue
+		}
+		cue := Cue{Start: start, End: end}
+		body := strings.TrimSpace(strings.Join(lines[timing+1:], " "))
+		if vtt {
+			if voice := voiceTag.FindStringSubmatch(body); voice != nil {
+				cue.Speaker = strings.TrimSpace(voice[1])
+			}
+		}
+		cue.Text = strings.TrimSpace(html.UnescapeString(tags.ReplaceAllString(body, "")))
+		if cue.Text != "" {
+			cues = append(cues, cue)
+		}
+	}
+	return cues
+}
+
+//The podcast namespace's JSON transcript format
+type transcriptJSON struct {
+	Segments []struct {
+		Speaker   string  `json:"speaker"`
+		StartTime float64 `json:"startTime"`
+		EndTime   float64 `json:"endTime"`
+		Body      string  `json:"body"`
+	} `json:"segments"`
+}
+
+//Cues longer than this are split up, even in the middle of a sentence
+const maxCueLength = 300
+
+//Parses a JSON transcript. These are often one segment per word, so segments are joined up
+//into sentences
+func parseJSONTranscript(data []byte) ([]Cue, error) {
+	var parsed transcriptJSON
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, err
+	}
+	cues := make([]Cue, 0)
+	for _, segment := range parsed.Segments {
+		body := strings.TrimSpace(segment.Body)
+		if body == "" {
+			continue
+		}
+		last := len(cues) - 1
+		if last < 0 || cues[last].Speaker != segment.Speaker || sentenceEnded(cues[last].Text) || len(cues[last].Text) > maxCueLength {
+			cues = append(cues, Cue{Start: seconds(segment.StartTime), Speaker: segment.Speaker, Text: body})
+			last++
+		} else {
+			cues[last].Text += " " + body
+		}
+		cues[last].End = seconds(segment.EndTime)
+	}
+	return cues, nil
+}
+
+func sentenceEnded(text string) bool {
+	return strings.HasSuffix(text, ".") || strings.HasSuffix(text, "?") || strings.HasSuffix(text, "!")
+}
+
+var htmlTranscriptParts = regexp.MustCompile(`(?is)<cite[^>]*>(.*?)</cite>|<time[^>]*>(.*?)</time>|<p[^>]*>(.*?)</p>`)
+
+//Parses the podcast namespace's HTML transcript format, where each paragraph can have a
+//<cite> for the speaker and a <time> before it
+func parseHTMLTranscript(text string) []Cue {
+	cues := make([]Cue, 0)
+	var speaker string
+	var start time.Duration
+	for _, part := range htmlTranscriptParts.FindAllStringSubmatch(text, -1) {
+		clean := func(s string) string {
+			return strings.Join(strings.Fields(html.UnescapeString(tags.ReplaceAllString(s, ""))), " ")
+		}
+		switch {
+		case part[1] != "":
+			speaker = strings.TrimSuffix(clean(part[1]), ":")
+		case part[2] != "":
+			if t, ok := parseTimestamp(clean(part[2])); ok {
+				start = t
+			}
+		default:
+			if body := clean(part[3]); body != "" {
+				cues = append(cues, Cue{Start: start, Speaker: speaker, Text: body})
+			}
+		}
+	}
+	return cues
+}
+
+//Plain text transcripts just get a cue for each paragraph
+func parsePlainTranscript(text string) []Cue {
+	cues := make([]Cue, 0)
+	for _, paragraph := range regexp.MustCompile(`\r?\n\s*\r?\n`).Split(text, -1) {
+		if body := strings.Join(strings.Fields(paragraph), " "); body != "" {
+			cues = append(cues, Cue{Text: body})
+		}
+	}
+	return cues
+}
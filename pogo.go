@@ -1,8 +1,67 @@
 package main
 
-import "github.com/programmingthomas/Pogo/server"
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/programmingthomas/Pogo/server"
+	"os"
+)
+
+//Runs pogo backup or pogo restore, which work on the files in the folder Pogo is run from
+//instead of starting the server (so Pogo shouldn't be running at the same time)
+func backupCommand(command string, args []string) error {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	media := false
+	if command == "backup" {
+		flags.BoolVar(&media, "media", media, "include the downloaded episodes")
+		flags.Usage = func() {
+			fmt.Fprintln(flags.Output(), "Usage: pogo backup [-media] [file.tar.gz, or - for standard output]")
+			flags.PrintDefaults()
+		}
+	} else {
+		flags.Usage = func() {
+			fmt.Fprintln(flags.Output(), "Usage: pogo restore file.tar.gz (or - for standard input)")
+		}
+	}
+	flags.Parse(args)
+	if command == "backup" {
+		return server.BackupTo(flags.Arg(0), media)
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("which backup should be restored?")
+	}
+	return server.RestoreFrom(flags.Arg(0))
+}
 
 func main() {
+	if len(os.Args) > 1 && (os.Args[1] == "backup" || os.Args[1] == "restore") {
+		if err := backupCommand(os.Args[1], os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	flag.IntVar(&server.Port, "port", server.Port, "port to serve Pogo on")
+	flag.StringVar(&server.CertFile, "cert", server.CertFile, "certificate file to serve HTTPS with")
+	flag.StringVar(&server.KeyFile, "key", server.KeyFile, "private key file to serve HTTPS with")
+	flag.StringVar(&server.URLPrefix, "prefix", server.URLPrefix, "path to serve Pogo under, e.g. /pogo")
+	flag.BoolVar(&server.TrustProxy, "trust-proxy", server.TrustProxy, "trust X-Forwarded-* headers from a reverse proxy")
+	flag.StringVar(&server.Overrides, "overrides", server.Overrides, "folder of templates and resources to use instead of the built in ones")
+	flag.StringVar(&server.DefaultTheme, "theme", server.DefaultTheme, "theme to use for people who haven't picked one")
+	flag.BoolVar(&server.Offline, "offline", server.Offline, "start in offline mode, only using podcasts and episodes that have already been downloaded")
+	flag.DurationVar(&server.RefreshInterval, "refresh", server.RefreshInterval, "how often to refresh podcasts that Pogo can't work out an interval for")
+	flag.StringVar(&server.QuietHours, "quiet-hours", server.QuietHours, "times of day not to refresh or download anything, e.g. 23:00-07:00")
+	flag.StringVar(&server.DownloadWindows, "download-windows", server.DownloadWindows, "times of day to download episodes in, e.g. 01:00-06:00")
+	flag.IntVar(&server.DownloadCap, "download-cap", server.DownloadCap, "megabytes that can be downloaded in each download window (0 for no cap)")
+	flag.BoolVar(&server.Cache, "cache", server.Cache, "cache templates and let browsers cache resources (turn off when editing templates)")
+	flag.StringVar(&server.SMTPServer, "smtp", server.SMTPServer, "mail server (host:port) to send notification emails through")
+	flag.StringVar(&server.SMTPFrom, "smtp-from", server.SMTPFrom, "address notification emails are sent from")
+	flag.StringVar(&server.SMTPUser, "smtp-user", server.SMTPUser, "user name for the mail server (the password is read from POGO_SMTP_PASSWORD)")
+	flag.Parse()
+	//Passwords on the command line can be seen by everyone on the machine
+	server.SMTPPassword = os.Getenv("POGO_SMTP_PASSWORD")
 	//Starts a pogo server...
 	server.Start()
-}
+}
\ No newline at end of file
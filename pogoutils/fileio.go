@@ -1,11 +1,14 @@
 package pogoutils
 
 import (
+	"context"
+	"errors"
 	"time"
 	"os"
 	"fmt"
 	"net/http"
 	"io"
+	"path/filepath"
 )
 
 //This function allows you to determine if a file exists
@@ -23,23 +26,106 @@ func LastMod(path string) (time.Time) {
 	return fileInfo.ModTime()
 }
 
-//Download a file from the given URL and save it to the given file
+//Download a file from the given URL and save it to the given file. Returns the number of
+//bytes written so that callers can keep track of how much has been downloaded. The file is
+//downloaded to saveFile.part first and only renamed once it is complete
 //Note that the Instagram API encourages you to take into account the IP of Instagram
 //users, so you shouldn't download files with this
-func Download(url, saveFile string) {
+func Download(ctx context.Context, url, saveFile string) (int64, error) {
+	return DownloadThrough(ctx, url, saveFile, nil)
+}
+
+//Filters can return this (or an error wrapping it) to stop a download for now. Like cancelling
+//the context, it keeps the .part file so that the next download carries on where it stopped
+var ErrDownloadPaused = errors.New("download paused")
+
+//Like Download, but the file is read through whatever filter returns (unless filter is nil),
+//which is handy for keeping an eye on or limiting how much is downloaded. The filter is also
+//given how much of the file was already downloaded last time and the size of the whole file,
+//or -1 if the server didn't say. If there's a saveFile.part left over from a download that was
+//stopped, only the rest of the file is requested (if the server can't do that it starts again)
+func DownloadThrough(ctx context.Context, url, saveFile string, filter func(io.Reader, int64, int64) io.Reader) (int64, error) {
 	fmt.Println("Downloading", url, "to", saveFile)
-	out, err := os.Create(saveFile)
+	partFile := saveFile + ".part"
+	out, err := os.OpenFile(partFile, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
-		return
+		return 0, err
+	}
+	written, err := fetchTo(ctx, url, out, filter)
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
 	}
-	defer out.Close()
-	
-	resp, err := http.Get(url)
 	if err == nil {
-		defer resp.Body.Close()
-		io.Copy(out, resp.Body)
+		err = os.Rename(partFile, saveFile)
+	}
+	if err != nil {
+		if ctx.Err() == nil && !errors.Is(err, ErrDownloadPaused) {
+			os.Remove(partFile)
+		}
+		return written, err
 	}
 	fmt.Println("Downloaded", url, "to", saveFile)
+	return written, nil
+}
+
+func fetchTo(ctx context.Context, url string, out *os.File, filter func(io.Reader, int64, int64) io.Reader) (int64, error) {
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	size := resp.ContentLength
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		//Make sure that it's actually the rest of the file we've got
+		var start, end, total int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &total); err != nil || start != offset {
+			return 0, fmt.Errorf("unexpected range %q downloading %s", resp.Header.Get("Content-Range"), url)
+		}
+		size = total
+	case resp.StatusCode == http.StatusOK:
+		//The server is sending the whole file, so throw away what we had
+		if offset > 0 {
+			if err := out.Truncate(0); err != nil {
+				return 0, err
+			}
+			if _, err := out.Seek(0, io.SeekStart); err != nil {
+				return 0, err
+			}
+			offset = 0
+		}
+	default:
+		return 0, fmt.Errorf("unexpected status %s downloading %s", resp.Status, url)
+	}
+	var body io.Reader = resp.Body
+	if filter != nil {
+		body = filter(body, offset, size)
+	}
+	return io.Copy(out, body)
+}
+
+//Works out the total size of all of the files in a folder (and its subfolders)
+func DirSize(folder string) int64 {
+	var size int64
+	filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
 }
 
 //Create a folder at the given URL
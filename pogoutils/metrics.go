@@ -0,0 +1,256 @@
+package pogoutils
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//A single metric that can write itself out in the Prometheus text format
+type Metric interface {
+	WriteMetric(w io.Writer)
+}
+
+var metricsMutex sync.Mutex
+var registeredMetrics []Metric
+
+//Adds a metric to the list written out by WriteMetrics
+func RegisterMetric(metric Metric) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	registeredMetrics = append(registeredMetrics, metric)
+}
+
+//Writes every registered metric in the Prometheus text exposition format
+func WriteMetrics(w io.Writer) {
+	metricsMutex.Lock()
+	metrics := make([]Metric, len(registeredMetrics))
+	copy(metrics, registeredMetrics)
+	metricsMutex.Unlock()
+	for _, metric := range metrics {
+		metric.WriteMetric(w)
+	}
+}
+
+//The values of a metric split up by its labels (e.g. one value per feed)
+type labelledValues struct {
+	name   string
+	help   string
+	labels []string
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+func (lv *labelledValues) add(delta float64, labelValues []string) {
+	key := strings.Join(labelValues, "\xff")
+	lv.mutex.Lock()
+	lv.values[key] += delta
+	lv.mutex.Unlock()
+}
+
+func (lv *labelledValues) set(value float64, labelValues []string) {
+	key := strings.Join(labelValues, "\xff")
+	lv.mutex.Lock()
+	lv.values[key] = value
+	lv.mutex.Unlock()
+}
+
+func (lv *labelledValues) writeTo(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", lv.name, lv.help, lv.name, kind)
+	lv.mutex.Lock()
+	defer lv.mutex.Unlock()
+	//Unlabelled metrics should still report 0 before anything has happened
+	if len(lv.labels) == 0 && len(lv.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", lv.name)
+		return
+	}
+	for _, key := range sortedKeys(lv.values) {
+		fmt.Fprintf(w, "%s%s %s\n", lv.name, labelString(lv.labels, key, ""), formatFloat(lv.values[key]))
+	}
+}
+
+//A counter is a value that only ever goes up (e.g. number of refreshes)
+type Counter struct {
+	labelledValues
+}
+
+//Creates and registers a counter. The label values must be passed to Inc/Add in the
+//same order as the label names given here
+func NewCounter(name, help string, labels ...string) *Counter {
+	counter := &Counter{labelledValues{name: name, help: help, labels: labels, values: make(map[string]float64)}}
+	RegisterMetric(counter)
+	return counter
+}
+
+//Increments the counter by one
+func (counter *Counter) Inc(labelValues ...string) {
+	counter.add(1, labelValues)
+}
+
+//Increments the counter by the given amount, which should not be negative
+func (counter *Counter) Add(delta float64, labelValues ...string) {
+	if delta >= 0 {
+		counter.add(delta, labelValues)
+	}
+}
+
+func (counter *Counter) WriteMetric(w io.Writer) {
+	counter.writeTo(w, "counter")
+}
+
+//A gauge is a value that can go up and down (e.g. the number of queued downloads)
+type Gauge struct {
+	labelledValues
+}
+
+//Creates and registers a gauge
+func NewGauge(name, help string, labels ...string) *Gauge {
+	gauge := &Gauge{labelledValues{name: name, help: help, labels: labels, values: make(map[string]float64)}}
+	RegisterMetric(gauge)
+	return gauge
+}
+
+func (gauge *Gauge) Set(value float64, labelValues ...string) {
+	gauge.set(value, labelValues)
+}
+
+func (gauge *Gauge) Add(delta float64, labelValues ...string) {
+	gauge.add(delta, labelValues)
+}
+
+func (gauge *Gauge) WriteMetric(w io.Writer) {
+	gauge.writeTo(w, "gauge")
+}
+
+//A gauge whose value is worked out each time the metrics are scraped (useful for things
+//like disk usage which would be a pain to keep track of)
+type GaugeFunc struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+//Creates and registers a gauge that calls value whenever it is written out
+func NewGaugeFunc(name, help string, value func() float64) *GaugeFunc {
+	gauge := &GaugeFunc{name: name, help: help, value: value}
+	RegisterMetric(gauge)
+	return gauge
+}
+
+func (gauge *GaugeFunc) WriteMetric(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", gauge.name, gauge.help, gauge.name)
+	fmt.Fprintf(w, "%s %s\n", gauge.name, formatFloat(gauge.value()))
+}
+
+//A histogram counts observations (like request durations) into buckets
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mutex   sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+//Creates and registers a histogram with the given (sorted) bucket upper bounds
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	histogram := &Histogram{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+	RegisterMetric(histogram)
+	return histogram
+}
+
+//Records a single observation
+func (histogram *Histogram) Observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	histogram.mutex.Lock()
+	defer histogram.mutex.Unlock()
+	series, ok := histogram.series[key]
+	if !ok {
+		series = &histogramSeries{counts: make([]uint64, len(histogram.buckets))}
+		histogram.series[key] = series
+	}
+	for i, bound := range histogram.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+func (histogram *Histogram) WriteMetric(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", histogram.name, histogram.help, histogram.name)
+	histogram.mutex.Lock()
+	defer histogram.mutex.Unlock()
+	if len(histogram.labels) == 0 && len(histogram.series) == 0 {
+		histogram.series[""] = &histogramSeries{counts: make([]uint64, len(histogram.buckets))}
+	}
+	keys := make([]string, 0, len(histogram.series))
+	for key := range histogram.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		series := histogram.series[key]
+		for i, bound := range histogram.buckets {
+			le := fmt.Sprintf("le=\"%s\"", formatFloat(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", histogram.name, labelString(histogram.labels, key, le), series.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", histogram.name, labelString(histogram.labels, key, "le=\"+Inf\""), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", histogram.name, labelString(histogram.labels, key, ""), formatFloat(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", histogram.name, labelString(histogram.labels, key, ""), series.count)
+	}
+}
+
+//Builds the {label="value",...} part of a sample line
+func labelString(labels []string, key, extra string) string {
+	pairs := make([]string, 0, len(labels)+1)
+	if len(labels) > 0 {
+		values := strings.Split(key, "\xff")
+		for i, label := range labels {
+			value := ""
+			if i < len(values) {
+				value = values[i]
+			}
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", label, escapeLabel(value)))
+		}
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprint(value)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,96 @@
+package pogoutils
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+//Limits how quickly things are read (in bytes per second). One limiter can be shared by lots
+//of readers, which then share the rate between them, and the rate can be changed while they're
+//reading. A rate of 0 means there isn't a limit
+type RateLimiter struct {
+	mutex sync.Mutex
+	rate  int64
+	//How many bytes can be read straight away. This goes negative when readers have to wait
+	allowance float64
+	last      time.Time
+}
+
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{rate: rate, last: time.Now()}
+}
+
+//Gets the rate in bytes per second
+func (limiter *RateLimiter) Rate() int64 {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	return limiter.rate
+}
+
+//Changes the rate (in bytes per second, 0 for no limit)
+func (limiter *RateLimiter) SetRate(rate int64) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.rate = rate
+	limiter.allowance = 0
+	limiter.last = time.Now()
+}
+
+//Takes n bytes from the allowance and waits until they've been paid back. Up to a second's
+//worth of bytes can be read without waiting. Returns early if ctx is cancelled
+func (limiter *RateLimiter) Wait(ctx context.Context, n int) error {
+	limiter.mutex.Lock()
+	if limiter.rate <= 0 {
+		limiter.mutex.Unlock()
+		return nil
+	}
+	now := time.Now()
+	limiter.allowance += now.Sub(limiter.last).Seconds() * float64(limiter.rate)
+	limiter.last = now
+	if limiter.allowance > float64(limiter.rate) {
+		limiter.allowance = float64(limiter.rate)
+	}
+	limiter.allowance -= float64(n)
+	wait := time.Duration(-limiter.allowance / float64(limiter.rate) * float64(time.Second))
+	limiter.mutex.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//Gets a reader that reads from reader no faster than the limiter allows
+func (limiter *RateLimiter) Reader(ctx context.Context, reader io.Reader) io.Reader {
+	return limitedReader{ctx: ctx, reader: reader, limiter: limiter}
+}
+
+type limitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *RateLimiter
+}
+
+//Small reads keep slow rates smooth rather than reading a big chunk and then waiting ages
+const limitedReadSize = 16 << 10
+
+func (reader limitedReader) Read(p []byte) (int, error) {
+	if len(p) > limitedReadSize {
+		p = p[:limitedReadSize]
+	}
+	n, err := reader.reader.Read(p)
+	if n > 0 {
+		if waitErr := reader.limiter.Wait(reader.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
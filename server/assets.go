@@ -0,0 +1,172 @@
+package server
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+)
+
+//The themes (templates and resources) and locales are built into the binary so that Pogo can
+//be run from anywhere
+//
+//go:embed themes locales
+var embeddedAssets embed.FS
+
+//Looks for files in an override folder first and falls back to the embedded ones. This
+//means that individual templates or resources can be swapped out without rebuilding Pogo
+type overlayFS struct {
+	override fs.FS
+	base     fs.FS
+}
+
+func (overlay overlayFS) Open(name string) (fs.File, error) {
+	if overlay.override != nil {
+		file, err := overlay.override.Open(name)
+		if err == nil {
+			return file, nil
+		}
+	}
+	return overlay.base.Open(name)
+}
+
+//Lists the files in a folder from both file systems, preferring the override versions
+func (overlay overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries := make(map[string]fs.DirEntry)
+	baseEntries, baseErr := fs.ReadDir(overlay.base, name)
+	for _, entry := range baseEntries {
+		entries[entry.Name()] = entry
+	}
+	var overrideErr error = fs.ErrNotExist
+	if overlay.override != nil {
+		var overrideEntries []fs.DirEntry
+		overrideEntries, overrideErr = fs.ReadDir(overlay.override, name)
+		for _, entry := range overrideEntries {
+			entries[entry.Name()] = entry
+		}
+	}
+	if baseErr != nil && overrideErr != nil {
+		return nil, baseErr
+	}
+	merged := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		merged = append(merged, entry)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name() < merged[j].Name() })
+	return merged, nil
+}
+
+//Gets the file system that templates and resources are loaded from
+func assets() fs.FS {
+	overlay := overlayFS{base: embeddedAssets}
+	if Overrides != "" {
+		overlay.override = os.DirFS(Overrides)
+	}
+	return overlay
+}
+
+//Gets the files for a theme. Themes live in themes/<name> and contain a templates folder
+//and a res folder; anything a theme leaves out comes from the default theme
+func themeFS(theme string) fs.FS {
+	base, _ := fs.Sub(assets(), "themes/default")
+	if theme == "default" {
+		return base
+	}
+	override, _ := fs.Sub(assets(), "themes/"+theme)
+	return overlayFS{override: override, base: base}
+}
+
+//Lists the names of all of the themes, including any in the override folder
+func Themes() []string {
+	themes := make([]string, 0)
+	entries, _ := fs.ReadDir(assets(), "themes")
+	for _, entry := range entries {
+		if entry.IsDir() && validThemeName(entry.Name()) {
+			themes = append(themes, entry.Name())
+		}
+	}
+	return themes
+}
+
+//Theme names end up in URLs and paths, so they have to be simple
+func validThemeName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+//Whether or not there is a theme with the given name
+func themeExists(name string) bool {
+	for _, theme := range Themes() {
+		if theme == name {
+			return true
+		}
+	}
+	return false
+}
+
+//Works out which theme to show a request in: the user's choice if they are logged in and it
+//still exists, otherwise the default
+func themeFor(r *http.Request) string {
+	if user, ok := Users.Find(authFor(r).User); ok && user.Settings.Theme != "" && themeExists(user.Settings.Theme) {
+		return user.Settings.Theme
+	}
+	return DefaultTheme
+}
+
+var cachedTemplates = make(map[string]*template.Template)
+var templatesMutex sync.Mutex
+
+//Gets the page templates for the theme and locale a request should be shown in. When caching
+//is turned off they are parsed every time so that changes to templates show up straight away
+func pageTemplates(r *http.Request) *template.Template {
+	return themeTemplates(themeFor(r), localeFor(r))
+}
+
+//Gets the page templates for a theme, translated into a locale
+func themeTemplates(theme string, locale *Locale) *template.Template {
+	templatesMutex.Lock()
+	defer templatesMutex.Unlock()
+	key := theme + "/" + locale.Code
+	cached := cachedTemplates[key]
+	if cached == nil || !Cache {
+		parsed, err := template.New(theme).Funcs(locale.funcs()).ParseFS(themeFS(theme), "templates/*.html")
+		if err != nil {
+			if cached == nil {
+				panic(err)
+			}
+			//Keep using the last templates that worked
+			fmt.Println("Error parsing templates for", theme, err)
+			return cached
+		}
+		cachedTemplates[key] = parsed
+		cached = parsed
+	}
+	return cached
+}
+
+//Checks that the override folder (if any) exists
+func checkOverrides() error {
+	if Overrides == "" {
+		return nil
+	}
+	info, err := os.Stat(Overrides)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New(Overrides + " is not a folder")
+	}
+	return nil
+}
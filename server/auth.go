@@ -0,0 +1,351 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//A logged in browser session
+type session struct {
+	User    string
+	CSRF    string
+	Expires time.Time
+}
+
+//Sessions are only kept in memory, so everyone has to log in again when Pogo restarts
+var sessions = make(map[string]*session)
+var sessionsMutex sync.Mutex
+
+//Who a request was made by. Session is empty if the request used an API token
+type requestAuth struct {
+	User    string
+	Session string
+	CSRF    string
+}
+
+type authContextKey struct{}
+
+//All of the user accounts
+var Users *UserStore
+
+//Creates a new session for the user and sets the cookie for it
+func startSession(w http.ResponseWriter, r *http.Request, user string) {
+	id := randomToken()
+	sessionsMutex.Lock()
+	sessions[id] = &session{User: user, CSRF: randomToken(), Expires: time.Now().Add(SessionLength)}
+	sessionsMutex.Unlock()
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    id,
+		Path:     basePath(r) + "/",
+		Expires:  time.Now().Add(SessionLength),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+//Removes the session that the request was made with (if any) and clears its cookie
+func endSession(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		sessionsMutex.Lock()
+		delete(sessions, cookie.Value)
+		sessionsMutex.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: SessionCookie, Value: "", Path: basePath(r) + "/", MaxAge: -1})
+}
+
+//Logs someone out everywhere, like when their account is removed
+func endSessionsFor(user string) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	for id, s := range sessions {
+		if s.User == user {
+			delete(sessions, id)
+		}
+	}
+}
+
+//Logs out everyone whose account has gone, like after a backup is restored
+func endSessionsForMissingUsers() {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	for id, s := range sessions {
+		if _, ok := Users.Find(s.User); !ok {
+			delete(sessions, id)
+		}
+	}
+}
+
+//Works out who made a request, either from their session cookie or an API token given in
+//the Authorization header (or the token parameter for clients that can't set headers)
+func authenticate(r *http.Request) (requestAuth, bool) {
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		sessionsMutex.Lock()
+		s, ok := sessions[cookie.Value]
+		if ok && time.Now().After(s.Expires) {
+			delete(sessions, cookie.Value)
+			ok = false
+		}
+		sessionsMutex.Unlock()
+		if ok {
+			//The account may have gone since they logged in
+			if _, exists := Users.Find(s.User); !exists {
+				endSessionsFor(s.User)
+				return requestAuth{}, false
+			}
+			return requestAuth{User: s.User, Session: cookie.Value, CSRF: s.CSRF}, true
+		}
+	}
+	token := r.URL.Query().Get("token")
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimPrefix(header, "Bearer ")
+	}
+	if token != "" {
+		if user, ok := Users.UserForToken(token); ok {
+			return requestAuth{User: user}, true
+		}
+	}
+	return requestAuth{}, false
+}
+
+//Gets the details of who made a request that has been through requireLogin
+func authFor(r *http.Request) requestAuth {
+	auth, _ := r.Context().Value(authContextKey{}).(requestAuth)
+	return auth
+}
+
+//Checks that a form submitted from a browser session came from one of our own pages.
+//Requests made with API tokens don't use cookies so they can't be forged like this
+func validCSRF(r *http.Request, auth requestAuth) bool {
+	if auth.Session == "" {
+		return true
+	}
+	token := r.Header.Get("X-CSRF-Token")
+	if token == "" {
+		token = r.FormValue("csrf")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(auth.CSRF)) == 1
+}
+
+//Whether a request looks like it came from a browser (as opposed to an API client), which
+//decides whether it should be redirected to the login page or just told it's unauthorised
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+//Wraps a handler so that it can only be used by someone who is logged in (or has an API
+//token). Mutating requests from browser sessions must include the CSRF token
+func requireLogin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if Users.Empty() {
+			redirect(w, r, "/setup")
+			return
+		}
+		auth, ok := authenticate(r)
+		if !ok {
+			if wantsHTML(r) {
+				redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()))
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="Pogo"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			}
+			return
+		}
+		if r.Method != "GET" && r.Method != "HEAD" && !validCSRF(r, auth) {
+			http.Error(w, "Invalid or missing CSRF token", http.StatusForbidden)
+			return
+		}
+		handler(w, r.WithContext(context.WithValue(r.Context(), authContextKey{}, auth)))
+	}
+}
+
+//Wraps a handler so that only admins can use it
+func requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return requireLogin(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := Users.Find(authFor(r).User)
+		if !ok || !user.Admin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	})
+}
+
+//Only redirect to pages on this server after logging in
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/home"
+	}
+	return next
+}
+
+//Data for the login and setup forms
+type authForm struct {
+	Error string
+	Name  string
+	Next  string
+}
+
+func renderAuthForm(w http.ResponseWriter, r *http.Request, title, templateName string, form authForm) {
+	page := newPage(r, title)
+	content := bytes.NewBufferString("")
+	pageTemplates(r).ExecuteTemplate(content, templateName, form)
+	page.Content = template.HTML(content.String())
+	pageHandler(page, "index.html", w)
+}
+
+//Serves the login page and logs the user in when the form is submitted
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if Users.Empty() {
+		redirect(w, r, "/setup")
+		return
+	}
+	form := authForm{Next: safeNext(r.FormValue("next"))}
+	if r.Method == "POST" {
+		form.Name = r.FormValue("name")
+		if Users.Authenticate(form.Name, r.FormValue("password")) {
+			startSession(w, r, form.Name)
+			redirect(w, r, form.Next)
+			return
+		}
+		fmt.Println("Failed login for", form.Name, "from", clientIP(r))
+		form.Error = "Incorrect user name or password"
+	}
+	renderAuthForm(w, r, "Log in - Pogo", "login.html", form)
+}
+
+//Logs the user out
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	endSession(w, r)
+	redirect(w, r, "/login")
+}
+
+//The first time Pogo is run there are no accounts, so this page lets the admin account be
+//created. Once there is an account it just redirects to the homepage
+func setupHandler(w http.ResponseWriter, r *http.Request) {
+	if !Users.Empty() {
+		redirect(w, r, "/home")
+		return
+	}
+	form := authForm{}
+	if r.Method == "POST" {
+		form.Name = r.FormValue("name")
+		if r.FormValue("password") != r.FormValue("confirm") {
+			form.Error = "The passwords don't match"
+		} else if err := Users.Add(form.Name, r.FormValue("password"), true); err != nil {
+			form.Error = err.Error()
+		} else {
+			//Anything caught before accounts existed belongs to the admin
+			for _, podcast := range PodCatcher.AllPodcasts() {
+				Users.Subscribe(strings.TrimSpace(form.Name), podcast.FeedURL)
+			}
+			startSession(w, r, strings.TrimSpace(form.Name))
+			redirect(w, r, "/home")
+			return
+		}
+	}
+	renderAuthForm(w, r, "Set up - Pogo", "setup.html", form)
+}
+
+//Data for the account page
+type accountPage struct {
+	User     User
+	CSRF     string
+	NewToken string
+	Error    string
+	//The events webhooks can be sent
+	Events []eventChoice
+}
+
+//Serves the account page, where API tokens can be created and revoked (and webhooks, which
+//are changed through /api/webhooks)
+func accountHandler(w http.ResponseWriter, r *http.Request) {
+	auth := authFor(r)
+	data := accountPage{CSRF: auth.CSRF, Events: eventChoices(nil)}
+	if r.Method == "POST" {
+		var err error
+		switch r.FormValue("action") {
+		case "createtoken":
+			data.NewToken, err = Users.NewAPIToken(auth.User, r.FormValue("name"))
+		case "revoketoken":
+			err = Users.RevokeAPIToken(auth.User, r.FormValue("token"))
+		}
+		if err != nil {
+			data.Error = err.Error()
+		}
+	}
+	data.User, _ = Users.Find(auth.User)
+	page := newPage(r, "Account - Pogo")
+	content := bytes.NewBufferString("")
+	pageTemplates(r).ExecuteTemplate(content, "account.html", data)
+	page.Content = template.HTML(content.String())
+	pageHandler(page, "index.html", w)
+}
+
+//Data for the user management page
+type usersPage struct {
+	Users []User
+	Me    string
+	CSRF  string
+	Error string
+}
+
+//Lets admins add and remove accounts for other people sharing the same Pogo
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	auth := authFor(r)
+	data := usersPage{Me: auth.User, CSRF: auth.CSRF}
+	if r.Method == "POST" {
+		var err error
+		switch r.FormValue("action") {
+		case "add":
+			err = Users.Add(r.FormValue("name"), r.FormValue("password"), r.FormValue("admin") == "on")
+		case "remove":
+			if r.FormValue("name") == auth.User {
+				err = errors.New("you can't remove your own account")
+			} else {
+				err = removeUser(r.FormValue("name"))
+			}
+		}
+		if err != nil {
+			data.Error = err.Error()
+		}
+	}
+	data.Users = Users.All()
+	page := newPage(r, "Users - Pogo")
+	content := bytes.NewBufferString("")
+	pageTemplates(r).ExecuteTemplate(content, "users.html", data)
+	page.Content = template.HTML(content.String())
+	pageHandler(page, "index.html", w)
+}
+
+//Removes an account, and any feeds that only that user was subscribed to
+func removeUser(name string) error {
+	user, ok := Users.Find(name)
+	if !ok {
+		return errors.New("no such user")
+	}
+	if err := Users.Remove(name); err != nil {
+		return err
+	}
+	endSessionsFor(name)
+	for _, feedURL := range user.Subscriptions {
+		if Users.Subscribers(feedURL) == 0 {
+			go PodCatcher.RemovePodcastFeed(feedURL)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,450 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//A backup is a .tar.gz of everything Pogo keeps: the podcasts and episodes, the user accounts
+//(with everyone's subscriptions, progress and settings), the push notification key and the
+//artwork, images and transcripts in downloads. The episodes themselves are only included if
+//they're asked for, because they're usually most of it. Files are named the same way in every
+//backup, wherever they're kept on the machine it was made on
+
+//The version of the backup format. Backups from newer versions of Pogo aren't restored, as they
+//may have things in them that this one doesn't understand
+const backupVersion = 1
+
+//The first file in every backup, which says what it is
+const backupManifest = "pogo-backup.json"
+
+//What the state files are called in backups
+const (
+	backupConfig   = "pogoconfig.json"
+	backupUsers    = "pogousers.json"
+	backupPushKeys = "pogopush.json"
+)
+
+//The folders in downloads that are always backed up
+var backupFolders = []string{"artwork", "images", "transcripts"}
+
+//What's in backupManifest
+type backupInfo struct {
+	Version int
+	Created time.Time
+	Media   bool
+}
+
+//Gets the name a backup made now is saved as
+func backupName() string {
+	return "pogo-backup-" + time.Now().Format("2006-01-02") + ".tar.gz"
+}
+
+//Gets Pogo's state as it's saved on disk, for backing up when Pogo isn't running
+func savedState() (map[string][]byte, error) {
+	state := make(map[string][]byte)
+	for name, location := range map[string]string{backupConfig: ConfigLocation, backupUsers: UsersLocation, backupPushKeys: PushKeysLocation} {
+		contents, err := ioutil.ReadFile(location)
+		if os.IsNotExist(err) && name == backupPushKeys {
+			//It's made the first time Pogo starts
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		state[name] = contents
+	}
+	return state, nil
+}
+
+//Gets the running server's state. The catcher is saved first so that nothing is missed, and
+//the accounts come straight from the store so that they can't be caught halfway through
+//being saved
+func liveState() (map[string][]byte, error) {
+	PodCatcher.SaveData()
+	config, err := ioutil.ReadFile(PodCatcher.ConfigLocation)
+	if err != nil {
+		return nil, err
+	}
+	users, err := Users.saved()
+	if err != nil {
+		return nil, err
+	}
+	state := map[string][]byte{backupConfig: config, backupUsers: users}
+	if pushKeys, err := ioutil.ReadFile(PushKeysLocation); err == nil {
+		state[backupPushKeys] = pushKeys
+	}
+	return state, nil
+}
+
+//Whether or not a file in downloads is backed up
+func backedUp(name string, media bool) bool {
+	if strings.HasSuffix(name, ".part") || strings.HasSuffix(name, ".tmp") {
+		return false
+	}
+	folder := strings.SplitN(name, "/", 2)[0]
+	if folder == name {
+		return media
+	}
+	return contains(backupFolders, folder)
+}
+
+//Adds a file that's in memory to a backup
+func writeBackupFile(archive *tar.Writer, name string, contents []byte) error {
+	err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(contents)), ModTime: time.Now()})
+	if err == nil {
+		_, err = archive.Write(contents)
+	}
+	return err
+}
+
+//Writes a backup of some state (see savedState and liveState) and the files in downloads
+func writeBackup(w io.Writer, state map[string][]byte, media bool) error {
+	compressed := gzip.NewWriter(w)
+	archive := tar.NewWriter(compressed)
+	manifest, err := json.MarshalIndent(backupInfo{Version: backupVersion, Created: time.Now(), Media: media}, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err = writeBackupFile(archive, backupManifest, manifest); err != nil {
+		return err
+	}
+	for _, name := range []string{backupConfig, backupUsers, backupPushKeys} {
+		if contents, ok := state[name]; ok {
+			if err = writeBackupFile(archive, name, contents); err != nil {
+				return err
+			}
+		}
+	}
+	err = filepath.WalkDir("downloads", func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		name := filepath.ToSlash(file)
+		if !backedUp(strings.TrimPrefix(name, "downloads/"), media) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		in, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		if err = archive.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+			return err
+		}
+		_, err = io.Copy(archive, in)
+		return err
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = archive.Close(); err != nil {
+		return err
+	}
+	return compressed.Close()
+}
+
+//A backup that has been read and checked. Its state is kept in memory and the files for
+//downloads wait in a staging folder until they're installed
+type restoredBackup struct {
+	backupInfo
+	State   map[string][]byte
+	Files   []string
+	staging string
+}
+
+//Works out where a file in a backup goes, or returns an error if it shouldn't be in one
+func restoredName(name string) (string, error) {
+	if path.Clean(name) != name || path.IsAbs(name) || strings.Contains(name, "\\") {
+		return "", fmt.Errorf("backup contains an unsafe file name %q", name)
+	}
+	if name == backupConfig || name == backupUsers || name == backupPushKeys {
+		return name, nil
+	}
+	if strings.HasPrefix(name, "downloads/") && backedUp(strings.TrimPrefix(name, "downloads/"), true) {
+		return name, nil
+	}
+	return "", fmt.Errorf("backup contains an unexpected file %q", name)
+}
+
+//Reads a backup and checks that it can be restored, without changing anything yet. The backup
+//has to be one Pogo made in a version it understands, and its podcasts and accounts have to
+//be readable. Close it once it's been restored (or not)
+func readBackup(r io.Reader) (*restoredBackup, error) {
+	compressed, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.New("not a Pogo backup")
+	}
+	archive := tar.NewReader(compressed)
+	header, err := archive.Next()
+	if err != nil || header.Name != backupManifest {
+		return nil, errors.New("not a Pogo backup")
+	}
+	backup := &restoredBackup{State: make(map[string][]byte)}
+	contents, err := ioutil.ReadAll(io.LimitReader(archive, 1<<20))
+	if err == nil {
+		err = json.Unmarshal(contents, &backup.backupInfo)
+	}
+	if err != nil {
+		return nil, errors.New("not a Pogo backup")
+	}
+	if backup.Version > backupVersion {
+		return nil, fmt.Errorf("this backup was made by a newer version of Pogo (format %d, but this one reads up to %d)", backup.Version, backupVersion)
+	} else if backup.Version < 1 {
+		return nil, errors.New("not a Pogo backup")
+	}
+	//Staged next to downloads so that files can be moved into place rather than copied
+	if backup.staging, err = os.MkdirTemp(".", "pogo-restore-"); err != nil {
+		return nil, err
+	}
+	for {
+		header, err = archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil && header.Typeflag != tar.TypeReg {
+			err = fmt.Errorf("backup contains %q, which isn't a file", header.Name)
+		}
+		var name string
+		if err == nil {
+			name, err = restoredName(header.Name)
+		}
+		if err == nil && !strings.HasPrefix(name, "downloads/") {
+			backup.State[name], err = ioutil.ReadAll(archive)
+		} else if err == nil {
+			err = backup.stage(name, archive)
+		}
+		if err != nil {
+			backup.Close()
+			return nil, err
+		}
+	}
+	err = backup.check()
+	if err != nil {
+		backup.Close()
+		return nil, err
+	}
+	return backup, nil
+}
+
+//Copies a file for downloads into the staging folder
+func (backup *restoredBackup) stage(name string, contents io.Reader) error {
+	staged := filepath.Join(backup.staging, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(staged), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(staged)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, contents)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	backup.Files = append(backup.Files, name)
+	return err
+}
+
+//Checks that the podcasts, accounts and push key in a backup can be read, and that someone
+//will be able to look after Pogo once it's restored (otherwise whoever got to /setup first
+//would become an admin)
+func (backup *restoredBackup) check() error {
+	var podcasts struct {
+		Podcasts []json.RawMessage
+	}
+	if contents, ok := backup.State[backupConfig]; !ok || json.Unmarshal(contents, &podcasts) != nil {
+		return errors.New("backup doesn't have any podcasts that can be read")
+	}
+	var users UserStore
+	if contents, ok := backup.State[backupUsers]; !ok || json.Unmarshal(contents, &users) != nil {
+		return errors.New("backup doesn't have any accounts that can be read")
+	}
+	admin := false
+	for _, user := range users.Users {
+		admin = admin || user.Admin
+	}
+	if !admin {
+		return errors.New("backup doesn't have an admin account")
+	}
+	var pushKeys savedPushKeys
+	if contents, ok := backup.State[backupPushKeys]; ok && json.Unmarshal(contents, &pushKeys) != nil {
+		return errors.New("backup's push notification key can't be read")
+	}
+	return nil
+}
+
+//Moves the backup's files into downloads, replacing any that are already there. Files that
+//aren't in the backup are left alone
+func (backup *restoredBackup) installDownloads() error {
+	for _, name := range backup.Files {
+		target := filepath.FromSlash(name)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(backup.staging, target), target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Saves a file from a backup to where it's kept. It's written to a temporary file first so that
+//it is never left half written
+func installState(location string, contents []byte) error {
+	if err := ioutil.WriteFile(location+".tmp", contents, 0600); err != nil {
+		return err
+	}
+	return os.Rename(location+".tmp", location)
+}
+
+//Gets rid of whatever is left in the staging folder
+func (backup *restoredBackup) Close() {
+	os.RemoveAll(backup.staging)
+}
+
+//Writes a backup of Pogo's saved state to a file (or standard output for -). Pogo shouldn't be
+//running while this happens, as it may change things halfway through
+func BackupTo(filename string, media bool) error {
+	state, err := savedState()
+	if err != nil {
+		return err
+	}
+	if filename == "-" {
+		return writeBackup(os.Stdout, state, media)
+	}
+	if filename == "" {
+		filename = backupName()
+	}
+	out, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = writeBackup(out, state, media)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filename)
+		return err
+	}
+	fmt.Println("Backed up to", filename)
+	return nil
+}
+
+//Restores a backup from a file (or standard input for -), replacing Pogo's saved state. Pogo
+//shouldn't be running while this happens, or it will save its own state over the backup's
+func RestoreFrom(filename string) error {
+	in := os.Stdin
+	if filename != "-" {
+		var err error
+		if in, err = os.Open(filename); err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+	backup, err := readBackup(in)
+	if err != nil {
+		return err
+	}
+	defer backup.Close()
+	for name, location := range map[string]string{backupConfig: ConfigLocation, backupUsers: UsersLocation, backupPushKeys: PushKeysLocation} {
+		if contents, ok := backup.State[name]; ok {
+			if err = installState(location, contents); err != nil {
+				return err
+			}
+		}
+	}
+	if err = backup.installDownloads(); err != nil {
+		return err
+	}
+	fmt.Println("Restored backup from", backup.Created.Format(time.RFC1123), "with", len(backup.Files), "files in downloads")
+	return nil
+}
+
+//What a restore did, as returned by /api/backup
+type restoreResult struct {
+	Created time.Time
+	Media   bool
+	Files   int
+}
+
+//Downloads a backup of the running server (GET, with media=true to include the episodes), or
+//restores one that is POSTed (as the backup file in a form, or as the whole body), replacing
+//every podcast and account with the backup's
+func backupHandler(w http.ResponseWriter, r *http.Request) {
+	//Backups with episodes in them can take a while
+	controller := http.NewResponseController(w)
+	controller.SetReadDeadline(time.Time{})
+	controller.SetWriteDeadline(time.Time{})
+	if r.Method != "POST" {
+		state, err := liveState()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+backupName()+"\"")
+		if err = writeBackup(w, state, r.FormValue("media") == "true"); err != nil {
+			//It's too late to say so in the response
+			fmt.Println("Error writing backup", err)
+		}
+		return
+	}
+	var in io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("backup")
+		if err != nil {
+			http.Error(w, "No backup", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		in = file
+	}
+	backup, err := readBackup(in)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer backup.Close()
+	//The files only go into downloads once the state they belong to has been restored, so that
+	//a backup that can't be restored doesn't leave them behind
+	err = PodCatcher.Restore(backup.State[backupConfig])
+	if err == nil {
+		err = Users.Restore(backup.State[backupUsers])
+		endSessionsForMissingUsers()
+	}
+	if contents, ok := backup.State[backupPushKeys]; ok && err == nil {
+		if err = installState(PushKeysLocation, contents); err == nil {
+			var key *ecdsa.PrivateKey
+			if key, err = loadPushKeys(PushKeysLocation); err == nil {
+				setPushKey(key)
+			}
+		}
+	}
+	if err == nil {
+		err = backup.installDownloads()
+	}
+	if err != nil {
+		fmt.Println("Error restoring backup", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Println("Restored backup from", backup.Created.Format(time.RFC1123))
+	respond(w, r, restoreResult{Created: backup.Created, Media: backup.Media, Files: len(backup.Files)})
+}
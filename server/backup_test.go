@@ -0,0 +1,61 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReadBackup(t *testing.T) {
+	config := []byte(`{"Podcasts": []}`)
+	tests := []struct {
+		name  string
+		users string
+		ok    bool
+	}{
+		{"admin", `{"Users": [{"Name": "a", "Admin": true}, {"Name": "b"}]}`, true},
+		{"no admin", `{"Users": [{"Name": "b"}]}`, false},
+		{"no users", `{"Users": []}`, false},
+		{"empty", `{}`, false},
+		{"unreadable", `{"Users": [`, false},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		state := map[string][]byte{backupConfig: config, backupUsers: []byte(test.users)}
+		if err := writeBackup(&b, state, false); err != nil {
+			t.Fatal(err)
+		}
+		backup, err := readBackup(&b)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: readBackup = %v, want ok %v", test.name, err, test.ok)
+		}
+		if err == nil {
+			if !bytes.Equal(backup.State[backupConfig], config) {
+				t.Errorf("%s: the podcasts came back as %s", test.name, backup.State[backupConfig])
+			}
+			backup.Close()
+		}
+	}
+}
+
+func TestRestoredName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{backupConfig, true},
+		{backupUsers, true},
+		{"downloads/images/abc/original", true},
+		{"downloads/episode.mp3", true},
+		{"downloads/episode.mp3.part", false},
+		{"downloads/../pogousers.json", false},
+		{"/etc/passwd", false},
+		{"../pogoconfig.json", false},
+		{"downloads\\..\\x", false},
+		{"somewhere/else", false},
+	}
+	for _, test := range tests {
+		if _, err := restoredName(test.name); (err == nil) != test.ok {
+			t.Errorf("restoredName(%q) = %v, want ok %v", test.name, err, test.ok)
+		}
+	}
+}
@@ -1,7 +1,75 @@
 package server
 
-var Path = "http://localhost"
+import "time"
 
 var Port = 8888
 
-var Cache = true
\ No newline at end of file
+var Cache = true
+
+//A folder laid out like Pogo's built in files (themes/<name>/templates and
+//themes/<name>/res) whose files are used instead of the built in ones. New themes can be
+//added here too, which is handy for theming or working on the templates
+var Overrides = ""
+
+//The theme used for people who haven't picked one (and the login page)
+var DefaultTheme = "default"
+
+//Certificate and key files to serve HTTPS with. Pogo uses plain HTTP if these are empty
+var CertFile = ""
+var KeyFile = ""
+
+//A path that all of Pogo's pages are served under (e.g. /pogo), which is useful when Pogo
+//shares a host name with other things behind a reverse proxy
+var URLPrefix = ""
+
+//Whether or not to believe the X-Forwarded-* headers set by a reverse proxy. Only turn this
+//on if Pogo can't be reached except through the proxy
+var TrustProxy = false
+
+//Start without using the network at all: nothing is refreshed or downloaded and only
+//episodes that have already been downloaded can be played. Admins can change this while
+//Pogo is running
+var Offline = false
+
+//How often podcasts are refreshed when they don't have their own refresh interval and
+//haven't published enough episodes for Pogo to work out how often to check them
+var RefreshInterval = 30 * time.Minute
+
+//Times of day (like 23:00-07:00, separated by commas) when Pogo doesn't refresh or download
+//anything
+var QuietHours = ""
+
+//Times of day (like 01:00-06:00, separated by commas) when episodes can be downloaded. Episodes
+//can be downloaded at any time if this is empty
+var DownloadWindows = ""
+
+//How many megabytes can be downloaded in each download window (or each day if there aren't
+//any windows). 0 means there isn't a cap
+var DownloadCap = 0
+
+//How long to wait for requests and downloads to finish when Pogo is shutting down
+var ShutdownTimeout = 30 * time.Second
+
+//Where the podcasts and episodes are saved
+var ConfigLocation = "pogoconfig.json"
+
+//Where the user accounts are saved
+var UsersLocation = "pogousers.json"
+
+//The name of the cookie used for login sessions
+var SessionCookie = "pogo_session"
+
+//How long someone stays logged in for
+var SessionLength = 30 * 24 * time.Hour
+
+//The mail server (host:port) used to email people about their podcasts, and who the emails
+//come from. Nobody is emailed if this is empty
+var SMTPServer = ""
+var SMTPFrom = ""
+
+//What to log in to the mail server with, if it needs it
+var SMTPUser = ""
+var SMTPPassword = ""
+
+//Where the keys used to send browsers push notifications are saved
+var PushKeysLocation = "pogopush.json"
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/programmingthomas/Pogo/catcher"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+//How many episodes are on each page of a podcast, unless perpage says otherwise
+const (
+	defaultPerPage = 50
+	maxPerPage     = 500
+)
+
+//The ways episodes can be sorted (apart from by whether they've been played, which depends on
+//who is asking)
+var episodeSorts = map[string]catcher.EpisodesBy{
+	"date":     catcher.EpisodesByDate,
+	"title":    catcher.EpisodesByTitle,
+	"duration": catcher.EpisodesByLength,
+}
+
+//Which of a podcast's episodes to show and in what order. These come from the query
+//parameters sort (date, title, duration or played), order (asc or desc), downloaded and
+//played (yes, no or any), type (audio, video or any), season, page and perpage
+type episodeQuery struct {
+	Sort       string
+	Order      string
+	Downloaded string
+	Played     string
+	Type       string
+	//0 for every season
+	Season  int
+	Page    int
+	PerPage int
+}
+
+//Reads an episode query from a request. Anything that isn't given gets a default: newest
+//first, 50 to a page and (for people who hide played episodes) only unplayed episodes
+func parseEpisodeQuery(r *http.Request, user User) (episodeQuery, error) {
+	query := episodeQuery{
+		Sort:       r.FormValue("sort"),
+		Order:      r.FormValue("order"),
+		Downloaded: r.FormValue("downloaded"),
+		Played:     r.FormValue("played"),
+		Type:       r.FormValue("type"),
+		Page:       1,
+		PerPage:    defaultPerPage,
+	}
+	if query.Sort == "" {
+		query.Sort = "date"
+	}
+	if query.Order == "" {
+		//Dates are more useful newest first, but titles are A to Z
+		query.Order = "asc"
+		if query.Sort == "date" {
+			query.Order = "desc"
+		}
+	}
+	if query.Downloaded == "" {
+		query.Downloaded = "any"
+	}
+	if query.Played == "" {
+		query.Played = "any"
+		if user.Settings.HidePlayed {
+			query.Played = "no"
+		}
+	}
+	if query.Type == "" {
+		query.Type = "any"
+	}
+	if _, ok := episodeSorts[query.Sort]; !ok && query.Sort != "played" {
+		return query, errors.New("sort should be date, title, duration or played")
+	}
+	if query.Order != "asc" && query.Order != "desc" {
+		return query, errors.New("order should be asc or desc")
+	}
+	for name, value := range map[string]string{"downloaded": query.Downloaded, "played": query.Played} {
+		if value != "yes" && value != "no" && value != "any" {
+			return query, fmt.Errorf("%s should be yes, no or any", name)
+		}
+	}
+	if query.Type != "audio" && query.Type != "video" && query.Type != "any" {
+		return query, errors.New("type should be audio, video or any")
+	}
+	for name, number := range map[string]*int{"season": &query.Season, "page": &query.Page, "perpage": &query.PerPage} {
+		if value := r.FormValue(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				return query, fmt.Errorf("%s should be a number", name)
+			}
+			*number = parsed
+		}
+	}
+	if query.Page < 1 {
+		return query, errors.New("page should be at least 1")
+	}
+	if query.PerPage < 1 || query.PerPage > maxPerPage {
+		return query, fmt.Errorf("perpage should be between 1 and %d", maxPerPage)
+	}
+	return query, nil
+}
+
+//Whether or not an episode should be shown
+func (query episodeQuery) matches(user User, episode catcher.PodEpisode) bool {
+	if !matchesFilter(query.Downloaded, episode.Downloaded()) || !matchesFilter(query.Played, user.EpisodeState(episode.URL).Played) {
+		return false
+	}
+	if (query.Type == "audio" && !episode.IsAudio()) || (query.Type == "video" && !episode.IsVideo()) {
+		return false
+	}
+	return query.Season == 0 || episode.Season == query.Season
+}
+
+//Checks something against a yes, no or any filter
+func matchesFilter(filter string, value bool) bool {
+	return filter == "any" || (filter == "yes") == value
+}
+
+//Gets how the query sorts episodes
+func (query episodeQuery) sorter(user User) catcher.EpisodesBy {
+	by, ok := episodeSorts[query.Sort]
+	if !ok {
+		//Unplayed first
+		by = func(p1, p2 *catcher.PodEpisode) bool {
+			return !user.EpisodeState(p1.URL).Played && user.EpisodeState(p2.URL).Played
+		}
+	}
+	if query.Order == "desc" {
+		by = by.Reverse()
+	}
+	return by
+}
+
+//One page of a podcast's episodes
+type episodePage struct {
+	Query    episodeQuery
+	Episodes []episodeView
+	//How many episodes matched, and how many pages they fill
+	Total int
+	Pages int
+	//The podcast's seasons, for filtering by
+	Seasons []int
+}
+
+//Finds the podcast's episodes that match the query and gets the page that was asked for
+func (query episodeQuery) apply(user User, podcast catcher.PodFeed, csrf string) episodePage {
+	page := episodePage{Query: query, Episodes: make([]episodeView, 0), Seasons: make([]int, 0)}
+	episodes := make([]catcher.PodEpisode, 0, len(podcast.PodcastEpisodes))
+	seasons := make(map[int]bool)
+	for _, episode := range podcast.PodcastEpisodes {
+		if episode.Season != 0 && !seasons[episode.Season] {
+			seasons[episode.Season] = true
+			page.Seasons = append(page.Seasons, episode.Season)
+		}
+		if query.matches(user, episode) {
+			episodes = append(episodes, episode)
+		}
+	}
+	sort.Ints(page.Seasons)
+	//Newest first when the sort can't tell episodes apart
+	catcher.EpisodesBy(catcher.EpisodesByDate).Reverse().Sort(episodes)
+	query.sorter(user).Sort(episodes)
+	page.Total = len(episodes)
+	page.Pages = (len(episodes) + query.PerPage - 1) / query.PerPage
+	//Pages after the last one are empty (and working out where they'd start could overflow)
+	if query.Page > page.Pages {
+		return page
+	}
+	start := (query.Page - 1) * query.PerPage
+	for i := start; i < len(episodes) && i < start+query.PerPage; i++ {
+		page.Episodes = append(page.Episodes, viewEpisode(user, episodes[i], csrf))
+	}
+	return page
+}
+
+//Gets a link (relative to the podcast page) to another page of the same episodes
+func (page episodePage) link(number int) string {
+	values := url.Values{}
+	values.Set("sort", page.Query.Sort)
+	values.Set("order", page.Query.Order)
+	values.Set("downloaded", page.Query.Downloaded)
+	values.Set("played", page.Query.Played)
+	values.Set("type", page.Query.Type)
+	if page.Query.Season != 0 {
+		values.Set("season", strconv.Itoa(page.Query.Season))
+	}
+	if page.Query.PerPage != defaultPerPage {
+		values.Set("perpage", strconv.Itoa(page.Query.PerPage))
+	}
+	values.Set("page", strconv.Itoa(number))
+	return "?" + values.Encode()
+}
+
+//Gets a link to the previous page, or "" if this is the first one
+func (page episodePage) PreviousPage() string {
+	if page.Query.Page <= 1 || page.Pages < 1 {
+		return ""
+	}
+	return page.link(min(page.Query.Page-1, page.Pages))
+}
+
+//Gets a link to the next page, or "" if this is the last one
+func (page episodePage) NextPage() string {
+	if page.Query.Page >= page.Pages {
+		return ""
+	}
+	return page.link(page.Query.Page + 1)
+}
+
+//Lists a podcast's episodes, sorted, filtered and split into pages like they are on the
+//podcast's page
+func episodesHandler(w http.ResponseWriter, r *http.Request) {
+	podcast, ok := PodCatcher.Podcast(r.FormValue("feed"))
+	if !ok {
+		http.Error(w, catcher.ErrNoPodcast.Error(), http.StatusNotFound)
+		return
+	}
+	user, _ := Users.Find(authFor(r).User)
+	query, err := parseEpisodeQuery(r, user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, query.apply(user, podcast, ""))
+}
@@ -0,0 +1,315 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//The locale used when nobody has asked for anything else. Its strings are the keys in every
+//other locale's catalogue
+const defaultLocale = "en"
+
+//A language (and the way dates are written in it). Locales are loaded from locales/<code>.json
+//and anything a locale leaves out comes from its language (so en-GB falls back to en) and then
+//from English
+type Locale struct {
+	Code string
+	//What the language is called in the language itself
+	Name string
+	//Go time layouts. Month and day names are translated after formatting
+	ShortDate string
+	LongDate  string
+	//How plurals work: "one" for languages where only 1 is singular (like English) and
+	//"zero-one" for languages where 0 is singular too (like French)
+	Plural   string
+	Messages map[string]message
+	parent   *Locale
+}
+
+//A translated string, which has a form for each plural category if it contains a count
+type message struct {
+	Text  string
+	One   string
+	Other string
+}
+
+func (m *message) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &m.Text); err == nil {
+		return nil
+	}
+	forms := struct {
+		One   string `json:"one"`
+		Other string `json:"other"`
+	}{}
+	err := json.Unmarshal(b, &forms)
+	m.One, m.Other = forms.One, forms.Other
+	return err
+}
+
+var locales map[string]*Locale
+var localesMutex sync.Mutex
+
+//Loads all of the locales, including any in the override folder
+func loadLocales() map[string]*Locale {
+	localesMutex.Lock()
+	defer localesMutex.Unlock()
+	if locales != nil && Cache {
+		return locales
+	}
+	loaded := make(map[string]*Locale)
+	entries, _ := fs.ReadDir(assets(), "locales")
+	for _, entry := range entries {
+		code := strings.TrimSuffix(entry.Name(), ".json")
+		if entry.IsDir() || code == entry.Name() {
+			continue
+		}
+		b, err := fs.ReadFile(assets(), "locales/"+entry.Name())
+		locale := &Locale{}
+		if err == nil {
+			err = json.Unmarshal(b, locale)
+		}
+		if err != nil {
+			fmt.Println("Error loading locale", code, err)
+			continue
+		}
+		locale.Code = code
+		loaded[strings.ToLower(code)] = locale
+	}
+	if loaded[defaultLocale] == nil {
+		loaded[defaultLocale] = &Locale{Code: defaultLocale, Name: "English", ShortDate: "1/2/2006", LongDate: "January 2, 2006"}
+	}
+	for code, locale := range loaded {
+		if language, _, found := strings.Cut(code, "-"); found && loaded[language] != nil {
+			locale.parent = loaded[language]
+		} else if code != defaultLocale {
+			locale.parent = loaded[defaultLocale]
+		}
+	}
+	locales = loaded
+	return locales
+}
+
+//Finds a locale by its code (ignoring case), or nil if there isn't one
+func findLocale(code string) *Locale {
+	return loadLocales()[strings.ToLower(code)]
+}
+
+//Lists the locales that people can pick from, sorted by code
+func Locales() []*Locale {
+	list := make([]*Locale, 0)
+	for _, locale := range loadLocales() {
+		list = append(list, locale)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Code < list[j].Code })
+	return list
+}
+
+//Works out which locale to show a request in: the user's choice if they've made one,
+//otherwise the best match for what their browser asks for
+func localeFor(r *http.Request) *Locale {
+	if user, ok := Users.Find(authFor(r).User); ok && user.Settings.Locale != "" {
+		if locale := findLocale(user.Settings.Locale); locale != nil {
+			return locale
+		}
+	}
+	return acceptedLocale(r.Header.Get("Accept-Language"))
+}
+
+//Picks the best locale for an Accept-Language header (e.g. "fr-CA,fr;q=0.9,en;q=0.8")
+func acceptedLocale(header string) *Locale {
+	type accepted struct {
+		code    string
+		quality float64
+	}
+	languages := make([]accepted, 0)
+	for _, part := range strings.Split(header, ",") {
+		code, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = parsed
+			}
+		}
+		if code != "" && code != "*" && quality > 0 {
+			languages = append(languages, accepted{code: code, quality: quality})
+		}
+	}
+	sort.SliceStable(languages, func(i, j int) bool { return languages[i].quality > languages[j].quality })
+	for _, language := range languages {
+		if locale := findLocale(language.code); locale != nil {
+			return locale
+		}
+		//fr-CA can make do with fr
+		if base, _, found := strings.Cut(language.code, "-"); found {
+			if locale := findLocale(base); locale != nil {
+				return locale
+			}
+		}
+	}
+	return findLocale(defaultLocale)
+}
+
+//Looks up a message in the locale, then its parents
+func (locale *Locale) message(key string) (message, bool) {
+	for l := locale; l != nil; l = l.parent {
+		if m, ok := l.Messages[key]; ok {
+			return m, true
+		}
+	}
+	return message{}, false
+}
+
+//Translates a string, filling in any arguments with fmt.Sprintf. Strings that haven't been
+//translated are used as they are
+func (locale *Locale) T(key string, args ...interface{}) string {
+	text := key
+	if m, ok := locale.message(key); ok && m.Text != "" {
+		text = m.Text
+	} else if ok && m.Other != "" {
+		text = m.Other
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+//Translates a string containing a count (as %d), picking the right plural form
+func (locale *Locale) N(count int, key string) string {
+	text := key
+	if m, ok := locale.message(key); ok {
+		text = m.Other
+		if m.Text != "" {
+			text = m.Text
+		}
+		if m.One != "" && locale.singular(count) {
+			text = m.One
+		}
+	}
+	return fmt.Sprintf(text, count)
+}
+
+//Whether or not a count is singular in this locale
+func (locale *Locale) singular(count int) bool {
+	rule := locale.Plural
+	for l := locale; rule == "" && l != nil; l = l.parent {
+		rule = l.Plural
+	}
+	if rule == "zero-one" {
+		return count == 0 || count == 1
+	}
+	return count == 1
+}
+
+//Gets a setting from the locale or its parents
+func (locale *Locale) layout(get func(*Locale) string) string {
+	for l := locale; l != nil; l = l.parent {
+		if value := get(l); value != "" {
+			return value
+		}
+	}
+	return get(findLocale(defaultLocale))
+}
+
+//Formats a time with a layout, translating month and day names
+func (locale *Locale) format(t time.Time, layout string) string {
+	formatted := t.Format(layout)
+	if strings.Contains(layout, "January") {
+		formatted = strings.Replace(formatted, t.Month().String(), locale.T(t.Month().String()), 1)
+	} else if strings.Contains(layout, "Jan") {
+		formatted = strings.Replace(formatted, t.Month().String()[:3], locale.T(t.Month().String()[:3]), 1)
+	}
+	if strings.Contains(layout, "Monday") {
+		formatted = strings.Replace(formatted, t.Weekday().String(), locale.T(t.Weekday().String()), 1)
+	}
+	return formatted
+}
+
+//Formats a date like 1/2/2006 (or however the locale writes dates)
+func (locale *Locale) ShortDateText(t time.Time) string {
+	return locale.format(t, locale.layout(func(l *Locale) string { return l.ShortDate }))
+}
+
+//Formats a date like January 2, 2006 (or however the locale writes dates)
+func (locale *Locale) LongDateText(t time.Time) string {
+	return locale.format(t, locale.layout(func(l *Locale) string { return l.LongDate }))
+}
+
+//Describes when something happened relative to now: today, yesterday, a few days ago and
+//then the date itself. The zero time means we don't know
+func (locale *Locale) RelativeDateText(t time.Time) string {
+	if t.IsZero() {
+		return locale.T("Unknown date")
+	}
+	now := time.Now()
+	t = t.In(now.Location())
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, now.Location())
+	//Rounded because days aren't always 24 hours long
+	days := int((today.Sub(day).Hours() + 12) / 24)
+	switch {
+	case days <= 0:
+		//Episodes from slightly in the future are usually just time zone mistakes
+		return locale.T("Today")
+	case days == 1:
+		return locale.T("Yesterday")
+	case days < 7:
+		return locale.N(days, "%d days ago")
+	case days < 28 && t.Year() == now.Year():
+		return locale.N(days/7, "%d weeks ago")
+	}
+	return locale.LongDateText(t)
+}
+
+//Formats a length of time like 1 hr 5 min
+func (locale *Locale) DurationText(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	hours := int(d.Hours())
+	minutes := int(d.Minutes()) % 60
+	if hours == 0 && minutes == 0 {
+		return locale.T("%d sec", int(d.Seconds()))
+	}
+	if hours == 0 {
+		return locale.T("%d min", minutes)
+	}
+	if minutes == 0 {
+		return locale.T("%d hr", hours)
+	}
+	return locale.T("%d hr", hours) + " " + locale.T("%d min", minutes)
+}
+
+//Describes how often something happens, like 3 hours
+func (locale *Locale) IntervalText(interval time.Duration) string {
+	switch {
+	case interval <= 0:
+		return locale.T("Automatically")
+	case interval%(24*time.Hour) == 0:
+		return locale.N(int(interval/(24*time.Hour)), "%d days")
+	case interval%time.Hour == 0:
+		return locale.N(int(interval/time.Hour), "%d hours")
+	}
+	return locale.N(int(interval/time.Minute), "%d minutes")
+}
+
+//The functions that templates can use to translate and format things
+func (locale *Locale) funcs() template.FuncMap {
+	return template.FuncMap{
+		"T":         locale.T,
+		"N":         locale.N,
+		"date":      locale.RelativeDateText,
+		"shortdate": locale.ShortDateText,
+		"longdate":  locale.LongDateText,
+		"duration":  locale.DurationText,
+		"interval":  locale.IntervalText,
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"errors"
+	"github.com/programmingthomas/Pogo/catcher"
+	"github.com/programmingthomas/Pogo/media"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//How long browsers can keep images for. Images are cached under a key made from their URL,
+//so when a feed changes its artwork the page links to a different image anyway
+const imageMaxAge = 7 * 24 * 60 * 60
+
+//Gets the URL that Pogo serves a cached image at, so that pages never link to publishers'
+//servers directly. Images that haven't been cached yet can't be fetched while offline, so
+//they're left out
+func imageURL(r *http.Request, source string, size int) string {
+	if source == "" || (PodCatcher.Offline() && !catcher.ImageCached(source)) {
+		return ""
+	}
+	return basePath(r) + "/image/" + catcher.ImageKey(source) + "/" + strconv.Itoa(size)
+}
+
+//Serves a cached image or one of its thumbnails, e.g. /image/<key>/150. Leaving the size out
+//gets the original image
+func imageHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/image/"), "/")
+	size := 0
+	if len(parts) > 1 && parts[1] != "" {
+		var err error
+		if size, err = strconv.Atoi(parts[1]); err != nil || size < 0 {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	filename, contentType, err := PodCatcher.Image(parts[0], size)
+	if errors.Is(err, catcher.ErrNoImage) || err == catcher.ErrOffline {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(imageMaxAge))
+	//Images come from publishers, so browsers mustn't treat them as anything else
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeFile(w, r, filename)
+}
+
+//Copies some chapters, pointing their images at Pogo's image cache
+func localChapterImages(r *http.Request, chapters []media.Chapter) []media.Chapter {
+	local := make([]media.Chapter, len(chapters))
+	for i, chapter := range chapters {
+		local[i] = chapter
+		local[i].Image = imageURL(r, chapter.Image, 64)
+	}
+	return local
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bytes"
+	"github.com/programmingthomas/Pogo/catcher"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//How far back the inbox goes. Older episodes (like the back catalogue of a podcast somebody
+//has just subscribed to) are left on the podcast's page
+const inboxDays = 30
+
+//An episode along with the podcast it's from
+type podcastEpisode struct {
+	episodeView
+	Podcast   string
+	PodcastID string
+	//Found since the user last looked at their inbox (only used by the inbox)
+	New bool
+}
+
+//A page of the inbox
+type inboxPage struct {
+	Episodes []podcastEpisode
+	Total    int
+	Page     int
+	Pages    int
+	Days     int
+	CSRF     string
+	Offline  bool
+}
+
+//Gets when an episode arrived: when it was published, or when Pogo found it if the feed
+//doesn't say
+func arrived(episode catcher.PodEpisode) time.Time {
+	if episode.HasPubDate() {
+		return episode.Published
+	}
+	return episode.Discovered
+}
+
+//Gets the episodes in a user's inbox: the latest episodes of every podcast they're
+//subscribed to that they haven't played or dismissed, newest first
+func inboxEpisodes(user User, csrf string) []podcastEpisode {
+	since := time.Now().AddDate(0, 0, -inboxDays)
+	episodes := make([]podcastEpisode, 0)
+	for _, podcast := range subscribedPodcasts(user) {
+		for _, episode := range podcast.PodcastEpisodes {
+			state := user.EpisodeState(episode.URL)
+			if state.Played || state.Dismissed || arrived(episode).Before(since) {
+				continue
+			}
+			//Old saves don't know when episodes were found, so go by when they were published
+			found := episode.Discovered
+			if found.IsZero() {
+				found = episode.Published
+			}
+			episodes = append(episodes, podcastEpisode{
+				episodeView: viewEpisode(user, episode, csrf),
+				Podcast:     podcast.Name,
+				PodcastID:   podcast.ID,
+				New:         !user.InboxVisited.IsZero() && found.After(user.InboxVisited),
+			})
+		}
+	}
+	sort.SliceStable(episodes, func(i, j int) bool {
+		return catcher.EpisodesByDate(&episodes[j].PodEpisode, &episodes[i].PodEpisode)
+	})
+	return episodes
+}
+
+//Gets a page of a user's inbox
+func inbox(user User, csrf string, page int) inboxPage {
+	episodes := inboxEpisodes(user, csrf)
+	data := inboxPage{Total: len(episodes), Page: page, Days: inboxDays, CSRF: csrf, Offline: PodCatcher.Offline()}
+	data.Pages = (len(episodes) + defaultPerPage - 1) / defaultPerPage
+	data.Episodes = make([]podcastEpisode, 0)
+	//Pages after the last one are empty (and working out where they'd start could overflow)
+	if page >= 1 && page <= data.Pages {
+		data.Episodes = episodes[(page-1)*defaultPerPage:]
+		if len(data.Episodes) > defaultPerPage {
+			data.Episodes = data.Episodes[:defaultPerPage]
+		}
+	}
+	return data
+}
+
+//Gets a link to the previous page, or "" if this is the first one
+func (data inboxPage) PreviousPage() string {
+	if data.Page <= 1 || data.Pages < 1 {
+		return ""
+	}
+	return "?page=" + strconv.Itoa(min(data.Page-1, data.Pages))
+}
+
+//Gets a link to the next page, or "" if this is the last one
+func (data inboxPage) NextPage() string {
+	if data.Page >= data.Pages {
+		return ""
+	}
+	return "?page=" + strconv.Itoa(data.Page+1)
+}
+
+//Reads the page parameter, which is 1 if it's left out
+func pageNumber(r *http.Request) (int, bool) {
+	if r.FormValue("page") == "" {
+		return 1, true
+	}
+	page, err := strconv.Atoi(r.FormValue("page"))
+	return page, err == nil && page >= 1
+}
+
+//Serves the current user's inbox. Looking at it marks everything in it as seen, so only
+//episodes found after this are new next time
+func inboxHandler(w http.ResponseWriter, r *http.Request) {
+	number, ok := pageNumber(r)
+	if !ok {
+		http.Error(w, "Invalid page", http.StatusBadRequest)
+		return
+	}
+	page := newPage(r, "Inbox - Pogo")
+	user, _ := Users.Find(page.User)
+	data := inbox(user, page.CSRF, number)
+	if !wantsHTML(r) {
+		writeJSON(w, data)
+		return
+	}
+	visited := time.Now()
+	Users.Update(page.User, func(user *User) {
+		user.InboxVisited = visited
+	})
+	content := bytes.NewBufferString("")
+	pageTemplates(r).ExecuteTemplate(content, "inbox.html", data)
+	page.Content = template.HTML(content.String())
+	pageHandler(page, "index.html", w)
+}
+
+//Lists the current user's inbox, or does something to episodes in it. The actions are played
+//(marks them as played), download and dismiss (takes them out of the inbox without playing
+//them). The episodes are given as episode parameters, or all=true for everything in the inbox
+func inboxAPIHandler(w http.ResponseWriter, r *http.Request) {
+	number, ok := pageNumber(r)
+	if !ok {
+		http.Error(w, "Invalid page", http.StatusBadRequest)
+		return
+	}
+	auth := authFor(r)
+	if r.Method == "POST" {
+		user, _ := Users.Find(auth.User)
+		episodeURLs := r.Form["episode"]
+		if r.FormValue("all") == "true" {
+			episodeURLs = make([]string, 0)
+			for _, episode := range inboxEpisodes(user, "") {
+				episodeURLs = append(episodeURLs, episode.URL)
+			}
+		}
+		for _, episodeURL := range episodeURLs {
+			if podcast, _, ok := findEpisode(episodeURL); !ok || !user.IsSubscribed(podcast.FeedURL) {
+				http.Error(w, "No such episode", http.StatusNotFound)
+				return
+			}
+		}
+		var err error
+		switch r.FormValue("action") {
+		case "played":
+			err = Users.UpdateEpisodes(auth.User, episodeURLs, func(state *EpisodeState) {
+				state.Played = true
+				state.Position = 0
+			})
+		case "dismiss":
+			err = Users.UpdateEpisodes(auth.User, episodeURLs, func(state *EpisodeState) {
+				state.Dismissed = true
+			})
+		case "download":
+			if PodCatcher.Offline() {
+				http.Error(w, catcher.ErrOffline.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			for _, episodeURL := range episodeURLs {
+				if err = PodCatcher.DownloadEpisode(episodeURL); err != nil {
+					break
+				}
+			}
+		default:
+			http.Error(w, "Unknown action", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	user, _ := Users.Find(auth.User)
+	respond(w, r, inbox(user, "", number))
+}
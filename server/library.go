@@ -0,0 +1,312 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/programmingthomas/Pogo/catcher"
+	"github.com/programmingthomas/Pogo/media"
+	"github.com/programmingthomas/Pogo/pogoutils"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+//An episode along with the current user's progress through it
+type episodeView struct {
+	catcher.PodEpisode
+	EpisodeState
+	Queued     bool
+	CSRF       string
+	Transcript []media.Cue
+	Offline    bool
+}
+
+//Whether or not the episode can be played. Only downloaded episodes can be played offline
+func (view episodeView) Available() bool {
+	return !view.Offline || view.Downloaded()
+}
+
+//A podcast along with the current user's progress through its episodes
+type podcastView struct {
+	catcher.PodFeed
+	episodePage
+	Subscribed   bool
+	CSRF         string
+	Admin        bool
+	RefreshEvery time.Duration
+	Labels       FeedLabels
+	//The folders the user already has, to pick from
+	Folders []string
+}
+
+//Gets the podcasts that a user is subscribed to
+func subscribedPodcasts(user User) []catcher.PodFeed {
+	podcasts := make([]catcher.PodFeed, 0)
+	for _, podcast := range PodCatcher.AllPodcasts() {
+		if user.IsSubscribed(podcast.FeedURL) {
+			podcasts = append(podcasts, podcast)
+		}
+	}
+	return podcasts
+}
+
+//Finds an episode (and the podcast it belongs to) from its URL
+func findEpisode(episodeURL string) (catcher.PodFeed, catcher.PodEpisode, bool) {
+	for _, podcast := range PodCatcher.AllPodcasts() {
+		for _, episode := range podcast.PodcastEpisodes {
+			if episode.URL == episodeURL {
+				return podcast, episode, true
+			}
+		}
+	}
+	return catcher.PodFeed{}, catcher.PodEpisode{}, false
+}
+
+//Gets the best image for an episode at (about) the given size: its own image from the feed,
+//then the artwork embedded in the download and finally the podcast's image
+func episodeImage(r *http.Request, podcast catcher.PodFeed, episode catcher.PodEpisode, size int) string {
+	switch {
+	case episode.Image != "":
+		return imageURL(r, episode.Image, size)
+	case episode.Artwork != "":
+		return basePath(r) + "/artwork/?episode=" + url.QueryEscape(episode.URL)
+	}
+	return imageURL(r, podcast.Image, size)
+}
+
+//Serves the artwork that was embedded in a downloaded episode
+func artworkHandler(w http.ResponseWriter, r *http.Request) {
+	_, episode, ok := findEpisode(r.FormValue("episode"))
+	if !ok || episode.Artwork == "" || !pogoutils.FileExists(episode.Artwork) {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeFile(w, r, episode.Artwork)
+}
+
+//Combines an episode with the user's progress through it
+func viewEpisode(user User, episode catcher.PodEpisode, csrf string) episodeView {
+	return episodeView{PodEpisode: episode, EpisodeState: user.EpisodeState(episode.URL), Queued: user.InQueue(episode.URL), CSRF: csrf, Offline: PodCatcher.Offline()}
+}
+
+//Gets the episodes in a user's queue, in order
+func queuedEpisodes(user User) []catcher.PodEpisode {
+	episodes := make([]catcher.PodEpisode, 0, len(user.Queue))
+	for _, episodeURL := range user.Queue {
+		if _, episode, ok := findEpisode(episodeURL); ok {
+			episodes = append(episodes, episode)
+		}
+	}
+	return episodes
+}
+
+//Writes a value out as JSON
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	b, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(b)
+}
+
+//Finishes off an API request. Forms submitted from our own pages get sent back to the page
+//they came from, while everything else gets the result as JSON
+func respond(w http.ResponseWriter, r *http.Request, v interface{}) {
+	if wantsHTML(r) {
+		back := basePath(r) + "/home"
+		if referer, err := url.Parse(r.Referer()); err == nil && referer.Host == requestHost(r) {
+			back = safeNext(referer.RequestURI())
+		}
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	writeJSON(w, v)
+}
+
+//Lists the current user's subscriptions (only the ones matching the tag, category and folder
+//parameters, if they're given), subscribes/unsubscribes them from a feed or puts a
+//subscription in a folder and tags it (with the label action, folder and tags separated by
+//commas). A feed is only removed from the catcher when nobody is subscribed to it any more
+func subscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	name := authFor(r).User
+	if r.Method == "POST" {
+		feedURL := r.FormValue("feed")
+		var err error
+		switch r.FormValue("action") {
+		case "subscribe":
+			if PodCatcher.Offline() {
+				http.Error(w, catcher.ErrOffline.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			err = Users.Subscribe(name, feedURL)
+			if err == nil {
+				go PodCatcher.AddPodcastFeed(feedURL)
+			}
+		case "unsubscribe":
+			err = Users.Unsubscribe(name, feedURL)
+			if err == nil && Users.Subscribers(feedURL) == 0 {
+				go PodCatcher.RemovePodcastFeed(feedURL)
+			}
+		case "label":
+			if user, _ := Users.Find(name); !user.IsSubscribed(feedURL) {
+				http.Error(w, "Not subscribed to that feed", http.StatusNotFound)
+				return
+			}
+			err = Users.SetLabels(name, feedURL, parseLabels(r.FormValue("folder"), r.FormValue("tags")))
+		default:
+			http.Error(w, "Unknown action", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	user, _ := Users.Find(name)
+	//The label action's folder parameter isn't a filter
+	filter := parseSubscriptionFilter(r)
+	if r.Method == "POST" || !filter.Active() {
+		respond(w, r, user.Subscriptions)
+		return
+	}
+	feedURLs := make([]string, 0)
+	for _, podcast := range subscribedPodcasts(user) {
+		if filter.matches(user, podcast) {
+			feedURLs = append(feedURLs, podcast.FeedURL)
+		}
+	}
+	respond(w, r, feedURLs)
+}
+
+//Saves the current user's progress through an episode. position is in seconds, and played
+//can be left out to keep the episode's played state as it is
+func progressHandler(w http.ResponseWriter, r *http.Request) {
+	name := authFor(r).User
+	user, _ := Users.Find(name)
+	episodeURL := r.FormValue("episode")
+	if _, _, ok := findEpisode(episodeURL); !ok {
+		http.Error(w, "No such episode", http.StatusNotFound)
+		return
+	}
+	state := user.EpisodeState(episodeURL)
+	if r.Method == "POST" {
+		if position := r.FormValue("position"); position != "" {
+			seconds, err := strconv.ParseFloat(position, 64)
+			if err != nil || seconds < 0 {
+				http.Error(w, "Invalid position", http.StatusBadRequest)
+				return
+			}
+			state.Position = time.Duration(seconds * float64(time.Second))
+		}
+		if played := r.FormValue("played"); played != "" {
+			state.Played = played == "true"
+			if state.Played {
+				state.Position = 0
+			}
+		}
+		if err := Users.SetEpisodeState(name, episodeURL, state); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	respond(w, r, state)
+}
+
+//Lists the episodes in the current user's queue, or changes it. The actions are add (to the
+//end), next (to the front, so that it plays next), move (to index) and remove
+func queueHandler(w http.ResponseWriter, r *http.Request) {
+	name := authFor(r).User
+	if r.Method == "POST" {
+		episodeURL := r.FormValue("episode")
+		if _, _, ok := findEpisode(episodeURL); !ok {
+			http.Error(w, "No such episode", http.StatusNotFound)
+			return
+		}
+		var err error
+		switch r.FormValue("action") {
+		case "add":
+			err = Users.Enqueue(name, episodeURL)
+		case "next":
+			err = Users.PlayNext(name, episodeURL)
+		case "move":
+			index, parseErr := strconv.Atoi(r.FormValue("index"))
+			if parseErr != nil {
+				http.Error(w, "Invalid index", http.StatusBadRequest)
+				return
+			}
+			err = Users.MoveInQueue(name, episodeURL, index)
+		case "remove":
+			err = Users.Dequeue(name, episodeURL)
+		default:
+			http.Error(w, "Unknown action", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	respond(w, r, currentPlayerState(r, name))
+}
+
+//Data for the settings page
+type settingsPage struct {
+	Settings UserSettings
+	Themes   []string
+	Theme    string
+	CSRF     string
+	Saved    bool
+	Admin    bool
+	Limits   downloadLimits
+	Locales  []*Locale
+	//Changed through /api/notifications
+	Notifications notificationSettings
+	Events        []eventChoice
+}
+
+//Serves the settings page and saves the current user's settings
+func settingsHandler(w http.ResponseWriter, r *http.Request) {
+	auth := authFor(r)
+	data := settingsPage{CSRF: auth.CSRF}
+	if r.Method == "POST" {
+		theme := r.FormValue("theme")
+		if !themeExists(theme) {
+			http.Error(w, "No such theme", http.StatusBadRequest)
+			return
+		}
+		locale := r.FormValue("locale")
+		if locale != "" && findLocale(locale) == nil {
+			http.Error(w, "No such locale", http.StatusBadRequest)
+			return
+		}
+		err := Users.Update(auth.User, func(user *User) {
+			user.Settings.HidePlayed = r.FormValue("hideplayed") == "on"
+			user.Settings.Theme = theme
+			user.Settings.Locale = locale
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data.Saved = true
+	}
+	user, _ := Users.Find(auth.User)
+	data.Settings = user.Settings
+	data.Admin = user.Admin
+	data.Limits = currentDownloadLimits()
+	data.Themes = Themes()
+	data.Locales = Locales()
+	data.Theme = themeFor(r)
+	data.Notifications = notificationsFor(user)
+	data.Events = eventChoices(user.Settings.NotifyEvents)
+	page := newPage(r, "Settings - Pogo")
+	content := bytes.NewBufferString("")
+	pageTemplates(r).ExecuteTemplate(content, "settings.html", data)
+	page.Content = template.HTML(content.String())
+	pageHandler(page, "index.html", w)
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+)
+
+//Download rate limits in kilobytes per second. 0 means there isn't a limit
+type downloadLimits struct {
+	Total       int64
+	PerDownload int64
+}
+
+//Gets the current download rate limits
+func currentDownloadLimits() downloadLimits {
+	total, each := PodCatcher.DownloadLimits()
+	return downloadLimits{Total: total >> 10, PerDownload: each >> 10}
+}
+
+//Gets or changes the download rate limits. total limits all downloads together and
+//perdownload limits each one, both in kilobytes per second (0 for no limit). Either can be
+//left out to keep it as it is
+func downloadLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		limits := currentDownloadLimits()
+		for name, limit := range map[string]*int64{"total": &limits.Total, "perdownload": &limits.PerDownload} {
+			value := r.FormValue(name)
+			if value == "" {
+				continue
+			}
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || parsed < 0 {
+				http.Error(w, "Invalid "+name+" limit", http.StatusBadRequest)
+				return
+			}
+			*limit = parsed
+		}
+		if err := PodCatcher.SetDownloadLimits(limits.Total<<10, limits.PerDownload<<10); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	respond(w, r, currentDownloadLimits())
+}
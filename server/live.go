@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/programmingthomas/Pogo/catcher"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//Pages keep a stream of server-sent events open (/api/events) so that they can show new
+//episodes, refreshes and downloads as they happen instead of waiting to be reloaded
+
+//How often idle streams are sent a comment so that proxies don't think they've died
+const liveKeepAlive = 30 * time.Second
+
+//How many events a stream can fall behind by before it starts missing them
+const liveBuffer = 64
+
+//Somebody's open stream
+type liveListener struct {
+	events chan catcher.Event
+}
+
+//Passes the catcher's events on to every open stream
+type liveHub struct {
+	lock      sync.Mutex
+	listeners map[*liveListener]bool
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+var liveEvents = &liveHub{listeners: make(map[*liveListener]bool), closed: make(chan struct{})}
+
+func (hub *liveHub) Notify(event catcher.Event) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	for listener := range hub.listeners {
+		select {
+		case listener.events <- event:
+		default:
+			//It isn't keeping up, so it misses this one rather than holding everyone else up
+		}
+	}
+}
+
+func (hub *liveHub) listen() *liveListener {
+	listener := &liveListener{events: make(chan catcher.Event, liveBuffer)}
+	hub.lock.Lock()
+	hub.listeners[listener] = true
+	hub.lock.Unlock()
+	return listener
+}
+
+func (hub *liveHub) stopListening(listener *liveListener) {
+	hub.lock.Lock()
+	delete(hub.listeners, listener)
+	hub.lock.Unlock()
+}
+
+//Ends every stream, so that the server can shut down without waiting for them
+func (hub *liveHub) close() {
+	hub.closeOnce.Do(func() {
+		close(hub.closed)
+	})
+}
+
+//Gets what someone is shown of an event. Events about podcasts are only shown to the people
+//subscribed to them, except that everyone can see how far a refresh has got (without being
+//told about podcasts that aren't theirs)
+func visibleEvent(user User, event catcher.Event) (catcher.Event, bool) {
+	if (event.FeedURL == "" && event.EpisodeURL == "") || user.IsSubscribed(event.FeedURL) {
+		return event, true
+	}
+	if event.Type == catcher.EventRefreshProgress {
+		event.FeedURL, event.Podcast = "", ""
+		return event, true
+	}
+	return event, false
+}
+
+//Writes an event to a stream in the text/event-stream format, named after its type
+func writeLiveEvent(w http.ResponseWriter, event catcher.Event) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, b)
+	return err
+}
+
+//Streams the catcher's events about the current user's podcasts as server-sent events. The
+//stream starts with the refresh and downloads that are already going on
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	name := authFor(r).User
+	controller := http.NewResponseController(w)
+	//Streams stay open for as long as the page does
+	controller.SetWriteDeadline(time.Time{})
+	listener := liveEvents.listen()
+	defer liveEvents.stopListening(listener)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	//Stops nginx from holding on to events
+	w.Header().Set("X-Accel-Buffering", "no")
+	user, _ := Users.Find(name)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	for _, event := range PodCatcher.Activity() {
+		if shown, ok := visibleEvent(user, event); ok {
+			writeLiveEvent(w, shown)
+		}
+	}
+	if err := controller.Flush(); err != nil {
+		fmt.Println("Can't stream events", err)
+		return
+	}
+	keepAlive := time.NewTicker(liveKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-liveEvents.closed:
+			return
+		case event := <-listener.events:
+			//Someone may have subscribed or unsubscribed since the stream started
+			user, _ = Users.Find(name)
+			shown, ok := visibleEvent(user, event)
+			if !ok {
+				continue
+			}
+			err = writeLiveEvent(w, shown)
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err == nil {
+			err = controller.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"github.com/programmingthomas/Pogo/pogoutils"
+	"net/http"
+	"strconv"
+)
+
+var httpRequests = pogoutils.NewCounter("pogo_http_requests_total",
+	"Number of HTTP requests served, by handler and status code.", "handler", "code")
+
+//Records the status code written by a handler so that it can be counted
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+//Lets http.ResponseController get at the real ResponseWriter
+func (recorder *statusRecorder) Unwrap() http.ResponseWriter {
+	return recorder.ResponseWriter
+}
+
+//Wraps a handler so that every request it serves is counted under the given name
+func instrument(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(recorder, r)
+		httpRequests.Inc(name, strconv.Itoa(recorder.status))
+	}
+}
+
+//Serves up the catcher and server metrics in the Prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	pogoutils.WriteMetrics(w)
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"fmt"
+	"github.com/programmingthomas/Pogo/catcher"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//A test event, which people can send themselves to check that notifications work
+const eventTest = "test"
+
+//The names of events, as they're shown on the settings and account pages
+var eventNames = map[string]string{
+	catcher.EventNewEpisode:     "New episodes",
+	catcher.EventDownloaded:     "Finished downloads",
+	catcher.EventDownloadFailed: "Failed downloads",
+	catcher.EventFeedError:      "Feeds that can't be refreshed",
+}
+
+//An event that can be ticked in a form
+type eventChoice struct {
+	Type     string
+	Name     string
+	Selected bool
+}
+
+//Gets every event for a form, ticking the ones that have been picked
+func eventChoices(selected []string) []eventChoice {
+	choices := make([]eventChoice, len(catcher.EventTypes))
+	for i, eventType := range catcher.EventTypes {
+		choices[i] = eventChoice{Type: eventType, Name: eventNames[eventType], Selected: contains(selected, eventType)}
+	}
+	return choices
+}
+
+//Reads the events picked in a form, leaving out any that don't exist
+func parseEvents(r *http.Request) []string {
+	events := make([]string, 0)
+	for _, eventType := range r.Form["event"] {
+		if contains(catcher.EventTypes, eventType) && !contains(events, eventType) {
+			events = append(events, eventType)
+		}
+	}
+	return events
+}
+
+//Gets the people who are subscribed to the podcast an event is about. Test events are only
+//sent to whoever asked for them, so they don't go through here
+func subscribersOf(feedURL string) []User {
+	users := make([]User, 0)
+	for _, user := range Users.All() {
+		if user.IsSubscribed(feedURL) {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+//Gets the locale to write to someone in when they aren't making a request
+func userLocale(user User) *Locale {
+	if locale := findLocale(user.Settings.Locale); locale != nil {
+		return locale
+	}
+	return findLocale(defaultLocale)
+}
+
+//Describes an event in someone's language, as a title and a line of detail
+func describeEvent(locale *Locale, event catcher.Event) (string, string) {
+	switch event.Type {
+	case catcher.EventNewEpisode:
+		return locale.T("New episode of %s", event.Podcast), event.Episode
+	case catcher.EventDownloaded:
+		return locale.T("Downloaded an episode of %s", event.Podcast), event.Episode
+	case catcher.EventDownloadFailed:
+		return locale.T("Couldn't download an episode of %s", event.Podcast), event.Episode + ": " + event.Error
+	case catcher.EventFeedError:
+		return locale.T("Couldn't refresh %s", event.Podcast), event.Error
+	}
+	return locale.T("Notifications from Pogo are working"), ""
+}
+
+//Gets the page an event is about, relative to Pogo's home page
+func eventPage(event catcher.Event) string {
+	if event.EpisodeURL != "" {
+		return "episode/?episode=" + url.QueryEscape(event.EpisodeURL)
+	}
+	if podcast, ok := PodCatcher.Podcast(event.FeedURL); ok {
+		return "podcast/" + podcast.ID
+	}
+	return "home"
+}
+
+//Emails people about their podcasts
+type emailNotifier struct{}
+
+func (emailNotifier) Notify(event catcher.Event) {
+	if !catcher.Notifiable(event.Type) {
+		return
+	}
+	for _, user := range subscribersOf(event.FeedURL) {
+		if user.Settings.Email != "" && contains(user.Settings.NotifyEvents, event.Type) {
+			emailUser(user, event)
+		}
+	}
+}
+
+//Emails someone about an event
+func emailUser(user User, event catcher.Event) error {
+	subject, detail := describeEvent(userLocale(user), event)
+	err := sendEmail(user.Settings.Email, subject, detail)
+	if err != nil {
+		fmt.Println("Error emailing", user.Name, err)
+	}
+	return err
+}
+
+//Sends a plain text email through the mail server
+func sendEmail(to, subject, body string) error {
+	host, _, err := net.SplitHostPort(SMTPServer)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if SMTPUser != "" {
+		auth = smtp.PlainAuth("", SMTPUser, SMTPPassword, host)
+	}
+	//Subjects come from feeds, so they're encoded in case they have new lines in them
+	headers := []string{
+		"From: " + SMTPFrom,
+		"To: " + to,
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+	}
+	message := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.ReplaceAll(body, "\n", "\r\n") + "\r\n"
+	return smtp.SendMail(SMTPServer, auth, SMTPFrom, []string{to}, []byte(message))
+}
+
+//The current user's notification settings, as served by /api/notifications
+type notificationSettings struct {
+	Email  string
+	Events []string
+	//Whether Pogo can send emails at all
+	EmailEnabled bool
+	//The key browsers need to subscribe to push notifications
+	PushKey           string
+	PushSubscriptions int
+}
+
+//Gets someone's notification settings
+func notificationsFor(user User) notificationSettings {
+	return notificationSettings{
+		Email:             user.Settings.Email,
+		Events:            append([]string{}, user.Settings.NotifyEvents...),
+		EmailEnabled:      SMTPServer != "",
+		PushKey:           vapidPublicKey(),
+		PushSubscriptions: len(user.PushSubscriptions),
+	}
+}
+
+//Gets or changes the current user's notification settings. The actions are settings (email and
+//the events to be told about, as event parameters), subscribe and unsubscribe (a browser's
+//push subscription, given as endpoint, p256dh and auth) and test (sends a test notification
+//by email and to every subscribed browser)
+func notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	name := authFor(r).User
+	if r.Method == "POST" {
+		var err error
+		switch r.FormValue("action") {
+		case "settings":
+			email := strings.TrimSpace(r.FormValue("email"))
+			if email != "" {
+				address, parseErr := mail.ParseAddress(email)
+				if parseErr != nil {
+					http.Error(w, "Invalid email address", http.StatusBadRequest)
+					return
+				}
+				email = address.Address
+			}
+			events := parseEvents(r)
+			err = Users.Update(name, func(user *User) {
+				user.Settings.Email = email
+				user.Settings.NotifyEvents = events
+			})
+		case "subscribe":
+			subscription := PushSubscription{Endpoint: r.FormValue("endpoint"), P256dh: r.FormValue("p256dh"), Auth: r.FormValue("auth"), Created: time.Now()}
+			if err = subscription.check(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = Users.AddPushSubscription(name, subscription)
+		case "unsubscribe":
+			err = Users.RemovePushSubscription(name, r.FormValue("endpoint"))
+		case "test":
+			user, _ := Users.Find(name)
+			event := catcher.Event{Type: eventTest, Time: time.Now(), Podcast: "Pogo"}
+			if SMTPServer != "" && user.Settings.Email != "" {
+				err = emailUser(user, event)
+			}
+			pushToUser(user, event)
+		default:
+			http.Error(w, "Unknown action", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	user, _ := Users.Find(name)
+	respond(w, r, notificationsFor(user))
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+)
+
+//Whether or not Pogo is offline
+type offlineState struct {
+	Offline bool
+}
+
+//Gets whether or not Pogo is offline, or takes it offline (offline=true) or back online
+//(offline=false)
+func offlineHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		switch r.FormValue("offline") {
+		case "true":
+			PodCatcher.SetOffline(true)
+		case "false":
+			PodCatcher.SetOffline(false)
+		default:
+			http.Error(w, "offline must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	respond(w, r, offlineState{Offline: PodCatcher.Offline()})
+}
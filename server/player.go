@@ -0,0 +1,158 @@
+package server
+
+import (
+	"github.com/programmingthomas/Pogo/catcher"
+	"github.com/programmingthomas/Pogo/media"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+//An episode as the player needs it
+type playerEpisode struct {
+	URL       string
+	Title     string
+	Podcast   string
+	Image     string
+	Type      string
+	Source    string
+	Available bool
+	Position  float64
+	Length    float64
+	Page      string
+	Chapters  []playerChapter
+}
+
+//A chapter as the player needs it, with times in seconds
+type playerChapter struct {
+	Title string
+	Start float64
+	End   float64
+	URL   string
+	Image string
+}
+
+//Converts chapters to the player's format
+func toPlayerChapters(chapters []media.Chapter) []playerChapter {
+	converted := make([]playerChapter, len(chapters))
+	for i, chapter := range chapters {
+		converted[i] = playerChapter{
+			Title: chapter.Title,
+			Start: chapter.Start.Seconds(),
+			End:   chapter.End.Seconds(),
+			URL:   chapter.URL,
+			Image: chapter.Image,
+		}
+	}
+	return converted
+}
+
+//Everything the player bar needs to know: what's playing, what's up next and how fast
+type playerState struct {
+	NowPlaying *playerEpisode
+	Queue      []playerEpisode
+	Speed      float64
+}
+
+//Gets the details of an episode for the player. Downloaded episodes are played from Pogo,
+//everything else is streamed from wherever it was published (unless Pogo is offline, in which
+//case it can't be played at all)
+func toPlayerEpisode(r *http.Request, user User, podcast catcher.PodFeed, episode catcher.PodEpisode) playerEpisode {
+	source := episode.URL
+	if episode.Downloaded() {
+		source = basePath(r) + "/" + episode.DownloadedFilename()
+	} else if PodCatcher.Offline() {
+		source = ""
+	}
+	return playerEpisode{
+		URL:       episode.URL,
+		Title:     episode.Title,
+		Podcast:   podcast.Name,
+		Image:     episodeImage(r, podcast, episode, 300),
+		Type:      episode.Type,
+		Source:    source,
+		Available: source != "",
+		Position:  user.EpisodeState(episode.URL).Position.Seconds(),
+		Length:    episode.Length.Seconds(),
+		Page:      basePath(r) + "/episode/?episode=" + url.QueryEscape(episode.URL),
+		Chapters:  toPlayerChapters(localChapterImages(r, episode.Chapters)),
+	}
+}
+
+//Gets the current state of a user's player
+func currentPlayerState(r *http.Request, name string) playerState {
+	user, _ := Users.Find(name)
+	state := playerState{Queue: make([]playerEpisode, 0, len(user.Queue)), Speed: user.Settings.PlaybackSpeed}
+	if state.Speed <= 0 {
+		state.Speed = 1
+	}
+	if podcast, episode, ok := findEpisode(user.NowPlaying); ok {
+		//Make sure the chapters have been loaded for whatever is playing
+		episode.Chapters = PodCatcher.Chapters(episode.URL)
+		nowPlaying := toPlayerEpisode(r, user, podcast, episode)
+		state.NowPlaying = &nowPlaying
+	}
+	for _, episodeURL := range user.Queue {
+		if podcast, episode, ok := findEpisode(episodeURL); ok {
+			state.Queue = append(state.Queue, toPlayerEpisode(r, user, podcast, episode))
+		}
+	}
+	return state
+}
+
+//Gets or changes what the current user's player is doing. The actions are:
+//play (start playing episode), next (move on to the next queued episode, marking the current
+//one as played if finished is true), stop and speed (remember the playback speed)
+func playerHandler(w http.ResponseWriter, r *http.Request) {
+	name := authFor(r).User
+	if r.Method == "POST" {
+		var err error
+		switch r.FormValue("action") {
+		case "play":
+			episodeURL := r.FormValue("episode")
+			_, episode, ok := findEpisode(episodeURL)
+			if !ok {
+				http.Error(w, "No such episode", http.StatusNotFound)
+				return
+			}
+			if PodCatcher.Offline() && !episode.Downloaded() {
+				http.Error(w, "This episode hasn't been downloaded, so it can't be played offline", http.StatusConflict)
+				return
+			}
+			err = Users.Play(name, episodeURL)
+		case "next":
+			err = Users.PlayNextInQueue(name, r.FormValue("finished") == "true")
+		case "stop":
+			err = Users.Update(name, func(user *User) {
+				user.NowPlaying = ""
+			})
+		case "speed":
+			speed, parseErr := strconv.ParseFloat(r.FormValue("speed"), 64)
+			if parseErr != nil || speed < 0.25 || speed > 4 {
+				http.Error(w, "Invalid speed", http.StatusBadRequest)
+				return
+			}
+			err = Users.Update(name, func(user *User) {
+				user.Settings.PlaybackSpeed = speed
+			})
+		default:
+			http.Error(w, "Unknown action", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	respond(w, r, currentPlayerState(r, name))
+}
+
+//Gets the chapters of an episode (with times in seconds)
+func chaptersHandler(w http.ResponseWriter, r *http.Request) {
+	episodeURL := r.FormValue("episode")
+	if _, _, ok := findEpisode(episodeURL); !ok {
+		http.Error(w, "No such episode", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, toPlayerChapters(localChapterImages(r, PodCatcher.Chapters(episodeURL))))
+}
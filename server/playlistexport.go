@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+//A playlist as an RSS feed, so that it can be subscribed to in other podcast apps
+type playlistFeed struct {
+	XMLName xml.Name            `xml:"rss"`
+	Version string              `xml:"version,attr"`
+	ITunes  string              `xml:"xmlns:itunes,attr"`
+	Channel playlistFeedChannel `xml:"channel"`
+}
+
+type playlistFeedChannel struct {
+	Title       string             `xml:"title"`
+	Link        string             `xml:"link"`
+	Description string             `xml:"description"`
+	Items       []playlistFeedItem `xml:"item"`
+}
+
+type playlistFeedItem struct {
+	Title       string `xml:"title"`
+	GUID        playlistFeedGUID
+	PubDate     string `xml:"pubDate,omitempty"`
+	Description string `xml:"description,omitempty"`
+	Enclosure   playlistFeedEnclosure
+	Duration    string `xml:"itunes:duration,omitempty"`
+	Author      string `xml:"itunes:author,omitempty"`
+}
+
+type playlistFeedGUID struct {
+	XMLName     xml.Name `xml:"guid"`
+	IsPermaLink bool     `xml:"isPermaLink,attr"`
+	Value       string   `xml:",chardata"`
+}
+
+type playlistFeedEnclosure struct {
+	XMLName xml.Name `xml:"enclosure"`
+	URL     string   `xml:"url,attr"`
+	Length  int      `xml:"length,attr"`
+	Type    string   `xml:"type,attr"`
+}
+
+//Gets the URL that other apps should play an episode from. Downloaded episodes are served by
+//Pogo (which also works when it's offline), and everything else comes from the podcast itself.
+//Apps can't log in, so if the playlist was fetched with an API token the downloads use it too
+func mediaURL(r *http.Request, episode podcastEpisode) string {
+	if !episode.Downloaded() {
+		return episode.URL
+	}
+	target := absoluteURL(r, "/downloads/"+url.PathEscape(path.Base(episode.DownloadedFilename())))
+	if token := r.URL.Query().Get("token"); token != "" {
+		target += "?token=" + url.QueryEscape(token)
+	}
+	return target
+}
+
+//Gets a duration the way feeds write them, like 1:02:03
+func feedDuration(episode podcastEpisode) string {
+	if episode.Length == 0 {
+		return ""
+	}
+	seconds := int(episode.Length.Seconds())
+	return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
+
+//Writes a playlist out as an M3U playlist, for media players
+func writeM3U(w http.ResponseWriter, r *http.Request, playlist Playlist, episodes []podcastEpisode) {
+	w.Header().Set("Content-Type", "audio/x-mpegurl; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="`+playlist.ID+`.m3u"`)
+	fmt.Fprintln(w, "#EXTM3U")
+	fmt.Fprintln(w, "#PLAYLIST:"+oneLine(playlist.Name))
+	for _, episode := range episodes {
+		//-1 is how M3U says it doesn't know how long something is
+		length := -1
+		if episode.Length != 0 {
+			length = int(episode.Length.Seconds())
+		}
+		fmt.Fprintf(w, "#EXTINF:%d,%s - %s\n", length, oneLine(episode.Podcast), oneLine(episode.Title))
+		fmt.Fprintln(w, mediaURL(r, episode))
+	}
+}
+
+//Keeps text from feeds on one line, since a new line would start a new entry in an M3U file
+func oneLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+//Writes a playlist out as an RSS feed, for podcast apps
+func writeRSS(w http.ResponseWriter, r *http.Request, playlist Playlist, episodes []podcastEpisode) {
+	feed := playlistFeed{
+		Version: "2.0",
+		ITunes:  "http://www.itunes.com/dtds/podcast-1.0.dtd",
+		Channel: playlistFeedChannel{
+			Title:       playlist.Name,
+			Link:        absoluteURL(r, "/playlist/"+playlist.ID),
+			Description: "A Pogo playlist",
+			Items:       make([]playlistFeedItem, 0, len(episodes)),
+		},
+	}
+	for _, episode := range episodes {
+		item := playlistFeedItem{
+			Title:       episode.Title,
+			GUID:        playlistFeedGUID{Value: episode.URL},
+			Description: episode.PlainTextDescription(),
+			Enclosure:   playlistFeedEnclosure{URL: mediaURL(r, episode), Type: episode.Type},
+			Duration:    feedDuration(episode),
+			Author:      episode.Podcast,
+		}
+		if episode.HasPubDate() {
+			item.PubDate = episode.Published.Format(time.RFC1123Z)
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+	b, err := xml.MarshalIndent(feed, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(b)
+}
@@ -0,0 +1,415 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/programmingthomas/Pogo/catcher"
+	"html/template"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//A saved playlist. Its episodes aren't stored: they're whichever episodes of the user's
+//subscriptions match its rules at the time, so it keeps itself up to date
+type Playlist struct {
+	ID    string
+	Name  string
+	Rules PlaylistRules
+}
+
+//What an episode has to be like to be in a playlist. An episode has to match every rule that
+//is set, and rules that are left empty match everything
+type PlaylistRules struct {
+	//Feed URLs. Empty for all of the user's subscriptions
+	Podcasts []string
+	//0 for no limit
+	MinLength time.Duration
+	MaxLength time.Duration
+	//audio, video or any
+	Type string
+	//Only episodes from the last however long. 0 for any time
+	Within time.Duration
+	//yes, no or any
+	Played     string
+	Downloaded string
+	//Podcast categories. Episodes only have to be in one of them, and a category also matches
+	//its subcategories (so Technology matches Technology/Podcasting)
+	Categories []string
+	//Words that all have to be in the episode's title or description
+	Keywords string
+	//How the episodes are sorted, like on podcast pages
+	Sort  string
+	Order string
+	//The most episodes the playlist has. 0 for no limit
+	Limit int
+}
+
+//Copies a playlist so that it doesn't share anything with the original
+func (playlist Playlist) clone() Playlist {
+	copied := playlist
+	copied.Rules.Podcasts = append([]string{}, playlist.Rules.Podcasts...)
+	copied.Rules.Categories = append([]string{}, playlist.Rules.Categories...)
+	return copied
+}
+
+//Finds one of the user's playlists by its ID
+func (user User) Playlist(id string) (Playlist, bool) {
+	for _, playlist := range user.Playlists {
+		if playlist.ID == id {
+			return playlist.clone(), true
+		}
+	}
+	return Playlist{}, false
+}
+
+//Makes up a short ID for something new, like a playlist or a webhook
+func newID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+//Reads a playlist from a form. The rules are given as podcast (a feed URL, which can be given
+//more than once), minlength and maxlength (in minutes), type, days, played, downloaded,
+//category (which can be given more than once too), keywords, sort, order and limit
+func parsePlaylist(r *http.Request) (Playlist, error) {
+	r.ParseForm()
+	playlist := Playlist{Name: strings.TrimSpace(r.FormValue("name"))}
+	if playlist.Name == "" {
+		return playlist, errors.New("playlists need a name")
+	}
+	//Playlists are filtered and sorted like podcast pages, so they're checked the same way.
+	//Nobody's settings are used here though, since played episodes are a rule of their own
+	query, err := parseEpisodeQuery(r, User{})
+	if err != nil {
+		return playlist, err
+	}
+	playlist.Rules = PlaylistRules{
+		Podcasts:   nonEmpty(r.Form["podcast"]),
+		Type:       query.Type,
+		Played:     query.Played,
+		Downloaded: query.Downloaded,
+		Categories: nonEmpty(r.Form["category"]),
+		Keywords:   strings.TrimSpace(r.FormValue("keywords")),
+		Sort:       query.Sort,
+		Order:      query.Order,
+	}
+	numbers := make(map[string]int)
+	for _, name := range []string{"minlength", "maxlength", "days", "limit"} {
+		if value := strings.TrimSpace(r.FormValue(name)); value != "" {
+			number, err := strconv.Atoi(value)
+			if err != nil || number < 0 {
+				return playlist, fmt.Errorf("%s should be a number", name)
+			}
+			numbers[name] = number
+		}
+	}
+	playlist.Rules.MinLength = time.Duration(numbers["minlength"]) * time.Minute
+	playlist.Rules.MaxLength = time.Duration(numbers["maxlength"]) * time.Minute
+	playlist.Rules.Within = time.Duration(numbers["days"]) * 24 * time.Hour
+	playlist.Rules.Limit = numbers["limit"]
+	if playlist.Rules.MaxLength != 0 && playlist.Rules.MaxLength < playlist.Rules.MinLength {
+		return playlist, errors.New("maxlength can't be less than minlength")
+	}
+	return playlist, nil
+}
+
+//Leaves out blank values, which forms send for menus where nothing was picked
+func nonEmpty(values []string) []string {
+	kept := make([]string, 0, len(values))
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
+
+//Whether or not one of a podcast's categories is one of the ones asked for
+func inCategory(podcast catcher.PodFeed, categories []string) bool {
+	for _, wanted := range categories {
+		wanted = strings.ToLower(wanted)
+		for _, category := range podcast.Categories {
+			category = strings.ToLower(category)
+			if category == wanted || strings.HasPrefix(category, wanted+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//Whether or not an episode belongs in a playlist
+func (rules PlaylistRules) matches(user User, podcast catcher.PodFeed, episode catcher.PodEpisode, now time.Time) bool {
+	if len(rules.Podcasts) > 0 && !contains(rules.Podcasts, podcast.FeedURL) {
+		return false
+	}
+	if len(rules.Categories) > 0 && !inCategory(podcast, rules.Categories) {
+		return false
+	}
+	//Episodes we don't know the length of can't be said to be long or short enough
+	if (rules.MinLength != 0 || rules.MaxLength != 0) && episode.Length == 0 {
+		return false
+	}
+	if episode.Length < rules.MinLength || (rules.MaxLength != 0 && episode.Length > rules.MaxLength) {
+		return false
+	}
+	if rules.Within != 0 && arrived(episode).Before(now.Add(-rules.Within)) {
+		return false
+	}
+	if !rules.query().matches(user, episode) {
+		return false
+	}
+	if keywords := strings.Fields(strings.ToLower(rules.Keywords)); len(keywords) > 0 {
+		text := strings.ToLower(episode.Title + " " + episode.PlainTextDescription())
+		for _, keyword := range keywords {
+			if !strings.Contains(text, keyword) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//Gets the episode query that filters and sorts episodes the same way as the playlist
+func (rules PlaylistRules) query() episodeQuery {
+	return episodeQuery{Sort: rules.Sort, Order: rules.Order, Downloaded: rules.Downloaded, Played: rules.Played, Type: rules.Type}
+}
+
+//Whether or not a list has a certain string in it
+func contains(list []string, wanted string) bool {
+	for _, item := range list {
+		if item == wanted {
+			return true
+		}
+	}
+	return false
+}
+
+//Gets the episodes of a user's subscriptions that are in a playlist, in the playlist's order
+func (playlist Playlist) Episodes(user User, csrf string) []podcastEpisode {
+	now := time.Now()
+	episodes := make([]podcastEpisode, 0)
+	for _, podcast := range subscribedPodcasts(user) {
+		for _, episode := range podcast.PodcastEpisodes {
+			if playlist.Rules.matches(user, podcast, episode, now) {
+				episodes = append(episodes, podcastEpisode{
+					episodeView: viewEpisode(user, episode, csrf),
+					Podcast:     podcast.Name,
+					PodcastID:   podcast.ID,
+				})
+			}
+		}
+	}
+	//Newest first when the sort can't tell episodes apart
+	by := playlist.Rules.query().sorter(user)
+	newest := catcher.EpisodesBy(catcher.EpisodesByDate).Reverse()
+	sort.SliceStable(episodes, func(i, j int) bool {
+		return newest(&episodes[i].PodEpisode, &episodes[j].PodEpisode)
+	})
+	sort.SliceStable(episodes, func(i, j int) bool {
+		return by(&episodes[i].PodEpisode, &episodes[j].PodEpisode)
+	})
+	if playlist.Rules.Limit != 0 && len(episodes) > playlist.Rules.Limit {
+		episodes = episodes[:playlist.Rules.Limit]
+	}
+	return episodes
+}
+
+//The rules of a playlist as they're shown in the form for changing them
+type playlistForm struct {
+	Playlist
+	//What the rules can be set to
+	Podcasts   []catcher.PodFeed
+	Categories []string
+	CSRF       string
+}
+
+//Gets the form for a playlist, with the podcasts and categories the user could pick from
+func newPlaylistForm(user User, playlist Playlist, csrf string) playlistForm {
+	form := playlistForm{Playlist: playlist, Podcasts: subscribedPodcasts(user), CSRF: csrf}
+	form.Categories = podcastCategories(form.Podcasts)
+	return form
+}
+
+//Whether or not the playlist includes a podcast
+func (form playlistForm) HasPodcast(feedURL string) bool {
+	return contains(form.Rules.Podcasts, feedURL)
+}
+
+//Whether or not the playlist includes a category
+func (form playlistForm) HasCategory(category string) bool {
+	return contains(form.Rules.Categories, category)
+}
+
+//The rules that are durations, in the units the form uses
+func (form playlistForm) MinMinutes() int {
+	return int(form.Rules.MinLength / time.Minute)
+}
+
+func (form playlistForm) MaxMinutes() int {
+	return int(form.Rules.MaxLength / time.Minute)
+}
+
+func (form playlistForm) Days() int {
+	return int(form.Rules.Within / (24 * time.Hour))
+}
+
+//The playlists page: the user's playlists and a form for making a new one
+type playlistsPage struct {
+	Playlists []playlistSummary
+	Form      playlistForm
+}
+
+//A playlist in the list of playlists
+type playlistSummary struct {
+	Playlist
+	Count int
+}
+
+//A playlist along with the episodes that are in it
+type playlistView struct {
+	Playlist
+	Episodes []podcastEpisode
+	Form     playlistForm `json:"-"`
+	Offline  bool         `json:"-"`
+}
+
+//Gets a playlist and its episodes
+func viewPlaylist(user User, playlist Playlist, csrf string) playlistView {
+	return playlistView{Playlist: playlist, Episodes: playlist.Episodes(user, csrf), Form: newPlaylistForm(user, playlist, csrf), Offline: PodCatcher.Offline()}
+}
+
+//Lists the user's playlists along with how many episodes are in each of them
+func summarisePlaylists(user User) []playlistSummary {
+	summaries := make([]playlistSummary, 0, len(user.Playlists))
+	for _, playlist := range user.Playlists {
+		summaries = append(summaries, playlistSummary{Playlist: playlist, Count: len(playlist.Episodes(user, ""))})
+	}
+	return summaries
+}
+
+//Serves the list of the current user's playlists
+func playlistsHandler(w http.ResponseWriter, r *http.Request) {
+	page := newPage(r, "Playlists - Pogo")
+	user, _ := Users.Find(page.User)
+	if !wantsHTML(r) {
+		writeJSON(w, summarisePlaylists(user))
+		return
+	}
+	//New playlists start off as every unplayed episode, newest first
+	empty := Playlist{Rules: PlaylistRules{Type: "any", Played: "no", Downloaded: "any", Sort: "date", Order: "desc"}}
+	data := playlistsPage{Playlists: summarisePlaylists(user), Form: newPlaylistForm(user, empty, page.CSRF)}
+	content := bytes.NewBufferString("")
+	pageTemplates(r).ExecuteTemplate(content, "playlists.html", data)
+	page.Content = template.HTML(content.String())
+	pageHandler(page, "index.html", w)
+}
+
+//Serves a playlist: as a page at /playlist/ID, or for other apps at /playlist/ID.m3u and
+///playlist/ID.rss
+func playlistHandler(w http.ResponseWriter, r *http.Request) {
+	base := path.Base(r.URL.Path)
+	id := strings.TrimSuffix(strings.TrimSuffix(base, ".m3u"), ".rss")
+	auth := authFor(r)
+	user, _ := Users.Find(auth.User)
+	playlist, ok := user.Playlist(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case strings.HasSuffix(base, ".m3u"):
+		writeM3U(w, r, playlist, playlist.Episodes(user, ""))
+		return
+	case strings.HasSuffix(base, ".rss"):
+		writeRSS(w, r, playlist, playlist.Episodes(user, ""))
+		return
+	}
+	page := newPage(r, playlist.Name+" - Pogo")
+	view := viewPlaylist(user, playlist, page.CSRF)
+	if !wantsHTML(r) {
+		writeJSON(w, view)
+		return
+	}
+	content := bytes.NewBufferString("")
+	pageTemplates(r).ExecuteTemplate(content, "playlist.html", view)
+	page.Content = template.HTML(content.String())
+	pageHandler(page, "index.html", w)
+}
+
+//Lists the current user's playlists (or gets one of them, with its episodes, when an id is
+//given), or changes them. The actions are create, update and delete, and the rules are read
+//like parsePlaylist says
+func playlistsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	auth := authFor(r)
+	user, _ := Users.Find(auth.User)
+	id := r.FormValue("id")
+	if r.Method == "POST" {
+		action := r.FormValue("action")
+		if action != "create" {
+			if _, ok := user.Playlist(id); !ok {
+				http.Error(w, "No such playlist", http.StatusNotFound)
+				return
+			}
+		}
+		var err error
+		switch action {
+		case "create", "update":
+			var playlist Playlist
+			if playlist, err = parsePlaylist(r); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if action == "create" {
+				id = newID()
+			}
+			playlist.ID = id
+			err = Users.SavePlaylist(auth.User, playlist)
+		case "delete":
+			err = Users.DeletePlaylist(auth.User, id)
+		default:
+			http.Error(w, "Unknown action", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		user, _ = Users.Find(auth.User)
+		if wantsHTML(r) {
+			//The page the form was on might not make sense any more
+			switch action {
+			case "create":
+				redirect(w, r, "/playlist/"+id)
+				return
+			case "delete":
+				redirect(w, r, "/playlists")
+				return
+			}
+		}
+		if action == "delete" {
+			respond(w, r, summarisePlaylists(user))
+			return
+		}
+	}
+	if id == "" {
+		respond(w, r, summarisePlaylists(user))
+		return
+	}
+	playlist, ok := user.Playlist(id)
+	if !ok {
+		http.Error(w, "No such playlist", http.StatusNotFound)
+		return
+	}
+	respond(w, r, viewPlaylist(user, playlist, ""))
+}
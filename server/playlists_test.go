@@ -0,0 +1,98 @@
+package server
+
+import (
+	"github.com/programmingthomas/Pogo/catcher"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPlaylistRulesMatches(t *testing.T) {
+	now := time.Date(2024, time.March, 5, 12, 0, 0, 0, time.UTC)
+	podcast := catcher.PodFeed{FeedURL: "https://example.com/feed.xml", Categories: []string{"Technology/Podcasting", "News"}}
+	episode := catcher.PodEpisode{
+		URL:         "https://example.com/1.mp3",
+		Title:       "Building a Go server",
+		Description: "<p>We talk about <b>HTTP</b> handlers</p>",
+		Type:        "audio/mpeg",
+		Length:      45 * time.Minute,
+		Published:   now.Add(-48 * time.Hour),
+	}
+	user := User{Name: "a", Episodes: map[string]EpisodeState{"https://example.com/played.mp3": {Played: true}}}
+	everything := PlaylistRules{Type: "any", Played: "any", Downloaded: "any"}
+	with := func(change func(*PlaylistRules)) PlaylistRules {
+		rules := everything
+		change(&rules)
+		return rules
+	}
+	tests := []struct {
+		name    string
+		rules   PlaylistRules
+		episode catcher.PodEpisode
+		want    bool
+	}{
+		{"no rules", everything, episode, true},
+		{"podcast", with(func(r *PlaylistRules) { r.Podcasts = []string{"https://example.com/other.xml", podcast.FeedURL} }), episode, true},
+		{"other podcast", with(func(r *PlaylistRules) { r.Podcasts = []string{"https://example.com/other.xml"} }), episode, false},
+		{"category", with(func(r *PlaylistRules) { r.Categories = []string{"news"} }), episode, true},
+		{"parent category", with(func(r *PlaylistRules) { r.Categories = []string{"Technology"} }), episode, true},
+		{"category that only starts the same", with(func(r *PlaylistRules) { r.Categories = []string{"Tech"} }), episode, false},
+		{"other category", with(func(r *PlaylistRules) { r.Categories = []string{"Comedy"} }), episode, false},
+		{"long enough", with(func(r *PlaylistRules) { r.MinLength = 45 * time.Minute }), episode, true},
+		{"too short", with(func(r *PlaylistRules) { r.MinLength = 46 * time.Minute }), episode, false},
+		{"short enough", with(func(r *PlaylistRules) { r.MaxLength = 45 * time.Minute }), episode, true},
+		{"too long", with(func(r *PlaylistRules) { r.MaxLength = 30 * time.Minute }), episode, false},
+		{"unknown length with a length rule", with(func(r *PlaylistRules) { r.MaxLength = time.Hour }), catcher.PodEpisode{Type: "audio/mpeg", Published: now}, false},
+		{"unknown length without one", everything, catcher.PodEpisode{Type: "audio/mpeg", Published: now}, true},
+		{"recent", with(func(r *PlaylistRules) { r.Within = 72 * time.Hour }), episode, true},
+		{"too old", with(func(r *PlaylistRules) { r.Within = 24 * time.Hour }), episode, false},
+		{"discovered recently without a date", with(func(r *PlaylistRules) { r.Within = 24 * time.Hour }), catcher.PodEpisode{Discovered: now.Add(-time.Hour)}, true},
+		{"audio", with(func(r *PlaylistRules) { r.Type = "audio" }), episode, true},
+		{"video", with(func(r *PlaylistRules) { r.Type = "video" }), episode, false},
+		{"unplayed", with(func(r *PlaylistRules) { r.Played = "no" }), episode, true},
+		{"played", with(func(r *PlaylistRules) { r.Played = "yes" }), episode, false},
+		{"played episode", with(func(r *PlaylistRules) { r.Played = "yes" }), catcher.PodEpisode{URL: "https://example.com/played.mp3"}, true},
+		{"not downloaded", with(func(r *PlaylistRules) { r.Downloaded = "no" }), episode, true},
+		{"downloaded", with(func(r *PlaylistRules) { r.Downloaded = "yes" }), episode, false},
+		{"keyword in title", with(func(r *PlaylistRules) { r.Keywords = "go" }), episode, true},
+		{"keywords in title and description", with(func(r *PlaylistRules) { r.Keywords = "  Server   http " }), episode, true},
+		{"keyword that isn't there", with(func(r *PlaylistRules) { r.Keywords = "server rust" }), episode, false},
+		{"keyword in the markup", with(func(r *PlaylistRules) { r.Keywords = "<b>" }), episode, false},
+		{"every rule", PlaylistRules{
+			Podcasts:   []string{podcast.FeedURL},
+			MinLength:  30 * time.Minute,
+			MaxLength:  time.Hour,
+			Type:       "audio",
+			Within:     7 * 24 * time.Hour,
+			Played:     "no",
+			Downloaded: "no",
+			Categories: []string{"Technology"},
+			Keywords:   "handlers",
+		}, episode, true},
+	}
+	for _, test := range tests {
+		if got := test.rules.matches(user, podcast, test.episode, now); got != test.want {
+			t.Errorf("%s: matches = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParsePlaylist(t *testing.T) {
+	tests := []struct {
+		form string
+		ok   bool
+	}{
+		{"name=Short&maxlength=20", true},
+		{"name=Recent&days=7&limit=10&category=News&category=", true},
+		{"maxlength=20", false},
+		{"name=+&maxlength=20", false},
+		{"name=Short&maxlength=twenty", false},
+		{"name=Short&limit=-1", false},
+		{"name=Short&minlength=30&maxlength=20", false},
+	}
+	for _, test := range tests {
+		if _, err := parsePlaylist(httptest.NewRequest("GET", "/?"+test.form, nil)); (err == nil) != test.ok {
+			t.Errorf("parsePlaylist(%q) = %v, want ok %v", test.form, err, test.ok)
+		}
+	}
+}
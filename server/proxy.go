@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+//Gets the first value of a comma separated X-Forwarded-* header, but only if we've been
+//told to trust the reverse proxy in front of us (otherwise anyone could set them)
+func forwarded(r *http.Request, header string) string {
+	if !TrustProxy {
+		return ""
+	}
+	value := r.Header.Get(header)
+	if comma := strings.Index(value, ","); comma >= 0 {
+		value = value[:comma]
+	}
+	return strings.TrimSpace(value)
+}
+
+//Gets the IP address of whoever made the request
+func clientIP(r *http.Request) string {
+	if ip := forwarded(r, "X-Forwarded-For"); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+//Gets the host name that the browser used to reach Pogo
+func requestHost(r *http.Request) string {
+	if host := forwarded(r, "X-Forwarded-Host"); host != "" {
+		return host
+	}
+	return r.Host
+}
+
+//Whether or not the browser is talking to us (or our proxy) over HTTPS
+func isHTTPS(r *http.Request) bool {
+	if proto := forwarded(r, "X-Forwarded-Proto"); proto != "" {
+		return proto == "https"
+	}
+	return r.TLS != nil
+}
+
+//Gets the path that all of Pogo's pages live under, as seen by the browser. This is the
+//configured URLPrefix plus any prefix that a proxy stripped off before passing the request on
+func basePath(r *http.Request) string {
+	return strings.TrimSuffix(forwarded(r, "X-Forwarded-Prefix"), "/") + URLPrefix
+}
+
+//Redirects to one of Pogo's own pages (e.g. /home), taking the URL prefix into account
+func redirect(w http.ResponseWriter, r *http.Request, target string) {
+	http.Redirect(w, r, basePath(r)+target, http.StatusSeeOther)
+}
+
+//Wraps Pogo's handlers so that they can be served under URLPrefix (e.g. /pogo/home)
+func withPrefix(handler http.Handler) http.Handler {
+	if URLPrefix == "" {
+		return handler
+	}
+	stripped := http.StripPrefix(URLPrefix, handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == URLPrefix {
+			http.Redirect(w, r, basePath(r)+"/", http.StatusMovedPermanently)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, URLPrefix+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		stripped.ServeHTTP(w, r)
+	})
+}
+
+//Gets the full URL of one of Pogo's own pages (e.g. /home), for things that are used outside
+//of the browser, like playlist files
+func absoluteURL(r *http.Request, target string) string {
+	scheme := "http"
+	if isHTTPS(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + requestHost(r) + basePath(r) + target
+}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/programmingthomas/Pogo/catcher"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//A browser that has asked for push notifications. The keys are the ones the browser gave us
+//to encrypt notifications with (base64, as browsers give them out)
+type PushSubscription struct {
+	Endpoint string
+	P256dh   string
+	Auth     string
+	Created  time.Time
+}
+
+//The key Pogo signs push notifications with (see RFC 8292), which browsers check the
+//notifications against. It's made the first time Pogo starts and kept in PushKeysLocation.
+//Restoring a backup can change it, so use pushKey and setPushKey
+var (
+	vapidKey     *ecdsa.PrivateKey
+	vapidKeyLock sync.RWMutex
+)
+
+//Gets the push key, or nil if push notifications aren't available
+func pushKey() *ecdsa.PrivateKey {
+	vapidKeyLock.RLock()
+	defer vapidKeyLock.RUnlock()
+	return vapidKey
+}
+
+func setPushKey(key *ecdsa.PrivateKey) {
+	vapidKeyLock.Lock()
+	defer vapidKeyLock.Unlock()
+	vapidKey = key
+}
+
+//How long push services should keep trying to deliver a notification to a browser that's
+//turned off
+const pushTTL = 24 * time.Hour
+
+var pushClient = &http.Client{Timeout: 15 * time.Second}
+
+//How push keys are saved
+type savedPushKeys struct {
+	//PKCS #8, base64
+	PrivateKey string
+}
+
+//Loads the push notification key, making a new one if there isn't one yet
+func loadPushKeys(location string) (*ecdsa.PrivateKey, error) {
+	contents, err := ioutil.ReadFile(location)
+	if err == nil {
+		var saved savedPushKeys
+		if err = json.Unmarshal(contents, &saved); err != nil {
+			return nil, err
+		}
+		der, err := base64.StdEncoding.DecodeString(saved.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := parsed.(*ecdsa.PrivateKey)
+		if !ok || key.Curve != elliptic.P256() {
+			return nil, errors.New("push key isn't a P-256 key")
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.MarshalIndent(savedPushKeys{PrivateKey: base64.StdEncoding.EncodeToString(der)}, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return key, ioutil.WriteFile(location, b, 0600)
+}
+
+//Gets the public half of the push key the way browsers want it (an uncompressed point,
+//base64url), or "" if push notifications aren't available
+func vapidPublicKey() string {
+	return encodePublicKey(pushKey())
+}
+
+func encodePublicKey(key *ecdsa.PrivateKey) string {
+	if key == nil {
+		return ""
+	}
+	public, err := key.PublicKey.ECDH()
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(public.Bytes())
+}
+
+//Decodes the base64 that browsers use for their keys, which may or may not be padded
+func decodeBrowserKey(key string) ([]byte, error) {
+	for _, encoding := range []*base64.Encoding{base64.RawURLEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.StdEncoding} {
+		if b, err := encoding.DecodeString(key); err == nil {
+			return b, nil
+		}
+	}
+	return nil, errors.New("invalid key")
+}
+
+//Checks that a subscription that a browser sent us can be used
+func (subscription PushSubscription) check() error {
+	endpoint, err := url.Parse(subscription.Endpoint)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+		return errors.New("push endpoints need to be https URLs")
+	}
+	public, err := decodeBrowserKey(subscription.P256dh)
+	if err == nil {
+		_, err = ecdh.P256().NewPublicKey(public)
+	}
+	if err != nil {
+		return errors.New("invalid p256dh key")
+	}
+	if secret, err := decodeBrowserKey(subscription.Auth); err != nil || len(secret) != 16 {
+		return errors.New("invalid auth secret")
+	}
+	return nil
+}
+
+//Encrypts a notification so that only the browser can read it, as described in RFC 8291 (the
+//aes128gcm content coding from RFC 8188, with a key agreed using the browser's public key)
+func encryptPush(subscription PushSubscription, plaintext []byte) ([]byte, error) {
+	browserKey, err := decodeBrowserKey(subscription.P256dh)
+	if err != nil {
+		return nil, err
+	}
+	browserPublic, err := ecdh.P256().NewPublicKey(browserKey)
+	if err != nil {
+		return nil, err
+	}
+	authSecret, err := decodeBrowserKey(subscription.Auth)
+	if err != nil {
+		return nil, err
+	}
+	//A new key for every message
+	private, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := private.ECDH(browserPublic)
+	if err != nil {
+		return nil, err
+	}
+	public := private.PublicKey().Bytes()
+	prk, err := hkdf.Extract(sha256.New, shared, authSecret)
+	if err != nil {
+		return nil, err
+	}
+	ikm, err := hkdf.Expand(sha256.New, prk, "WebPush: info\x00"+string(browserKey)+string(public), 32)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, err
+	}
+	prk, err = hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	contentKey, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	//Everything fits in one record, which ends with a 2 to say it's the last one
+	record := append(append([]byte{}, plaintext...), 2)
+	header := bytes.NewBuffer(salt)
+	binary.Write(header, binary.BigEndian, uint32(4096))
+	header.WriteByte(byte(len(public)))
+	header.Write(public)
+	return gcm.Seal(header.Bytes(), nonce, record, nil), nil
+}
+
+//Makes the Authorization header that proves a notification came from us (RFC 8292): a JWT for
+//the push service, signed with the push key
+func vapidAuthorization(key *ecdsa.PrivateKey, endpoint string) (string, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	contact := "mailto:pogo@localhost"
+	if SMTPFrom != "" {
+		contact = "mailto:" + SMTPFrom
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": parsed.Scheme + "://" + parsed.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": contact,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	//JWTs want the two halves of the signature as fixed size numbers, one after the other
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return "vapid t=" + unsigned + "." + base64.RawURLEncoding.EncodeToString(signature) + ", k=" + encodePublicKey(key), nil
+}
+
+//Sends a notification to a browser. Also says whether the browser has gone away for good (it
+//unsubscribed, or the push service forgot about it), in which case it should be removed
+func sendPush(subscription PushSubscription, payload []byte) (bool, error) {
+	key := pushKey()
+	if key == nil {
+		return false, errors.New("push notifications aren't available")
+	}
+	body, err := encryptPush(subscription, payload)
+	if err != nil {
+		return false, err
+	}
+	authorization, err := vapidAuthorization(key, subscription.Endpoint)
+	if err != nil {
+		return false, err
+	}
+	request, err := http.NewRequest("POST", subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Authorization", authorization)
+	request.Header.Set("Content-Encoding", "aes128gcm")
+	request.Header.Set("Content-Type", "application/octet-stream")
+	request.Header.Set("TTL", strconv.Itoa(int(pushTTL.Seconds())))
+	resp, err := pushClient.Do(request)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	gone := resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone
+	return gone, fmt.Errorf("unexpected status %s", resp.Status)
+}
+
+//What the browser is sent. The page is relative to Pogo's home page
+type pushMessage struct {
+	Title string
+	Body  string
+	Page  string
+}
+
+//Sends notifications to the browsers of people who want to hear about an event
+type pushNotifier struct{}
+
+func (pushNotifier) Notify(event catcher.Event) {
+	if !catcher.Notifiable(event.Type) {
+		return
+	}
+	for _, user := range subscribersOf(event.FeedURL) {
+		if contains(user.Settings.NotifyEvents, event.Type) {
+			pushToUser(user, event)
+		}
+	}
+}
+
+//Sends a notification about an event to every browser someone has subscribed
+func pushToUser(user User, event catcher.Event) {
+	title, body := describeEvent(userLocale(user), event)
+	payload, err := json.Marshal(pushMessage{Title: title, Body: body, Page: eventPage(event)})
+	if err != nil {
+		return
+	}
+	for _, subscription := range user.PushSubscriptions {
+		gone, err := sendPush(subscription, payload)
+		if gone {
+			fmt.Println("Removing push subscription for", user.Name, err)
+			Users.RemovePushSubscription(user.Name, subscription.Endpoint)
+		} else if err != nil {
+			fmt.Println("Error sending push notification to", user.Name, err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"github.com/programmingthomas/Pogo/catcher"
+	"net/http"
+	"time"
+)
+
+//The refresh intervals that can be picked on the podcast page
+var refreshChoices = []time.Duration{0, 15 * time.Minute, 30 * time.Minute, time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour, 7 * 24 * time.Hour}
+
+//Works out the catcher's schedule from the config. Windows that can't be understood are left
+//out rather than stopping Pogo from starting
+func schedule() catcher.Schedule {
+	quietHours, err := catcher.ParseWindows(QuietHours)
+	if err != nil {
+		fmt.Println("Not using quiet hours:", err)
+	}
+	downloadWindows, err := catcher.ParseWindows(DownloadWindows)
+	if err != nil {
+		fmt.Println("Not using download windows:", err)
+	}
+	return catcher.Schedule{
+		RefreshInterval: RefreshInterval,
+		QuietHours:      quietHours,
+		DownloadWindows: downloadWindows,
+		DownloadCap:     int64(DownloadCap) << 20,
+	}
+}
+
+//A refresh interval for the podcast page's menu
+type refreshChoice struct {
+	Value    string
+	Interval time.Duration
+	Selected bool
+}
+
+//Gets the refresh intervals that can be picked for a podcast
+func (view podcastView) RefreshChoices() []refreshChoice {
+	intervals := refreshChoices
+	found := false
+	for _, interval := range intervals {
+		found = found || interval == view.RefreshInterval
+	}
+	if !found {
+		//It was set to something else with the API
+		intervals = append(intervals[:len(intervals):len(intervals)], view.RefreshInterval)
+	}
+	choices := make([]refreshChoice, len(intervals))
+	for i, interval := range intervals {
+		choices[i] = refreshChoice{Value: interval.String(), Interval: interval, Selected: interval == view.RefreshInterval}
+		if interval == 0 {
+			choices[i].Value = "auto"
+		}
+	}
+	return choices
+}
+
+//How a podcast is refreshed
+type refreshState struct {
+	Feed          string
+	Interval      float64
+	Automatic     bool
+	LastChecked   time.Time
+	LastRefreshed time.Time
+}
+
+//Gets or changes how often a podcast is refreshed. interval is either auto (to work it out from
+//how often the podcast publishes episodes) or a duration like 6h
+func refreshHandler(w http.ResponseWriter, r *http.Request) {
+	feedURL := r.FormValue("feed")
+	if r.Method == "POST" {
+		var interval time.Duration
+		if value := r.FormValue("interval"); value != "auto" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed < time.Minute {
+				http.Error(w, "Invalid interval", http.StatusBadRequest)
+				return
+			}
+			interval = parsed
+		}
+		if err := PodCatcher.SetRefreshInterval(feedURL, interval); err != nil {
+			if err == catcher.ErrNoPodcast {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+	}
+	podcast, ok := PodCatcher.Podcast(feedURL)
+	if !ok {
+		http.Error(w, catcher.ErrNoPodcast.Error(), http.StatusNotFound)
+		return
+	}
+	respond(w, r, refreshState{
+		Feed:          podcast.FeedURL,
+		Interval:      PodCatcher.RefreshIntervalFor(podcast).Seconds(),
+		Automatic:     podcast.RefreshInterval == 0,
+		LastChecked:   podcast.LastChecked,
+		LastRefreshed: podcast.LastRefreshed,
+	})
+}
@@ -2,13 +2,19 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/programmingthomas/Pogo/catcher"
 	"github.com/programmingthomas/Pogo/pogoutils"
 	"html/template"
+	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"path"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -17,40 +23,99 @@ type Page struct {
 	URL     string
 	Content template.HTML
 	Title   string
+	User    string
+	CSRF    string
+	Admin   bool
+	Theme   string
+	Offline bool
+	Locale  *Locale
+	//How many episodes are in the user's inbox, for the badge next to it
+	Inbox int
 }
 
 type Podcast struct {
 	Name string
 }
 
-var templates = template.Must(template.ParseFiles("server/templates/index.html", "server/templates/welcome.html", "server/templates/about.html", "server/templates/addfeed.html", "server/templates/podcast.html", "server/templates/episode.html"))
-var PodCatcher catcher.Catcher
+//The podcasts and queue shown on the homepage
+type homePage struct {
+	Folders []podcastFolder
+	Queue   []catcher.PodEpisode
+	//What the podcasts can be browsed by
+	Filter     subscriptionFilter
+	Categories []filterChoice
+	Tags       []filterChoice
+}
+
+var PodCatcher *catcher.Catcher
+
+//Creates a page for the given request, filling in the details of who is logged in. Links
+//are built from the path the browser used so that Pogo works behind a reverse proxy
+func newPage(r *http.Request, title string) Page {
+	auth := authFor(r)
+	user, _ := Users.Find(auth.User)
+	locale := localeFor(r)
+	page := Page{URL: basePath(r), Title: locale.T(title), User: auth.User, CSRF: auth.CSRF, Admin: user.Admin, Theme: themeFor(r), Offline: PodCatcher.Offline(), Locale: locale}
+	if auth.User != "" {
+		page.Inbox = len(inboxEpisodes(user, ""))
+	}
+	return page
+}
 
-//Handles the CSS, JS and Bootstrap resources
+//When the server started, which is used as the modification time for embedded resources
+var started = time.Now()
+
+//Handles the CSS, JS and Bootstrap resources from the default theme
 func resHandler(w http.ResponseWriter, r *http.Request) {
 	_, filename := path.Split(r.URL.Path)
-	fullPath := "server/res/" + filename
-	fileExists := pogoutils.FileExists(fullPath)
-	if fileExists {
-		lastModTime := pogoutils.LastMod(fullPath)
+	serveResource(w, r, DefaultTheme, filename)
+}
+
+//Handles resources for a particular theme, e.g. /theme/dark/css/css.css
+func themeResHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/theme/"), "/")
+	if len(parts) < 2 || !themeExists(parts[0]) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	serveResource(w, r, parts[0], parts[len(parts)-1])
+}
+
+//Serves one of a theme's resources
+func serveResource(w http.ResponseWriter, r *http.Request, theme, filename string) {
+	fullPath := "res/" + filename
+	file, err := themeFS(theme).Open(fullPath)
+	if err == nil {
+		defer file.Close()
+		info, err := file.Stat()
+		content, seekable := file.(io.ReadSeeker)
+		if err != nil || info.IsDir() || !seekable {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		lastModTime := info.ModTime()
+		//Files built into the binary don't have a modification time
+		if lastModTime.IsZero() {
+			lastModTime = started
+		}
 		//This checks whether or not the Header was submitted with
 		//If-Modified-Since, which reduces server IO, only do if Cache is enabled
 		if r.Header["If-Modified-Since"] != nil && Cache {
 			//RFC1123 is the standard date format used with HTTP
 			headerTime, _ := time.Parse(time.RFC1123, r.Header["If-Modified-Since"][0])
-			if !headerTime.Before(lastModTime) {
+			if !headerTime.Before(lastModTime.Truncate(time.Second)) {
 				w.WriteHeader(http.StatusNotModified)
 				return
 			}
 		}
 		//Writer the header and content
 		if Cache {
-			w.Header().Add("Last-Modified", lastModTime.Format(time.RFC1123))
+			w.Header().Add("Last-Modified", lastModTime.UTC().Format(http.TimeFormat))
 		}
-		//Go has a function for serving files easily
+		//Go has a function for serving content easily
 		//I used this function because it reduces the complexity of the code
 		//And it seems to do a good job handling MIME types
-		http.ServeFile(w, r, fullPath)
+		http.ServeContent(w, r, filename, time.Time{}, content)
 	} else {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Println(fullPath, "not found")
@@ -59,20 +124,31 @@ func resHandler(w http.ResponseWriter, r *http.Request) {
 
 //Serves the homepage
 func homeHandler(w http.ResponseWriter, r *http.Request) {
-	page := Page{URL: fmt.Sprintf("%s:%d", Path, Port), Title: "Pogo"}
+	page := newPage(r, "Pogo")
 	content := bytes.NewBufferString("")
-	//Update podcast list
-	PodCatcher.UpdateAll()
-	templates.ExecuteTemplate(content, "welcome.html", PodCatcher.Podcasts)
+	user, _ := Users.Find(authFor(r).User)
+	podcasts := subscribedPodcasts(user)
+	data := homePage{Queue: queuedEpisodes(user), Filter: parseSubscriptionFilter(r)}
+	data.Categories = categoryChoices(podcasts, data.Filter.Category)
+	data.Tags = tagChoices(user, podcasts, data.Filter.Tag)
+	shown := make([]catcher.PodFeed, 0, len(podcasts))
+	for _, podcast := range podcasts {
+		if data.Filter.matches(user, podcast) {
+			podcast.Image = imageURL(r, podcast.Image, 150)
+			shown = append(shown, podcast)
+		}
+	}
+	data.Folders = organise(user, shown)
+	pageTemplates(r).ExecuteTemplate(content, "welcome.html", data)
 	page.Content = template.HTML(content.String())
 	pageHandler(page, "index.html", w)
 }
 
 //Serves the really exciting about page
 func aboutHandler(w http.ResponseWriter, r *http.Request) {
-	page := Page{URL: fmt.Sprintf("%s:%d", Path, Port), Title: "About - Pogo"}
+	page := newPage(r, "About - Pogo")
 	content := bytes.NewBufferString("")
-	templates.ExecuteTemplate(content, "about.html", nil)
+	pageTemplates(r).ExecuteTemplate(content, "about.html", nil)
 	page.Content = template.HTML(content.String())
 	pageHandler(page, "index.html", w)
 }
@@ -81,19 +157,21 @@ func aboutHandler(w http.ResponseWriter, r *http.Request) {
 //podcast in the 'feedurl' parameter
 func addPodcastHandler(w http.ResponseWriter, r *http.Request) {
 	//I.e. add a podcast feed
-	if r.Method == "POST" {
+	if r.Method == "POST" && !PodCatcher.Offline() {
 		if r.FormValue("feedurl") != "" {
 			//Check if the URL is a valid URL
 			feedURL, err := url.Parse(r.FormValue("feedurl"))
 			if err == nil {
+				//The feed is only fetched once however many people subscribe to it
+				Users.Subscribe(authFor(r).User, feedURL.String())
 				//Concurrent???
 				go PodCatcher.AddPodcastFeed(feedURL.String())
 			}
 		}
 	}
-	page := Page{URL: fmt.Sprintf("%s:%d", Path, Port), Title: "Add podcast - Pogo"}
+	page := newPage(r, "Add podcast - Pogo")
 	content := bytes.NewBufferString("")
-	templates.ExecuteTemplate(content, "addfeed.html", nil)
+	pageTemplates(r).ExecuteTemplate(content, "addfeed.html", page)
 	page.Content = template.HTML(content.String())
 	pageHandler(page, "index.html", w)
 }
@@ -101,28 +179,50 @@ func addPodcastHandler(w http.ResponseWriter, r *http.Request) {
 //Generic page handler contains the main template
 func pageHandler(page Page, template string, w http.ResponseWriter) {
 	w.Header().Add("Content-Type", "text/html; charset=utf-8")
-	err := templates.ExecuteTemplate(w, template, page)
+	err := themeTemplates(page.Theme, page.Locale).ExecuteTemplate(w, template, page)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
-//Allows you to download the configuration file in case you wanted to build something on
-//top of Pogo (like a mobile app)
+//The current user's podcasts and progress, as served by /pogo.json
+type userConfig struct {
+	Podcasts []catcher.PodFeed
+	Episodes map[string]EpisodeState
+	Queue    []string
+	Settings UserSettings
+	Labels   map[string]FeedLabels
+}
+
+//Allows you to download the configuration in case you wanted to build something on top of
+//Pogo (like a mobile app). Only includes the podcasts the current user is subscribed to
 func pogoConfigHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "pogoconfig.json")
+	user, _ := Users.Find(authFor(r).User)
+	writeJSON(w, userConfig{Podcasts: subscribedPodcasts(user), Episodes: user.Episodes, Queue: user.Queue, Settings: user.Settings, Labels: user.Labels})
 }
 
 //Serves up a page with info for a certain podcast
 func podcastHandler(w http.ResponseWriter, r *http.Request) {
 	base := path.Base(r.URL.Path)
 	if base != "podcasts" {
-		for _, podcast := range PodCatcher.Podcasts {
+		for _, podcast := range PodCatcher.AllPodcasts() {
 			if podcast.ID == base {
-				page := Page{URL: fmt.Sprintf("%s:%d", Path, Port), Title: podcast.Name + " - Pogo"}
+				page := newPage(r, podcast.Name+" - Pogo")
+				user, _ := Users.Find(page.User)
+				query, err := parseEpisodeQuery(r, user)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				view := podcastView{PodFeed: podcast, Subscribed: user.IsSubscribed(podcast.FeedURL), CSRF: page.CSRF, Admin: page.Admin}
+				view.Labels = user.LabelsFor(podcast.FeedURL)
+				view.Folders = user.Folders()
+				view.RefreshEvery = PodCatcher.RefreshIntervalFor(podcast)
+				view.Image = imageURL(r, podcast.Image, 300)
+				view.episodePage = query.apply(user, podcast, page.CSRF)
 				content := bytes.NewBufferString("")
-				templates.ExecuteTemplate(content, "podcast.html", podcast)
+				pageTemplates(r).ExecuteTemplate(content, "podcast.html", view)
 				page.Content = template.HTML(content.String())
 				pageHandler(page, "index.html", w)
 				break
@@ -133,14 +233,18 @@ func podcastHandler(w http.ResponseWriter, r *http.Request) {
 
 //Serves up a page with the info for an individual podcast
 func episodeHandler(w http.ResponseWriter, r *http.Request) {
-	PodCatcher.UpdateAll()
 	if r.FormValue("episode") != "" {
-		for _, podcast := range PodCatcher.Podcasts {
+		for _, podcast := range PodCatcher.AllPodcasts() {
 			for _, episode := range podcast.PodcastEpisodes {
 				if episode.URL == r.FormValue("episode") {
-					page := Page{URL: fmt.Sprintf("%s:%d", Path, Port), Title: episode.Title + " - Pogo"}
+					page := newPage(r, episode.Title+" - Pogo")
+					user, _ := Users.Find(page.User)
+					episode.Chapters = localChapterImages(r, PodCatcher.Chapters(episode.URL))
+					episode.Image = episodeImage(r, podcast, episode, 300)
 					content := bytes.NewBufferString("")
-					templates.ExecuteTemplate(content, "episode.html", episode)
+					view := viewEpisode(user, episode, page.CSRF)
+					view.Transcript = PodCatcher.Transcript(episode.URL)
+					pageTemplates(r).ExecuteTemplate(content, "episode.html", view)
 					page.Content = template.HTML(content.String())
 					pageHandler(page, "index.html", w)
 					return
@@ -154,6 +258,8 @@ func episodeHandler(w http.ResponseWriter, r *http.Request) {
 func downloadHandler(w http.ResponseWriter, r *http.Request) {
 	_, filename := path.Split(r.URL.Path)
 	if pogoutils.FileExists("downloads/" + filename) {
+		//Episodes are big, so they're allowed to take longer than the usual write timeout
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
 		fmt.Println("Serving downloads/", filename)
 		http.ServeFile(w, r, "downloads/"+filename)
 	}
@@ -162,19 +268,127 @@ func downloadHandler(w http.ResponseWriter, r *http.Request) {
 //Start the Pogo server
 func Start() {
 	fmt.Println("Starting Pogo server")
-	PodCatcher = catcher.StartCatcher("pogoconfig.json")
-	http.HandleFunc("/js/", resHandler)
-	http.HandleFunc("/css/", resHandler)
-	http.HandleFunc("/res/", resHandler)
-	http.HandleFunc("/img/", resHandler)
-	http.HandleFunc("/", homeHandler)
-	http.HandleFunc("/home", homeHandler)
-	http.HandleFunc("/index", homeHandler)
-	http.HandleFunc("/episode/", episodeHandler)
-	http.HandleFunc("/downloads/", downloadHandler)
-	http.HandleFunc("/podcasts/add", addPodcastHandler)
-	http.HandleFunc("/pogo.json", pogoConfigHandler)
-	http.HandleFunc("/podcast/", podcastHandler)
-	http.HandleFunc("/about", aboutHandler)
-	http.ListenAndServe(fmt.Sprintf(":%d", Port), nil)
+	if err := checkOverrides(); err != nil {
+		fmt.Println("Not using overrides:", err)
+		Overrides = ""
+	}
+	if !themeExists(DefaultTheme) {
+		fmt.Println("No theme called", DefaultTheme, "so using the default theme")
+		DefaultTheme = "default"
+	}
+	//Parse the templates now so that a broken template stops Pogo from starting
+	for _, theme := range Themes() {
+		themeTemplates(theme, findLocale(defaultLocale))
+	}
+	if URLPrefix != "" {
+		URLPrefix = "/" + strings.Trim(URLPrefix, "/")
+	}
+	users, err := LoadUsers(UsersLocation)
+	if err != nil {
+		fmt.Println("Not starting, as the user accounts can't be loaded:", err)
+		os.Exit(1)
+	}
+	Users = users
+	PodCatcher = catcher.StartCatcher(ConfigLocation, Offline, schedule())
+	feedURLs := make([]string, 0)
+	for _, podcast := range PodCatcher.AllPodcasts() {
+		feedURLs = append(feedURLs, podcast.FeedURL)
+	}
+	Users.MigrateSubscriptions(feedURLs)
+	if key, err := loadPushKeys(PushKeysLocation); err != nil {
+		fmt.Println("Not sending push notifications:", err)
+	} else {
+		setPushKey(key)
+	}
+	PodCatcher.AddNotifier(webhookNotifier{})
+	PodCatcher.AddNotifier(pushNotifier{})
+	PodCatcher.AddNotifier(liveEvents)
+	if SMTPServer != "" {
+		if SMTPFrom == "" {
+			SMTPFrom = "pogo@localhost"
+		}
+		PodCatcher.AddNotifier(emailNotifier{})
+	}
+	http.HandleFunc("/js/", instrument("res", resHandler))
+	http.HandleFunc("/css/", instrument("res", resHandler))
+	http.HandleFunc("/res/", instrument("res", resHandler))
+	http.HandleFunc("/img/", instrument("res", resHandler))
+	http.HandleFunc("/theme/", instrument("res", themeResHandler))
+	http.HandleFunc("/", instrument("home", requireLogin(homeHandler)))
+	http.HandleFunc("/home", instrument("home", requireLogin(homeHandler)))
+	http.HandleFunc("/index", instrument("home", requireLogin(homeHandler)))
+	http.HandleFunc("/episode/", instrument("episode", requireLogin(episodeHandler)))
+	http.HandleFunc("/downloads/", instrument("downloads", requireLogin(downloadHandler)))
+	http.HandleFunc("/artwork/", instrument("artwork", requireLogin(artworkHandler)))
+	http.HandleFunc("/image/", instrument("image", requireLogin(imageHandler)))
+	http.HandleFunc("/offline", instrument("offline", requireAdmin(offlineHandler)))
+	http.HandleFunc("/api/refresh", instrument("refresh", requireAdmin(refreshHandler)))
+	http.HandleFunc("/api/limits", instrument("limits", requireAdmin(downloadLimitsHandler)))
+	http.HandleFunc("/podcasts/add", instrument("addpodcast", requireLogin(addPodcastHandler)))
+	http.HandleFunc("/pogo.json", instrument("pogoconfig", requireLogin(pogoConfigHandler)))
+	http.HandleFunc("/podcast/", instrument("podcast", requireLogin(podcastHandler)))
+	http.HandleFunc("/about", instrument("about", requireLogin(aboutHandler)))
+	http.HandleFunc("/metrics", instrument("metrics", requireLogin(metricsHandler)))
+	http.HandleFunc("/login", instrument("login", loginHandler))
+	http.HandleFunc("/logout", instrument("logout", requireLogin(logoutHandler)))
+	http.HandleFunc("/setup", instrument("setup", setupHandler))
+	http.HandleFunc("/account", instrument("account", requireLogin(accountHandler)))
+	http.HandleFunc("/users", instrument("users", requireAdmin(usersHandler)))
+	http.HandleFunc("/settings", instrument("settings", requireLogin(settingsHandler)))
+	http.HandleFunc("/api/subscriptions", instrument("subscriptions", requireLogin(subscriptionsHandler)))
+	http.HandleFunc("/api/progress", instrument("progress", requireLogin(progressHandler)))
+	http.HandleFunc("/api/queue", instrument("queue", requireLogin(queueHandler)))
+	http.HandleFunc("/api/player", instrument("player", requireLogin(playerHandler)))
+	http.HandleFunc("/api/chapters", instrument("chapters", requireLogin(chaptersHandler)))
+	http.HandleFunc("/api/transcript", instrument("transcript", requireLogin(transcriptHandler)))
+	http.HandleFunc("/api/episodes", instrument("episodes", requireLogin(episodesHandler)))
+	http.HandleFunc("/api/inbox", instrument("inbox", requireLogin(inboxAPIHandler)))
+	http.HandleFunc("/api/playlists", instrument("playlists", requireLogin(playlistsAPIHandler)))
+	http.HandleFunc("/api/notifications", instrument("notifications", requireLogin(notificationsHandler)))
+	http.HandleFunc("/api/webhooks", instrument("webhooks", requireLogin(webhooksHandler)))
+	http.HandleFunc("/api/events", instrument("events", requireLogin(eventsHandler)))
+	http.HandleFunc("/api/backup", instrument("backup", requireAdmin(backupHandler)))
+	http.HandleFunc("/search", instrument("search", requireLogin(searchHandler)))
+	http.HandleFunc("/inbox", instrument("inbox", requireLogin(inboxHandler)))
+	http.HandleFunc("/playlists", instrument("playlists", requireLogin(playlistsHandler)))
+	http.HandleFunc("/playlist/", instrument("playlist", requireLogin(playlistHandler)))
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", Port),
+		Handler:           withPrefix(http.DefaultServeMux),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		WriteTimeout:      time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+	server.RegisterOnShutdown(liveEvents.close)
+	//Shut down cleanly on Ctrl+C (or when asked to by the OS)
+	shutdown := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		fmt.Println("Shutting down Pogo server")
+		ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		server.Shutdown(ctx)
+		PodCatcher.Stop(ShutdownTimeout)
+		if err := Users.Flush(); err != nil {
+			fmt.Println("Error saving users", err)
+		}
+		close(shutdown)
+	}()
+	if CertFile != "" && KeyFile != "" {
+		fmt.Println("Serving HTTPS on", server.Addr+URLPrefix)
+		err = server.ListenAndServeTLS(CertFile, KeyFile)
+	} else {
+		fmt.Println("Serving HTTP on", server.Addr+URLPrefix)
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		fmt.Println("Error starting server", err)
+		PodCatcher.Stop(0)
+		return
+	}
+	<-shutdown
+	fmt.Println("Pogo server stopped")
 }
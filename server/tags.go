@@ -0,0 +1,189 @@
+package server
+
+import (
+	"github.com/programmingthomas/Pogo/catcher"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+//How a user has organised one of their subscriptions. Everybody organises their own
+//subscriptions, so these are kept with the user rather than the podcast
+type FeedLabels struct {
+	Folder string
+	Tags   []string
+}
+
+//Gets the folder and tags a user has given a subscription
+func (user User) LabelsFor(feedURL string) FeedLabels {
+	labels := user.Labels[feedURL]
+	labels.Tags = append([]string{}, labels.Tags...)
+	return labels
+}
+
+//Whether or not a subscription has a tag. Tags aren't case sensitive
+func (labels FeedLabels) HasTag(tag string) bool {
+	for _, t := range labels.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+//Gets the tags as they're typed into the form, separated by commas
+func (labels FeedLabels) TagText() string {
+	return strings.Join(labels.Tags, ", ")
+}
+
+//Reads a folder and some tags (separated by commas) as they're typed in. Extra spaces are
+//tidied up, and tags that are only different in case are only kept once
+func parseLabels(folder, tags string) FeedLabels {
+	labels := FeedLabels{Folder: oneLine(folder), Tags: make([]string, 0)}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = oneLine(tag); tag != "" && !labels.HasTag(tag) {
+			labels.Tags = append(labels.Tags, tag)
+		}
+	}
+	sort.Slice(labels.Tags, func(i, j int) bool {
+		return strings.ToLower(labels.Tags[i]) < strings.ToLower(labels.Tags[j])
+	})
+	return labels
+}
+
+//Which subscriptions to show. These come from the tag, category and folder parameters, and
+//empty ones match everything
+type subscriptionFilter struct {
+	Tag      string
+	Category string
+	Folder   string
+}
+
+//Reads a subscription filter from a request
+func parseSubscriptionFilter(r *http.Request) subscriptionFilter {
+	return subscriptionFilter{Tag: r.FormValue("tag"), Category: r.FormValue("category"), Folder: r.FormValue("folder")}
+}
+
+//Whether or not anything is being filtered out
+func (filter subscriptionFilter) Active() bool {
+	return filter.Tag != "" || filter.Category != "" || filter.Folder != ""
+}
+
+//Whether or not one of a user's podcasts should be shown
+func (filter subscriptionFilter) matches(user User, podcast catcher.PodFeed) bool {
+	labels := user.LabelsFor(podcast.FeedURL)
+	if filter.Tag != "" && !labels.HasTag(filter.Tag) {
+		return false
+	}
+	if filter.Folder != "" && !strings.EqualFold(labels.Folder, filter.Folder) {
+		return false
+	}
+	return filter.Category == "" || inCategory(podcast, []string{filter.Category})
+}
+
+//Gets the categories of some podcasts, including the top level category of subcategories so
+//that a whole category can be picked
+func podcastCategories(podcasts []catcher.PodFeed) []string {
+	categories := make([]string, 0)
+	for _, podcast := range podcasts {
+		for _, category := range podcast.Categories {
+			for _, name := range []string{strings.SplitN(category, "/", 2)[0], category} {
+				if !contains(categories, name) {
+					categories = append(categories, name)
+				}
+			}
+		}
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+//Gets the folders a user has put their subscriptions in
+func (user User) Folders() []string {
+	folders := make([]string, 0)
+	for _, labels := range user.Labels {
+		if labels.Folder != "" && !contains(folders, labels.Folder) {
+			folders = append(folders, labels.Folder)
+		}
+	}
+	sort.Strings(folders)
+	return folders
+}
+
+//A category or tag that the home page can be filtered by, along with how many podcasts
+//it would show
+type filterChoice struct {
+	Name     string
+	Count    int
+	Selected bool
+}
+
+//Gets the categories that a user's podcasts can be browsed by
+func categoryChoices(podcasts []catcher.PodFeed, selected string) []filterChoice {
+	choices := make([]filterChoice, 0)
+	for _, category := range podcastCategories(podcasts) {
+		choice := filterChoice{Name: category, Selected: strings.EqualFold(category, selected)}
+		for _, podcast := range podcasts {
+			if inCategory(podcast, []string{category}) {
+				choice.Count++
+			}
+		}
+		choices = append(choices, choice)
+	}
+	return choices
+}
+
+//Gets the tags that a user's podcasts can be browsed by
+func tagChoices(user User, podcasts []catcher.PodFeed, selected string) []filterChoice {
+	choices := make([]filterChoice, 0)
+	counts := make(map[string]int)
+	for _, podcast := range podcasts {
+		for _, tag := range user.LabelsFor(podcast.FeedURL).Tags {
+			key := strings.ToLower(tag)
+			if _, ok := counts[key]; !ok {
+				choices = append(choices, filterChoice{Name: tag, Selected: strings.EqualFold(tag, selected)})
+			}
+			counts[key]++
+		}
+	}
+	for i := range choices {
+		choices[i].Count = counts[strings.ToLower(choices[i].Name)]
+	}
+	sort.Slice(choices, func(i, j int) bool {
+		return strings.ToLower(choices[i].Name) < strings.ToLower(choices[j].Name)
+	})
+	return choices
+}
+
+//A subscription along with how the user has organised it
+type subscriptionView struct {
+	catcher.PodFeed
+	Labels FeedLabels
+}
+
+//Some of the podcasts on the home page. Podcasts that aren't in a folder are in the one
+//with no name
+type podcastFolder struct {
+	Name     string
+	Podcasts []subscriptionView
+}
+
+//Puts a user's podcasts into their folders, with the podcasts that aren't in one first
+func organise(user User, podcasts []catcher.PodFeed) []podcastFolder {
+	folders := make([]podcastFolder, 0)
+	indexes := make(map[string]int)
+	for _, podcast := range podcasts {
+		labels := user.LabelsFor(podcast.FeedURL)
+		index, ok := indexes[labels.Folder]
+		if !ok {
+			index = len(folders)
+			indexes[labels.Folder] = index
+			folders = append(folders, podcastFolder{Name: labels.Folder})
+		}
+		folders[index].Podcasts = append(folders[index].Podcasts, subscriptionView{PodFeed: podcast, Labels: labels})
+	}
+	sort.SliceStable(folders, func(i, j int) bool {
+		return folders[i].Name < folders[j].Name
+	})
+	return folders
+}
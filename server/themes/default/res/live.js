@@ -0,0 +1,139 @@
+//Keeps pages up to date while they're open using the stream of events from /api/events: how
+//far a refresh has got, how downloads are getting on and new episodes as they arrive
+(function($) {
+	var strings, refresh = null, downloads = {}, feedError = null, feedErrorTimer;
+
+	//Fills in the %d and %s of a translated string
+	var format = function(text) {
+		var args = Array.prototype.slice.call(arguments, 1);
+		return text.replace(/%[ds]/g, function() {
+			return args.length ? args.shift() : "";
+		});
+	};
+
+	var progressBar = function(percent) {
+		return $("<div class=\"progress progress-striped active\"><div class=\"bar\"></div></div>").find(".bar").css("width", percent + "%").end();
+	};
+
+	//Everything on the page showing a download, like <td data-download="episode url">
+	var downloadElements = function(episodeURL) {
+		return $("[data-download]").filter(function() {
+			return $(this).attr("data-download") === episodeURL;
+		});
+	};
+
+	var renderDownload = function(event) {
+		var elements = downloadElements(event.EpisodeURL);
+		switch (event.Type) {
+		case "download.start":
+		case "download.progress":
+			var text = event.Total ? (event.Percent || 0) + "%" : strings.downloading;
+			elements.empty().append(progressBar(event.Total ? event.Percent || 0 : 100), $("<span>").text(text));
+			break;
+		case "download.complete":
+			elements.each(function() {
+				$(this).text($(this).data("done") || strings.downloaded);
+			});
+			break;
+		case "download.failed":
+			elements.text(strings.failed).attr("title", event.Error || "");
+			break;
+		case "download.deferred":
+			elements.text(strings.deferred);
+			break;
+		}
+	};
+
+	//The bar above the page saying what Pogo is up to
+	var renderStatus = function() {
+		var live = $("#live").empty();
+		if (refresh) {
+			var text = refresh.Podcast ? format(strings.refreshingPodcast, refresh.Podcast, (refresh.Done || 0) + 1, refresh.Total) : format(strings.refreshing, Math.min((refresh.Done || 0) + 1, refresh.Total), refresh.Total);
+			live.append($("<div>").text(text), progressBar(refresh.Percent || 0));
+		}
+		var count = Object.keys(downloads).length;
+		if (count > 0) {
+			live.append($("<div>").text(format(strings.downloads, count)));
+		}
+		if (feedError) {
+			live.append($("<div class=\"text-error\">").text(format(strings.feedError, feedError.Podcast)).attr("title", feedError.Error));
+		}
+		live.toggle(live.children().length > 0);
+	};
+
+	var newEpisode = function(event) {
+		var badge = $("#inbox-count");
+		badge.text(Number(badge.text() || 0) + 1).show();
+		$(".live-new").filter(function() {
+			var feed = $(this).attr("data-feed");
+			return !feed || feed === event.FeedURL;
+		}).show().find("a").attr("href", window.location.href);
+		$(".podcast[data-feed]").filter(function() {
+			return $(this).attr("data-feed") === event.FeedURL;
+		}).find(".live-badge").show();
+	};
+
+	var handle = function(event) {
+		switch (event.Type) {
+		case "refresh.start":
+		case "refresh.progress":
+			refresh = event;
+			break;
+		case "refresh.complete":
+			refresh = null;
+			break;
+		case "download.start":
+		case "download.progress":
+			downloads[event.EpisodeURL] = event;
+			renderDownload(event);
+			break;
+		case "download.complete":
+		case "download.failed":
+		case "download.deferred":
+			delete downloads[event.EpisodeURL];
+			renderDownload(event);
+			break;
+		case "episode.new":
+			newEpisode(event);
+			break;
+		case "feed.error":
+			feedError = event;
+			clearTimeout(feedErrorTimer);
+			feedErrorTimer = setTimeout(function() {
+				feedError = null;
+				renderStatus();
+			}, 15000);
+			break;
+		}
+		renderStatus();
+	};
+
+	$(function() {
+		if (!window.EventSource || !document.getElementById("live")) {
+			return;
+		}
+		//Translated by the page
+		strings = $("#live").data();
+		var source = new EventSource(($("body").data("base") || "") + "/api/events");
+		//Anything that happened while the stream was down is missed, but the stream starts by
+		//saying what's going on now
+		source.addEventListener("open", function() {
+			refresh = null;
+			downloads = {};
+			renderStatus();
+		});
+		$.each(["refresh.start", "refresh.progress", "refresh.complete", "download.start", "download.progress", "download.complete", "download.failed", "download.deferred", "episode.new", "feed.error"], function(i, type) {
+			source.addEventListener(type, function(e) {
+				handle(JSON.parse(e.data));
+			});
+		});
+		//Pages are loaded without reloading (see player.js), so show downloads on new ones
+		if (window.MutationObserver) {
+			new MutationObserver(function() {
+				$.each(downloads, function(url, event) {
+					renderDownload(event);
+				});
+			}).observe($(".content")[0], {childList: true});
+		}
+	});
+})(jQuery);
@@ -0,0 +1,62 @@
+//Turns on push notifications in this browser from the settings page. The service worker
+//shows them even when Pogo isn't open
+(function($) {
+	var base, csrf;
+
+	//Browsers want the key as bytes
+	var keyBytes = function(key) {
+		var padded = (key + "===".slice((key.length + 3) % 4)).replace(/-/g, "+").replace(/_/g, "/");
+		var raw = window.atob(padded), bytes = new Uint8Array(raw.length);
+		for (var i = 0; i < raw.length; i++) {
+			bytes[i] = raw.charCodeAt(i);
+		}
+		return bytes;
+	};
+
+	var encodeKey = function(buffer) {
+		return window.btoa(String.fromCharCode.apply(null, new Uint8Array(buffer))).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+	};
+
+	var status = function(push, message) {
+		push.find(".push-status").text(push.data(message));
+	};
+
+	var enable = function(push) {
+		if (!("serviceWorker" in navigator) || !("PushManager" in window) || !window.Notification) {
+			status(push, "unsupported");
+			return;
+		}
+		Notification.requestPermission().then(function(permission) {
+			if (permission !== "granted") {
+				status(push, "denied");
+				return;
+			}
+			return navigator.serviceWorker.register(base + "/js/pushworker.js").then(function(registration) {
+				return registration.pushManager.getSubscription().then(function(existing) {
+					return existing || registration.pushManager.subscribe({userVisibleOnly: true, applicationServerKey: keyBytes(push.data("key"))});
+				});
+			}).then(function(subscription) {
+				$.ajax({
+					type: "POST",
+					url: base + "/api/notifications",
+					data: {action: "subscribe", endpoint: subscription.endpoint, p256dh: encodeKey(subscription.getKey("p256dh")), auth: encodeKey(subscription.getKey("auth"))},
+					dataType: "json",
+					headers: {"X-CSRF-Token": csrf},
+					success: function() {
+						status(push, "enabled");
+					}
+				});
+			});
+		}).catch(function() {
+			status(push, "unsupported");
+		});
+	};
+
+	$(function() {
+		base = $("body").data("base") || "";
+		csrf = $("body").data("csrf");
+		$(document).on("click", ".enable-push", function() {
+			enable($(this).closest(".push"));
+		});
+	});
+})(jQuery);
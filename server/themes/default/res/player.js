@@ -0,0 +1,447 @@
+//The player bar at the bottom of every page. The queue lives on the server so it follows you
+//between browsers, and pages are loaded into .content without reloading the whole page so
+//that whatever is playing carries on while you look around
+(function($) {
+	var base, csrf, media, sleepTimer, strings;
+	var sleepAtEnd = false;
+	var state = {NowPlaying: null, Queue: [], Speed: 1};
+	var skipBack = 15, skipForward = 30;
+
+	//Talks to Pogo's API. GET if there's no data, otherwise POST
+	var api = function(path, data, done) {
+		$.ajax({
+			type: data ? "POST" : "GET",
+			url: base + path,
+			data: data,
+			dataType: "json",
+			headers: {"X-CSRF-Token": csrf},
+			success: done
+		});
+	};
+
+	//13:37 or 1:02:03
+	var formatTime = function(seconds) {
+		seconds = Math.max(0, Math.floor(seconds || 0));
+		var h = Math.floor(seconds / 3600), m = Math.floor(seconds / 60) % 60, s = seconds % 60;
+		var mm = h > 0 && m < 10 ? "0" + m : "" + m;
+		return (h > 0 ? h + ":" : "") + mm + ":" + (s < 10 ? "0" + s : s);
+	};
+
+	var saveProgress = function() {
+		if (state.NowPlaying && media.currentTime > 0) {
+			api("/api/progress", {episode: state.NowPlaying.URL, position: media.currentTime});
+		}
+	};
+
+	var updateMediaSession = function(episode) {
+		if (!("mediaSession" in navigator) || !window.MediaMetadata) {
+			return;
+		}
+		navigator.mediaSession.metadata = new MediaMetadata({
+			title: episode.Title,
+			artist: episode.Podcast,
+			album: episode.Podcast,
+			artwork: episode.Image ? [{src: episode.Image}] : []
+		});
+	};
+
+	//Puts an episode into the media element, carrying on from where it was left off
+	var load = function(episode, autoplay) {
+		if (!episode) {
+			media.pause();
+			media.removeAttribute("src");
+			media.load();
+			render();
+			return;
+		}
+		if (!episode.Available) {
+			//Pogo is offline and this hasn't been downloaded
+			media.pause();
+			media.removeAttribute("data-episode");
+			media.removeAttribute("src");
+			media.load();
+			render();
+			$("#player-chapter").text(strings.unavailable);
+			return;
+		}
+		if (media.getAttribute("data-episode") !== episode.URL) {
+			media.setAttribute("data-episode", episode.URL);
+			media.src = episode.Source;
+			$(media).one("loadedmetadata", function() {
+				if (episode.Position > 0 && episode.Position < media.duration - 5) {
+					media.currentTime = episode.Position;
+				}
+				media.playbackRate = state.Speed;
+			});
+		}
+		media.playbackRate = state.Speed;
+		updateMediaSession(episode);
+		if (autoplay) {
+			var playing = media.play();
+			if (playing && playing.catch) {
+				//Browsers won't always let us start playing without a click
+				playing.catch(function() {});
+			}
+		}
+		render();
+	};
+
+	var setState = function(newState, autoplay) {
+		var changed = (state.NowPlaying && state.NowPlaying.URL) !== (newState.NowPlaying && newState.NowPlaying.URL);
+		state = newState;
+		if (changed || autoplay) {
+			load(state.NowPlaying, autoplay);
+		} else {
+			render();
+		}
+	};
+
+	//Starts playing an episode, optionally from a particular time (in seconds)
+	var play = function(episodeURL, startAt) {
+		if (startAt !== undefined && state.NowPlaying && state.NowPlaying.URL === episodeURL) {
+			media.currentTime = startAt;
+			load(state.NowPlaying, true);
+			return;
+		}
+		saveProgress();
+		api("/api/player", {action: "play", episode: episodeURL}, function(s) {
+			if (startAt !== undefined && s.NowPlaying) {
+				s.NowPlaying.Position = startAt;
+			}
+			setState(s, true);
+		});
+	};
+
+	//Gets the chapter that's playing at the moment
+	var currentChapter = function() {
+		var chapters = (state.NowPlaying && state.NowPlaying.Chapters) || [];
+		var current = null;
+		$.each(chapters, function(i, chapter) {
+			if (chapter.Start <= media.currentTime) {
+				current = chapter;
+			}
+		});
+		return current;
+	};
+
+	//Moves on to the next episode in the queue
+	var next = function(finished, autoplay) {
+		if (!finished) {
+			saveProgress();
+		}
+		api("/api/player", {action: "next", finished: finished ? "true" : "false"}, function(s) {
+			setState(s, autoplay);
+		});
+	};
+
+	var changeQueue = function(action, episodeURL, index) {
+		var data = {action: action, episode: episodeURL};
+		if (index !== undefined) {
+			data.index = index;
+		}
+		api("/api/queue", data, function(s) {
+			setState(s, false);
+		});
+	};
+
+	var togglePlaying = function() {
+		if (!state.NowPlaying) {
+			if (state.Queue.length > 0) {
+				next(false, true);
+			}
+			return;
+		}
+		if (media.paused) {
+			load(state.NowPlaying, true);
+		} else {
+			media.pause();
+		}
+	};
+
+	var skip = function(seconds) {
+		if (state.NowPlaying) {
+			media.currentTime = Math.max(0, Math.min(media.duration || 0, media.currentTime + seconds));
+		}
+	};
+
+	var setSleepTimer = function(minutes) {
+		clearTimeout(sleepTimer);
+		sleepAtEnd = minutes < 0;
+		if (minutes > 0) {
+			sleepTimer = setTimeout(function() {
+				media.pause();
+				$("#player-sleep").val("0");
+			}, minutes * 60000);
+		}
+	};
+
+	var render = function() {
+		var player = $("#player");
+		var episode = state.NowPlaying;
+		player.toggle(!!episode || state.Queue.length > 0);
+		$("body").toggleClass("with-player", !!episode || state.Queue.length > 0);
+		$("#player-title").text(episode ? episode.Title : strings.nothing).attr("href", episode ? episode.Page : "#");
+		$("#player-podcast").text(episode ? episode.Podcast : "");
+		$("#player-art").attr("src", episode && episode.Image ? episode.Image : "").toggle(!!(episode && episode.Image));
+		$(media).toggleClass("player-video", !!(episode && episode.Type.indexOf("video") === 0));
+		$("#player-toggle").text(media.paused ? strings.play : strings.pause);
+		$("#player-speed").val(String(state.Speed));
+		$("#player-queue-count").text(state.Queue.length);
+		var list = $("#player-queue").empty();
+		$.each(state.Queue, function(i, queued) {
+			var item = $("<li>").attr("data-episode", queued.URL).attr("data-index", i);
+			item.append($("<a>").attr("href", queued.Page).text(queued.Title));
+			item.append(" <small>" + $("<span>").text(queued.Podcast).html() + "</small> ");
+			item.append($("<button class=\"btn btn-mini\" data-queue=\"play\">").text(strings.play));
+			item.append($("<button class=\"btn btn-mini\" data-queue=\"up\">&uarr;</button>"));
+			item.append($("<button class=\"btn btn-mini\" data-queue=\"down\">&darr;</button>"));
+			item.append($("<button class=\"btn btn-mini\" data-queue=\"remove\">&times;</button>"));
+			list.append(item);
+		});
+		if (state.Queue.length === 0) {
+			list.append($("<li>").text(strings.empty));
+		}
+	};
+
+	var renderTime = function() {
+		$("#player-time").text(formatTime(media.currentTime));
+		$("#player-duration").text(formatTime(media.duration));
+		$("#player-seek").attr("max", Math.floor(media.duration || 0)).val(Math.floor(media.currentTime));
+		var chapter = currentChapter();
+		$("#player-chapter").text(chapter ? "\u2013 " + chapter.Title : "");
+		highlightCue();
+		if ("mediaSession" in navigator && navigator.mediaSession.setPositionState && media.duration) {
+			try {
+				navigator.mediaSession.setPositionState({duration: media.duration, playbackRate: media.playbackRate, position: media.currentTime});
+			} catch (e) {}
+		}
+	};
+
+	//Highlights whatever is being said in the transcript on the page (if there is one for the
+	//episode that's playing), scrolling it into view if it's changed
+	var highlightCue = function() {
+		if (!state.NowPlaying) {
+			return;
+		}
+		var transcript = $(".transcript").filter(function() {
+			return $(this).data("episode") === state.NowPlaying.URL;
+		});
+		var current = transcript.find(".cue").filter(function() {
+			var start = Number($(this).data("time")), end = Number($(this).data("end"));
+			return start <= media.currentTime && (media.currentTime < end || !(end > start));
+		}).last();
+		if (current.length === 0 || current.hasClass("current")) {
+			return;
+		}
+		transcript.find(".cue.current").removeClass("current");
+		current.addClass("current");
+		transcript.scrollTop(transcript.scrollTop() + current.position().top - transcript.height() / 2);
+	};
+
+	//Loads a page into .content. Anything that can't be loaded like this (e.g. after logging
+	//out, or changing theme) is loaded normally instead
+	var navigate = function(url, options, push) {
+		var request;
+		var makeRequest = function() {
+			request = new XMLHttpRequest();
+			return request;
+		};
+		$.ajax($.extend({url: url, dataType: "html", headers: {Accept: "text/html"}, xhr: makeRequest}, options)).done(function(html) {
+			var doc = new DOMParser().parseFromString(html, "text/html");
+			var stylesheet = $(doc).find("link[rel=stylesheet]").last().attr("href");
+			//Forms redirect once they're done, so use wherever we ended up
+			var finalURL = request.responseURL || url;
+			if (!doc.getElementById("player") || stylesheet !== $("link[rel=stylesheet]").last().attr("href")) {
+				window.location.href = finalURL;
+				return;
+			}
+			document.title = doc.title;
+			$(".navbar").replaceWith($(doc).find(".navbar"));
+			$(".content").html($(doc).find(".content").html());
+			if (push) {
+				history.pushState({pogo: true}, doc.title, finalURL);
+			}
+			window.scrollTo(0, 0);
+		}).fail(function() {
+			window.location.href = url;
+		});
+	};
+
+	//Whether or not a link can be loaded into the page
+	var canNavigate = function(link) {
+		return link.host === window.location.host &&
+			link.pathname.indexOf(base + "/") === 0 &&
+			link.pathname.indexOf(base + "/downloads/") !== 0 &&
+			link.pathname.indexOf(base + "/theme/") !== 0 &&
+			!link.target && !$(link).is("[download]") &&
+			($(link).attr("href") || "#").charAt(0) !== "#";
+	};
+
+	$(function() {
+		media = document.getElementById("player-media");
+		if (!media) {
+			return;
+		}
+		base = $("body").data("base") || "";
+		csrf = $("body").data("csrf");
+		//Translated by the page
+		strings = $("#player").data();
+
+		$(media).on("play pause", function() {
+			render();
+			if (media.paused) {
+				saveProgress();
+			}
+			if ("mediaSession" in navigator) {
+				navigator.mediaSession.playbackState = media.paused ? "paused" : "playing";
+			}
+			sessionStorage.setItem("pogo-playing", media.paused ? "" : "yes");
+		});
+		$(media).on("timeupdate durationchange", renderTime);
+		$(media).on("ended", function() {
+			var stop = sleepAtEnd;
+			if (stop) {
+				$("#player-sleep").val("0");
+				setSleepTimer(0);
+			}
+			next(true, !stop);
+		});
+		setInterval(function() {
+			if (!media.paused) {
+				saveProgress();
+			}
+		}, 15000);
+		window.addEventListener("pagehide", function() {
+			if (state.NowPlaying && media.currentTime > 0 && navigator.sendBeacon) {
+				navigator.sendBeacon(base + "/api/progress", new URLSearchParams({csrf: csrf, episode: state.NowPlaying.URL, position: media.currentTime}));
+			}
+		});
+
+		$("#player-toggle").on("click", togglePlaying);
+		$("#player-back").on("click", function() { skip(-skipBack); });
+		$("#player-forward").on("click", function() { skip(skipForward); });
+		$("#player-next").on("click", function() { next(false, true); });
+		$("#player-seek").on("input change", function() {
+			media.currentTime = Number($(this).val());
+		});
+		$("#player-speed").on("change", function() {
+			state.Speed = Number($(this).val());
+			media.playbackRate = state.Speed;
+			api("/api/player", {action: "speed", speed: state.Speed});
+		});
+		$("#player-sleep").on("change", function() {
+			setSleepTimer(Number($(this).val()));
+		});
+		$("#player-queue-toggle").on("click", function() {
+			$("#player-queue").toggle();
+		});
+		$("#player-queue").on("click", "button", function() {
+			var item = $(this).closest("li");
+			var episodeURL = item.data("episode"), index = Number(item.data("index"));
+			switch ($(this).data("queue")) {
+			case "play": play(episodeURL); break;
+			case "up": changeQueue("move", episodeURL, index - 1); break;
+			case "down": changeQueue("move", episodeURL, index + 1); break;
+			case "remove": changeQueue("remove", episodeURL); break;
+			}
+		});
+
+		//Finding things in a transcript
+		$(document).on("input keyup", ".transcript-filter", function() {
+			var query = $(this).val().toLowerCase();
+			$(this).next(".transcript").find(".cue").each(function() {
+				$(this).toggle(query === "" || $(this).text().toLowerCase().indexOf(query) >= 0);
+			});
+		});
+
+		//Buttons on pages like <button data-player="play" data-episode="...">
+		$(document).on("click", "[data-player]", function(e) {
+			e.preventDefault();
+			var episodeURL = $(this).data("episode");
+			switch ($(this).data("player")) {
+			case "play": play(episodeURL); break;
+			case "next": changeQueue("next", episodeURL); break;
+			case "add": changeQueue("add", episodeURL); break;
+			case "seek": play(episodeURL, Number($(this).data("time"))); break;
+			}
+		});
+
+		//Keyboard shortcuts (when not typing into something)
+		$(document).on("keydown", function(e) {
+			if ($(e.target).is("input, textarea, select, button") || e.ctrlKey || e.metaKey || e.altKey) {
+				return;
+			}
+			switch (e.which) {
+			case 32: togglePlaying(); break;
+			case 37: skip(-skipBack); break;
+			case 39: skip(skipForward); break;
+			case 78: next(false, true); break;
+			default: return;
+			}
+			e.preventDefault();
+		});
+
+		if ("mediaSession" in navigator) {
+			var handlers = {
+				play: function() { load(state.NowPlaying, true); },
+				pause: function() { media.pause(); },
+				seekbackward: function(details) { skip(-(details.seekOffset || skipBack)); },
+				seekforward: function(details) { skip(details.seekOffset || skipForward); },
+				seekto: function(details) { media.currentTime = details.seekTime; },
+				nexttrack: function() { next(false, true); }
+			};
+			$.each(handlers, function(action, handler) {
+				try {
+					navigator.mediaSession.setActionHandler(action, handler);
+				} catch (e) {}
+			});
+		}
+
+		//Load pages without interrupting playback
+		if (window.history && history.pushState && window.DOMParser) {
+			$(document).on("click", "a", function(e) {
+				if (e.which > 1 || e.ctrlKey || e.metaKey || e.shiftKey || e.altKey || !canNavigate(this)) {
+					return;
+				}
+				e.preventDefault();
+				navigate(this.href, {}, true);
+			});
+			$(document).on("submit", "form", function(e) {
+				var form = $(this);
+				var action = this.action || window.location.href;
+				var link = document.createElement("a");
+				link.href = action;
+				var method = (form.attr("method") || "GET").toUpperCase();
+				if (!canNavigate(link) || form.find("input[type=file]").length > 0) {
+					return;
+				}
+				e.preventDefault();
+				//serialize() leaves out the button that was clicked, which can be what says
+				//what the form does
+				var data = form.serializeArray();
+				var submitter = e.originalEvent && e.originalEvent.submitter;
+				if (submitter && submitter.name) {
+					data.push({name: submitter.name, value: submitter.value});
+				}
+				if (method === "POST") {
+					navigate(action, {type: "POST", data: $.param(data)}, true);
+				} else {
+					navigate(action.split("?")[0] + "?" + $.param(data), {}, true);
+				}
+			});
+			window.addEventListener("popstate", function(e) {
+				if (e.state && e.state.pogo) {
+					navigate(window.location.href, {}, false);
+				}
+			});
+			history.replaceState({pogo: true}, document.title, window.location.href);
+		}
+
+		api("/api/player", null, function(s) {
+			setState(s, false);
+			if (s.NowPlaying && sessionStorage.getItem("pogo-playing")) {
+				load(s.NowPlaying, true);
+			}
+		});
+	});
+})(jQuery);
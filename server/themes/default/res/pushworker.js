@@ -0,0 +1,16 @@
+//Shows Pogo's push notifications, and opens the page they're about when they're clicked. This
+//is served from /js/, so Pogo's home page is the folder above
+var home = self.registration.scope.replace(/js\/$/, "");
+
+self.addEventListener("push", function(event) {
+	var message = event.data ? event.data.json() : {Title: "Pogo", Body: "", Page: "home"};
+	event.waitUntil(self.registration.showNotification(message.Title, {
+		body: message.Body,
+		data: {page: home + message.Page}
+	}));
+});
+
+self.addEventListener("notificationclick", function(event) {
+	event.notification.close();
+	event.waitUntil(self.clients.openWindow(event.notification.data.page));
+});
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"github.com/programmingthomas/Pogo/media"
+	"html/template"
+	"net/http"
+	"net/url"
+)
+
+//How many search results to show
+const maxSearchResults = 200
+
+//A cue as the API gives it, with times in seconds
+type transcriptCue struct {
+	Start   float64
+	End     float64
+	Speaker string
+	Text    string
+}
+
+func toTranscriptCue(cue media.Cue) transcriptCue {
+	return transcriptCue{Start: cue.Start.Seconds(), End: cue.End.Seconds(), Speaker: cue.Speaker, Text: cue.Text}
+}
+
+//Gets the transcript of an episode (with times in seconds)
+func transcriptHandler(w http.ResponseWriter, r *http.Request) {
+	episodeURL := r.FormValue("episode")
+	if _, _, ok := findEpisode(episodeURL); !ok {
+		http.Error(w, "No such episode", http.StatusNotFound)
+		return
+	}
+	cues := PodCatcher.Transcript(episodeURL)
+	converted := make([]transcriptCue, len(cues))
+	for i, cue := range cues {
+		converted[i] = toTranscriptCue(cue)
+	}
+	writeJSON(w, converted)
+}
+
+//Somewhere in an episode that matched a search
+type searchResult struct {
+	Podcast   string
+	Episode   string
+	Title     string
+	Page      string
+	StartText string
+	Cue       transcriptCue
+}
+
+//Data for the search page
+type searchPage struct {
+	Query   string
+	Results []searchResult
+	More    bool
+}
+
+//Searches the transcripts of the podcasts that the current user is subscribed to
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := Users.Find(authFor(r).User)
+	data := searchPage{Query: r.FormValue("q"), Results: make([]searchResult, 0)}
+	matches := PodCatcher.SearchTranscripts(data.Query, user.IsSubscribed, maxSearchResults+1)
+	if len(matches) > maxSearchResults {
+		matches = matches[:maxSearchResults]
+		data.More = true
+	}
+	for _, match := range matches {
+		data.Results = append(data.Results, searchResult{
+			Podcast:   match.Podcast.Name,
+			Episode:   match.Episode.URL,
+			Title:     match.Episode.Title,
+			Page:      basePath(r) + "/episode/?episode=" + url.QueryEscape(match.Episode.URL),
+			StartText: match.Cue.StartText(),
+			Cue:       toTranscriptCue(match.Cue),
+		})
+	}
+	if !wantsHTML(r) {
+		writeJSON(w, data)
+		return
+	}
+	page := newPage(r, "Search - Pogo")
+	content := bytes.NewBufferString("")
+	pageTemplates(r).ExecuteTemplate(content, "search.html", data)
+	page.Content = template.HTML(content.String())
+	pageHandler(page, "index.html", w)
+}
@@ -0,0 +1,656 @@
+package server
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Number of PBKDF2 iterations used when hashing new passwords
+const passwordIterations = 210000
+
+//An API token that lets clients (like a mobile app) use Pogo without logging in. Only a
+//hash of the token is stored
+type APIToken struct {
+	Name    string
+	Hash    string
+	Created time.Time
+}
+
+//How far through an episode a user is
+type EpisodeState struct {
+	Played   bool
+	Position time.Duration
+	//Taken out of the inbox without being played
+	Dismissed bool
+}
+
+//Settings that each user can change for themselves
+type UserSettings struct {
+	HidePlayed    bool
+	Theme         string
+	PlaybackSpeed float64
+	//The locale's code, or empty to use whatever the browser asks for
+	Locale string
+	//Where to send emails about the user's podcasts, and which events they're told about by
+	//email and in their browsers (see catcher.EventTypes)
+	Email        string
+	NotifyEvents []string
+}
+
+//A user account (as stored in pogousers.json). Feeds and downloads are shared between
+//everyone, so a user only keeps track of which feeds they are subscribed to (by feed URL)
+//and what they have listened to (by episode URL)
+type User struct {
+	Name          string
+	PasswordHash  string
+	Admin         bool
+	APITokens     []APIToken
+	Subscriptions []string
+	Episodes      map[string]EpisodeState
+	Queue         []string
+	NowPlaying    string
+	Settings      UserSettings
+	//When the user last looked at their inbox, so that we can show what's new since then
+	InboxVisited time.Time
+	Playlists    []Playlist
+	//Folders and tags for subscriptions, by feed URL
+	Labels            map[string]FeedLabels
+	Webhooks          []Webhook
+	PushSubscriptions []PushSubscription
+}
+
+//Whether or not the user is subscribed to the feed with the given URL
+func (user User) IsSubscribed(feedURL string) bool {
+	for _, subscription := range user.Subscriptions {
+		if subscription == feedURL {
+			return true
+		}
+	}
+	return false
+}
+
+//Gets the user's progress through an episode
+func (user User) EpisodeState(episodeURL string) EpisodeState {
+	return user.Episodes[episodeURL]
+}
+
+//Copies a user so that it can be used without holding the store's mutex
+func (user User) clone() User {
+	copied := user
+	copied.APITokens = append([]APIToken{}, user.APITokens...)
+	copied.Subscriptions = append([]string(nil), user.Subscriptions...)
+	copied.Queue = append([]string{}, user.Queue...)
+	copied.Playlists = make([]Playlist, len(user.Playlists))
+	for i, playlist := range user.Playlists {
+		copied.Playlists[i] = playlist.clone()
+	}
+	copied.Settings.NotifyEvents = append([]string(nil), user.Settings.NotifyEvents...)
+	copied.Webhooks = make([]Webhook, len(user.Webhooks))
+	for i, webhook := range user.Webhooks {
+		webhook.Events = append([]string(nil), webhook.Events...)
+		copied.Webhooks[i] = webhook
+	}
+	copied.PushSubscriptions = append([]PushSubscription{}, user.PushSubscriptions...)
+	copied.Labels = make(map[string]FeedLabels, len(user.Labels))
+	for feedURL, labels := range user.Labels {
+		labels.Tags = append([]string{}, labels.Tags...)
+		copied.Labels[feedURL] = labels
+	}
+	copied.Episodes = make(map[string]EpisodeState, len(user.Episodes))
+	for episodeURL, state := range user.Episodes {
+		copied.Episodes[episodeURL] = state
+	}
+	return copied
+}
+
+//Whether or not the episode is in the user's queue
+func (user User) InQueue(episodeURL string) bool {
+	for _, queued := range user.Queue {
+		if queued == episodeURL {
+			return true
+		}
+	}
+	return false
+}
+
+//All of the user accounts, which are saved to a separate file from the catcher so that
+//pogo.json never contains password hashes
+type UserStore struct {
+	Users    []User
+	location string
+	mutex    sync.Mutex
+	//Set while there are changes waiting to be saved by saveLater
+	saveTimer *time.Timer
+}
+
+//How long changes that happen all the time (like how far through an episode someone is) wait
+//before they're saved, so that the file isn't rewritten every few seconds
+const userSaveDelay = time.Minute
+
+//Loads the user accounts from the given file. If the file doesn't exist the store will be
+//empty, which means that Pogo will ask for an admin account to be set up. A file that can't be
+//read is an error rather than an empty store, as otherwise anyone could set up a new admin
+func LoadUsers(location string) (*UserStore, error) {
+	store := &UserStore{location: location}
+	contents, err := ioutil.ReadFile(location)
+	if os.IsNotExist(err) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(contents, store); err != nil {
+		return nil, fmt.Errorf("%s can't be read: %v", location, err)
+	}
+	return store, nil
+}
+
+//Saves all of the user accounts. The caller must hold the mutex. They're written to a
+//temporary file first so that the file is never left half written
+func (store *UserStore) save() error {
+	b, err := json.MarshalIndent(store, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(store.location+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(store.location+".tmp", store.location)
+}
+
+//Saves the user accounts in a little while (userSaveDelay), along with anything else that
+//changes before then. The caller must hold the mutex
+func (store *UserStore) saveLater() {
+	if store.saveTimer != nil {
+		return
+	}
+	store.saveTimer = time.AfterFunc(userSaveDelay, func() {
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		store.saveTimer = nil
+		if err := store.save(); err != nil {
+			fmt.Println("Error saving users", err)
+		}
+	})
+}
+
+//Saves any changes that are waiting to be saved, like when Pogo is shutting down
+func (store *UserStore) Flush() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.saveTimer == nil {
+		return nil
+	}
+	store.saveTimer.Stop()
+	store.saveTimer = nil
+	return store.save()
+}
+
+//Gets the user accounts as they're saved, for backups
+func (store *UserStore) saved() ([]byte, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return json.MarshalIndent(store, "", "    ")
+}
+
+//Replaces every account with ones saved on this machine or another one, like when a backup is
+//restored
+func (store *UserStore) Restore(contents []byte) error {
+	var loaded UserStore
+	if err := json.Unmarshal(contents, &loaded); err != nil {
+		return err
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.Users = loaded.Users
+	return store.save()
+}
+
+//Whether or not any accounts have been created yet
+func (store *UserStore) Empty() bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return len(store.Users) == 0
+}
+
+//Gets a copy of the user with the given name
+func (store *UserStore) Find(name string) (User, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, user := range store.Users {
+		if user.Name == name {
+			return user.clone(), true
+		}
+	}
+	return User{}, false
+}
+
+//Creates a new account with the given password
+func (store *UserStore) Add(name, password string, admin bool) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("a user name is required")
+	}
+	if len(password) < 8 {
+		return errors.New("passwords must be at least 8 characters long")
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, user := range store.Users {
+		if user.Name == name {
+			return errors.New("that user name is already taken")
+		}
+	}
+	store.Users = append(store.Users, User{Name: name, PasswordHash: hash, Admin: admin,
+		Subscriptions: []string{}, Episodes: make(map[string]EpisodeState), Queue: []string{}})
+	return store.save()
+}
+
+//Deletes an account
+func (store *UserStore) Remove(name string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for i, user := range store.Users {
+		if user.Name == name {
+			store.Users = append(store.Users[:i], store.Users[i+1:]...)
+			return store.save()
+		}
+	}
+	return errors.New("no such user")
+}
+
+//Gets copies of all of the accounts
+func (store *UserStore) All() []User {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	users := make([]User, len(store.Users))
+	for i, user := range store.Users {
+		users[i] = user.clone()
+	}
+	return users
+}
+
+//Makes a change to a user and saves it
+func (store *UserStore) Update(name string, change func(user *User)) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	user, ok := store.find(name)
+	if !ok {
+		return errors.New("no such user")
+	}
+	change(user)
+	return store.save()
+}
+
+//Finds a user so that it can be changed. The caller must hold the mutex
+func (store *UserStore) find(name string) (*User, bool) {
+	for i := range store.Users {
+		if store.Users[i].Name == name {
+			if store.Users[i].Episodes == nil {
+				store.Users[i].Episodes = make(map[string]EpisodeState)
+			}
+			return &store.Users[i], true
+		}
+	}
+	return nil, false
+}
+
+//Subscribes a user to a feed
+func (store *UserStore) Subscribe(name, feedURL string) error {
+	return store.Update(name, func(user *User) {
+		if !user.IsSubscribed(feedURL) {
+			user.Subscriptions = append(user.Subscriptions, feedURL)
+		}
+	})
+}
+
+//Unsubscribes a user from a feed
+func (store *UserStore) Unsubscribe(name, feedURL string) error {
+	return store.Update(name, func(user *User) {
+		user.Subscriptions = without(user.Subscriptions, feedURL)
+		delete(user.Labels, feedURL)
+	})
+}
+
+//Puts one of a user's subscriptions in a folder and tags it. An empty folder and no tags
+//leaves it unorganised
+func (store *UserStore) SetLabels(name, feedURL string, labels FeedLabels) error {
+	return store.Update(name, func(user *User) {
+		if labels.Folder == "" && len(labels.Tags) == 0 {
+			delete(user.Labels, feedURL)
+			return
+		}
+		if user.Labels == nil {
+			user.Labels = make(map[string]FeedLabels)
+		}
+		user.Labels[feedURL] = labels
+	})
+}
+
+//Counts how many users are subscribed to a feed, so that it can be removed from the
+//catcher once nobody wants it any more
+func (store *UserStore) Subscribers(feedURL string) int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	count := 0
+	for _, user := range store.Users {
+		if user.IsSubscribed(feedURL) {
+			count++
+		}
+	}
+	return count
+}
+
+//Saves how far through an episode a user is
+func (store *UserStore) SetEpisodeState(name, episodeURL string, state EpisodeState) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	user, ok := store.find(name)
+	if !ok {
+		return errors.New("no such user")
+	}
+	user.Episodes[episodeURL] = state
+	//Players save their progress every few seconds
+	store.saveLater()
+	return nil
+}
+
+//Changes a user's state for several episodes at once
+func (store *UserStore) UpdateEpisodes(name string, episodeURLs []string, change func(state *EpisodeState)) error {
+	return store.Update(name, func(user *User) {
+		for _, episodeURL := range episodeURLs {
+			state := user.Episodes[episodeURL]
+			change(&state)
+			user.Episodes[episodeURL] = state
+		}
+	})
+}
+
+//Adds a playlist to a user's playlists, or replaces the playlist with the same ID
+func (store *UserStore) SavePlaylist(name string, playlist Playlist) error {
+	return store.Update(name, func(user *User) {
+		for i := range user.Playlists {
+			if user.Playlists[i].ID == playlist.ID {
+				user.Playlists[i] = playlist
+				return
+			}
+		}
+		user.Playlists = append(user.Playlists, playlist)
+	})
+}
+
+//Deletes one of a user's playlists
+func (store *UserStore) DeletePlaylist(name, id string) error {
+	return store.Update(name, func(user *User) {
+		for i := range user.Playlists {
+			if user.Playlists[i].ID == id {
+				user.Playlists = append(user.Playlists[:i], user.Playlists[i+1:]...)
+				return
+			}
+		}
+	})
+}
+
+//Adds a webhook for a user
+func (store *UserStore) AddWebhook(name string, webhook Webhook) error {
+	return store.Update(name, func(user *User) {
+		user.Webhooks = append(user.Webhooks, webhook)
+	})
+}
+
+//Removes one of a user's webhooks
+func (store *UserStore) RemoveWebhook(name, id string) error {
+	return store.Update(name, func(user *User) {
+		for i := range user.Webhooks {
+			if user.Webhooks[i].ID == id {
+				user.Webhooks = append(user.Webhooks[:i], user.Webhooks[i+1:]...)
+				return
+			}
+		}
+	})
+}
+
+//Adds a browser to send push notifications to, or updates its keys if it's already there
+func (store *UserStore) AddPushSubscription(name string, subscription PushSubscription) error {
+	return store.Update(name, func(user *User) {
+		for i := range user.PushSubscriptions {
+			if user.PushSubscriptions[i].Endpoint == subscription.Endpoint {
+				user.PushSubscriptions[i] = subscription
+				return
+			}
+		}
+		user.PushSubscriptions = append(user.PushSubscriptions, subscription)
+	})
+}
+
+//Stops sending push notifications to a browser
+func (store *UserStore) RemovePushSubscription(name, endpoint string) error {
+	return store.Update(name, func(user *User) {
+		for i := range user.PushSubscriptions {
+			if user.PushSubscriptions[i].Endpoint == endpoint {
+				user.PushSubscriptions = append(user.PushSubscriptions[:i], user.PushSubscriptions[i+1:]...)
+				return
+			}
+		}
+	})
+}
+
+//Adds an episode to the end of a user's queue
+func (store *UserStore) Enqueue(name, episodeURL string) error {
+	return store.Update(name, func(user *User) {
+		if !user.InQueue(episodeURL) {
+			user.Queue = append(user.Queue, episodeURL)
+		}
+	})
+}
+
+//Removes an episode from a user's queue
+func (store *UserStore) Dequeue(name, episodeURL string) error {
+	return store.Update(name, func(user *User) {
+		user.Queue = without(user.Queue, episodeURL)
+	})
+}
+
+//Puts an episode at the front of a user's queue so that it plays next
+func (store *UserStore) PlayNext(name, episodeURL string) error {
+	return store.Update(name, func(user *User) {
+		user.Queue = append([]string{episodeURL}, without(user.Queue, episodeURL)...)
+	})
+}
+
+//Moves an episode to a different position in a user's queue
+func (store *UserStore) MoveInQueue(name, episodeURL string, index int) error {
+	return store.Update(name, func(user *User) {
+		if !user.InQueue(episodeURL) {
+			return
+		}
+		queue := without(user.Queue, episodeURL)
+		if index < 0 {
+			index = 0
+		}
+		if index > len(queue) {
+			index = len(queue)
+		}
+		queue = append(queue[:index], append([]string{episodeURL}, queue[index:]...)...)
+		user.Queue = queue
+	})
+}
+
+//Starts playing an episode (taking it out of the queue if it was in there)
+func (store *UserStore) Play(name, episodeURL string) error {
+	return store.Update(name, func(user *User) {
+		user.NowPlaying = episodeURL
+		user.Queue = without(user.Queue, episodeURL)
+	})
+}
+
+//Moves on to the next episode in a user's queue. If finished is true the episode that was
+//playing is marked as played
+func (store *UserStore) PlayNextInQueue(name string, finished bool) error {
+	return store.Update(name, func(user *User) {
+		if finished && user.NowPlaying != "" {
+			user.Episodes[user.NowPlaying] = EpisodeState{Played: true}
+		}
+		user.NowPlaying = ""
+		if len(user.Queue) > 0 {
+			user.NowPlaying = user.Queue[0]
+			user.Queue = user.Queue[1:]
+		}
+	})
+}
+
+//Accounts created before Pogo supported more than one user have no subscription list, so
+//they get subscribed to every feed the catcher already has
+func (store *UserStore) MigrateSubscriptions(feedURLs []string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	changed := false
+	for i, user := range store.Users {
+		if user.Subscriptions == nil {
+			store.Users[i].Subscriptions = append([]string{}, feedURLs...)
+			changed = true
+		}
+	}
+	if changed {
+		store.save()
+	}
+}
+
+//Gets a copy of a list of strings without the given one
+func without(list []string, unwanted string) []string {
+	remaining := make([]string, 0, len(list))
+	for _, item := range list {
+		if item != unwanted {
+			remaining = append(remaining, item)
+		}
+	}
+	return remaining
+}
+
+//Checks a user name and password
+func (store *UserStore) Authenticate(name, password string) bool {
+	user, ok := store.Find(name)
+	if !ok {
+		//Still hash something so that it isn't obvious which user names exist
+		checkPassword(password, "")
+		return false
+	}
+	return checkPassword(password, user.PasswordHash)
+}
+
+//Creates a new API token for the user. The token is returned so that it can be shown to
+//the user, but only its hash is kept
+func (store *UserStore) NewAPIToken(userName, tokenName string) (string, error) {
+	token := randomToken()
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for i, user := range store.Users {
+		if user.Name == userName {
+			if tokenName == "" {
+				tokenName = fmt.Sprintf("Token %d", len(user.APITokens)+1)
+			}
+			store.Users[i].APITokens = append(user.APITokens, APIToken{Name: tokenName, Hash: hashToken(token), Created: time.Now()})
+			return token, store.save()
+		}
+	}
+	return "", errors.New("no such user")
+}
+
+//Removes one of a user's API tokens
+func (store *UserStore) RevokeAPIToken(userName, tokenHash string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for i, user := range store.Users {
+		if user.Name == userName {
+			tokens := make([]APIToken, 0, len(user.APITokens))
+			for _, token := range user.APITokens {
+				if token.Hash != tokenHash {
+					tokens = append(tokens, token)
+				}
+			}
+			store.Users[i].APITokens = tokens
+			return store.save()
+		}
+	}
+	return errors.New("no such user")
+}
+
+//Finds the user that an API token belongs to
+func (store *UserStore) UserForToken(token string) (string, bool) {
+	hash := hashToken(token)
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, user := range store.Users {
+		for _, apiToken := range user.APITokens {
+			if subtle.ConstantTimeCompare([]byte(apiToken.Hash), []byte(hash)) == 1 {
+				return user.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+//Hashes a password with PBKDF2-SHA256 and a random salt. The result looks like
+//pbkdf2-sha256$iterations$salt$hash
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, 32)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+//Checks a password against a hash made by hashPassword
+func checkPassword(password, hash string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		parts = []string{"pbkdf2-sha256", strconv.Itoa(passwordIterations), "", ""}
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, saltErr := base64.RawStdEncoding.DecodeString(parts[2])
+	expected, keyErr := base64.RawStdEncoding.DecodeString(parts[3])
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, 32)
+	if err != nil || saltErr != nil || keyErr != nil || len(expected) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+//API tokens are long and random, so a plain SHA-256 is enough to store them safely
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+//Generates a random string suitable for session IDs, CSRF tokens and API tokens
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadUsers(t *testing.T) {
+	folder := t.TempDir()
+	tests := []struct {
+		name     string
+		contents string
+		users    int
+		fails    bool
+	}{
+		{"missing", "", 0, false},
+		{"empty store", `{"Users": []}`, 0, false},
+		{"one user", `{"Users": [{"Name": "a", "Admin": true}]}`, 1, false},
+		{"cut off halfway through being written", `{"Users": [{"Name": "a", "Adm`, 0, true},
+		{"empty file", " ", 0, true},
+	}
+	for i, test := range tests {
+		location := filepath.Join(folder, test.name+".json")
+		if i > 0 {
+			if err := os.WriteFile(location, []byte(test.contents), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		store, err := LoadUsers(location)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: LoadUsers worked, want an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: LoadUsers failed: %v", test.name, err)
+		} else if len(store.Users) != test.users {
+			t.Errorf("%s: got %d users, want %d", test.name, len(store.Users), test.users)
+		}
+	}
+}
+
+func TestSaveUsers(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "pogousers.json")
+	store := &UserStore{location: location, Users: []User{{Name: "a", Admin: true}}}
+	if err := store.save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(location + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("the temporary file was left behind")
+	}
+	loaded, err := LoadUsers(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Users) != 1 || loaded.Users[0].Name != "a" || !loaded.Users[0].Admin {
+		t.Errorf("loaded %+v, want the admin a", loaded.Users)
+	}
+}
+
+func TestSetEpisodeStateSavesLater(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "pogousers.json")
+	store := &UserStore{location: location, Users: []User{{Name: "a", Admin: true}}}
+	if err := store.save(); err != nil {
+		t.Fatal(err)
+	}
+	state := EpisodeState{Position: 90 * time.Second}
+	if err := store.SetEpisodeState("a", "https://example.com/1.mp3", state); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetEpisodeState("nobody", "https://example.com/1.mp3", state); err == nil {
+		t.Errorf("SetEpisodeState worked for a user that doesn't exist")
+	}
+	//It's kept in memory straight away...
+	if user, _ := store.Find("a"); user.EpisodeState("https://example.com/1.mp3") != state {
+		t.Errorf("the progress wasn't kept")
+	}
+	//...but isn't saved until later
+	saved, err := LoadUsers(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Users[0].Episodes) != 0 {
+		t.Errorf("the progress was saved straight away")
+	}
+	if err = store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err = LoadUsers(location); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Users[0].Episodes["https://example.com/1.mp3"] != state {
+		t.Errorf("Flush didn't save the progress")
+	}
+}
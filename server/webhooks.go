@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/programmingthomas/Pogo/catcher"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+//A URL that events about a user's podcasts are POSTed to as JSON. Each request is signed
+//with the secret (an HMAC-SHA256 of the body in the X-Pogo-Signature header) so that whoever
+//receives it can check it came from us
+type Webhook struct {
+	ID     string
+	URL    string
+	Secret string
+	//Empty for every event
+	Events  []string
+	Created time.Time
+}
+
+//How long to wait before trying a webhook again. Webhooks that are still failing after the last
+//one are given up on
+var webhookRetries = []time.Duration{10 * time.Second, time.Minute, 10 * time.Minute}
+
+var webhookClient = &http.Client{Timeout: 15 * time.Second}
+
+//Webhooks set up by people who aren't admins can only go to the internet, so that they can't be
+//used to find out what's on this machine or the network it's on. The address is checked as
+//it's connected to, so a host name can't point somewhere else by then (and neither can a
+//redirect)
+var publicWebhookClient = &http.Client{
+	Timeout: 15 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 15 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if !publicIP(net.ParseIP(host)) {
+					return errPrivateWebhook
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+var errPrivateWebhook = errors.New("webhooks can only be sent to public addresses")
+
+//Whether or not an address is on the internet, rather than this machine or a private network
+func publicIP(ip net.IP) bool {
+	return ip != nil && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast()
+}
+
+//Whether or not a webhook wants to hear about an event
+func (webhook Webhook) wants(eventType string) bool {
+	return len(webhook.Events) == 0 || contains(webhook.Events, eventType) || eventType == eventTest
+}
+
+//Gets the names of the events a webhook is sent, for the account page
+func (webhook Webhook) EventNames() []string {
+	names := make([]string, 0, len(webhook.Events))
+	for _, eventType := range webhook.Events {
+		names = append(names, eventNames[eventType])
+	}
+	return names
+}
+
+//POSTs events to people's webhooks
+type webhookNotifier struct{}
+
+func (webhookNotifier) Notify(event catcher.Event) {
+	if !catcher.Notifiable(event.Type) {
+		return
+	}
+	for _, user := range subscribersOf(event.FeedURL) {
+		for _, webhook := range user.Webhooks {
+			if webhook.wants(event.Type) {
+				go deliverWebhook(webhook, event, user.Admin)
+			}
+		}
+	}
+}
+
+//Sends an event to a webhook, trying again later if it fails in a way that might not happen
+//next time (it couldn't be reached or had a server error). Only admins' webhooks can go to
+//private addresses
+func deliverWebhook(webhook Webhook, event catcher.Event, admin bool) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		retry, err := postWebhook(webhook, event.Type, body, admin)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= len(webhookRetries) {
+			fmt.Println("Giving up on webhook", webhook.URL, err)
+			return err
+		}
+		fmt.Println("Error sending webhook", webhook.URL, err, "trying again in", webhookRetries[attempt])
+		time.Sleep(webhookRetries[attempt])
+	}
+}
+
+//POSTs an event to a webhook once. Also says whether it's worth trying again if it failed
+func postWebhook(webhook Webhook, eventType string, body []byte, admin bool) (bool, error) {
+	request, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write(body)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "Pogo")
+	request.Header.Set("X-Pogo-Event", eventType)
+	request.Header.Set("X-Pogo-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	client := webhookClient
+	if !admin {
+		client = publicWebhookClient
+	}
+	resp, err := client.Do(request)
+	if errors.Is(err, errPrivateWebhook) {
+		return false, errPrivateWebhook
+	} else if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("unexpected status %s", resp.Status)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+//Checks that a webhook's URL is somewhere we can POST to. Only admins can use private
+//addresses (like other apps on this machine)
+func checkWebhookURL(raw string, admin bool) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("webhooks need an http or https URL")
+	}
+	if admin {
+		return nil
+	}
+	ips := []net.IP{net.ParseIP(parsed.Hostname())}
+	if ips[0] == nil {
+		if ips, err = net.LookupIP(parsed.Hostname()); err != nil {
+			return fmt.Errorf("can't find %s", parsed.Hostname())
+		}
+	}
+	for _, ip := range ips {
+		if !publicIP(ip) {
+			return errPrivateWebhook
+		}
+	}
+	return nil
+}
+
+//Lists the current user's webhooks, or changes them. The actions are add (with a url and the
+//events to send as event parameters, or none for all of them), remove and test (which sends
+//a test event straight away and says whether it worked), and the last two take an id. Only
+//admins can add webhooks that go to private addresses
+func webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	name := authFor(r).User
+	if r.Method == "POST" {
+		user, _ := Users.Find(name)
+		var webhook Webhook
+		action := r.FormValue("action")
+		if action != "add" {
+			found := false
+			for _, existing := range user.Webhooks {
+				if existing.ID == r.FormValue("id") {
+					webhook, found = existing, true
+				}
+			}
+			if !found {
+				http.Error(w, "No such webhook", http.StatusNotFound)
+				return
+			}
+		}
+		var err error
+		switch action {
+		case "add":
+			webhook = Webhook{ID: newID(), URL: r.FormValue("url"), Secret: randomToken(), Events: parseEvents(r), Created: time.Now()}
+			if err = checkWebhookURL(webhook.URL, user.Admin); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = Users.AddWebhook(name, webhook)
+		case "remove":
+			err = Users.RemoveWebhook(name, webhook.ID)
+		case "test":
+			body, _ := json.Marshal(catcher.Event{Type: eventTest, Time: time.Now(), Podcast: "Pogo"})
+			if _, err = postWebhook(webhook, eventTest, body, user.Admin); err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+		default:
+			http.Error(w, "Unknown action", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	user, _ := Users.Find(name)
+	respond(w, r, user.Webhooks)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:192.168.1.1", false},
+	}
+	for _, test := range tests {
+		if got := publicIP(net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("publicIP(%s) = %v, want %v", test.ip, got, test.want)
+		}
+	}
+}
+
+func TestCheckWebhookURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		admin bool
+		ok    bool
+	}{
+		{"https://8.8.8.8/hook", false, true},
+		{"http://[2001:4860:4860::8888]:8080/hook", false, true},
+		{"ftp://8.8.8.8/hook", false, false},
+		{"https:///hook", false, false},
+		{"not a url", false, false},
+		{"http://127.0.0.1:8080/hook", false, false},
+		{"http://[::1]/hook", false, false},
+		{"http://192.168.1.10/hook", false, false},
+		{"http://169.254.169.254/latest/meta-data", false, false},
+		{"http://localhost/hook", false, false},
+		//Admins can send webhooks to other apps on their own network
+		{"http://192.168.1.10/hook", true, true},
+		{"http://localhost:8123/api/webhook/pogo", true, true},
+		{"ftp://192.168.1.10/hook", true, false},
+	}
+	for _, test := range tests {
+		err := checkWebhookURL(test.url, test.admin)
+		if (err == nil) != test.ok {
+			t.Errorf("checkWebhookURL(%q, admin %v) = %v, want ok %v", test.url, test.admin, err, test.ok)
+		}
+	}
+}
+
+func TestPostWebhookToPrivateAddress(t *testing.T) {
+	received := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+	}))
+	defer server.Close()
+	webhook := Webhook{URL: server.URL, Secret: "secret"}
+	retry, err := postWebhook(webhook, eventTest, []byte("{}"), false)
+	if err != errPrivateWebhook || retry {
+		t.Errorf("postWebhook to %s = %v, %v, want errPrivateWebhook without retrying", server.URL, retry, err)
+	}
+	if received != 0 {
+		t.Errorf("the webhook was sent to a private address")
+	}
+	if _, err = postWebhook(webhook, eventTest, []byte("{}"), true); err != nil || received != 1 {
+		t.Errorf("admin's webhook wasn't sent: %v", err)
+	}
+}